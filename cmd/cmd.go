@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"go.uber.org/zap"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 
@@ -14,9 +13,10 @@ import (
 
 type stringArgsArray []string
 type outputVideo struct {
-	video    VideoUrl
-	fileName string
-	format   string
+	video      VideoUrl
+	output     *OutputTemplate
+	videoUtils *VideoUtils
+	format     string
 }
 
 func (dua *stringArgsArray) String() string {
@@ -39,59 +39,141 @@ func getAsyncData(async *Async, warnPrefix string) interface{} {
 	validateAsync(err, warn, warnPrefix)
 	return i
 }
-func validateFileName(fileName string) string {
-	notAllowCh := []string{`\`, `/`, `:`, `|`, `?`, `"`, `*`, `<`, `>`}
-	for _, ch := range notAllowCh {
-		fileName = strings.Replace(fileName, ch, "", -1)
-	}
-	return fileName
-}
-func downloadBestAndMerge(url VideoUrl, videoUtils *VideoUtils, outputFormat string) *Async {
-	async, err := videoUtils.DownloadBestAndMerge(url, -1, outputFormat, true)
+func downloadBestAndMerge(url VideoUrl, videoUtils *VideoUtils, outputFormat string, languages []string, muxLanguages bool) *Async {
+	async, err := videoUtils.DownloadBestAndMergeLanguages(url, -1, outputFormat, true, languages, muxLanguages)
 	if err != nil {
 		panic(err)
 	} else {
 		return async
 	}
 }
-func liveDownload(l *zap.Logger, videos <-chan outputVideo, videoUtils *VideoUtils, wg *sync.WaitGroup) {
+func liveDownload(l *zap.Logger, videos <-chan outputVideo, archive *DownloadArchive, wg *sync.WaitGroup) {
 	defer wg.Done()
-	var filesName []string
+	var liveVideos []outputVideo
 	maxSizeInKb := 9.8 * 1024 * 1024
 	sizeSplitThreshold := 9.7 * 1024 * 1024
 	maxTimeInSec := 5.5 * 60 * 60
 	timeSplitThreshold := 5.4 * 60 * 60
 	var downloadAsync []*Async
 	for video := range videos {
-		async, err := videoUtils.LiveDownload(&Logger{Logger: l.With(zap.Any("video", video))}, video.video, GetBestFormat(video.video.Formats, true, true), video.format, int(maxSizeInKb), int(sizeSplitThreshold), int(maxTimeInSec), int(timeSplitThreshold), nil, nil)
+		videoUtils := video.videoUtils
+		async, err := videoUtils.LiveDownload(&Logger{Logger: l.With(zap.Any("video", video.video))}, video.video, videoUtils.GetBestFormat(video.video.Formats, true, true), video.format, int(maxSizeInKb), int(sizeSplitThreshold), int(maxTimeInSec), int(timeSplitThreshold), nil, nil)
 		if err != nil {
 			fmt.Println(err)
 		} else {
+			if archive != nil {
+				async = archive.ArchiveOnFinish(video.video, async)
+			}
 			downloadAsync = append(downloadAsync, async)
-			filesName = append(filesName, video.fileName)
+			liveVideos = append(liveVideos, video)
 		}
 	}
 	for i, s := range downloadAsync {
-		output := getAsyncData(s, filesName[i]).(string)
-		os.Rename(output, filesName[i]+filepath.Ext(output))
+		video := liveVideos[i]
+		report := getAsyncData(s, video.video.Name).(*LiveReport)
+		for _, part := range report.Parts {
+			if _, err := video.output.Move(video.video, part.FileName); err != nil {
+				fmt.Println(err)
+			}
+		}
 	}
 }
+
+// createExtractor create fallback extractor from extractors in the format name or name=path.
+func createExtractor(l *zap.Logger, extractors []string) Extractor {
+	if len(extractors) == 0 {
+		extractors = []string{YtDlpExtractor, YoutubeDlExtractor}
+	}
+	fallback := &FallbackExtractor{}
+	for _, extractor := range extractors {
+		name, path := extractor, ""
+		if i := strings.Index(extractor, "="); i != -1 {
+			name, path = extractor[:i], extractor[i+1:]
+		}
+		e, err := CreateExtractor(name, path)
+		if err != nil {
+			l.Warn("Extractor is not available", zap.String("extractor", extractor), zap.Error(err))
+		}
+		if e != nil && e.Info().Available {
+			fallback.Extractors = append(fallback.Extractors, e)
+		}
+	}
+	if len(fallback.Extractors) == 0 {
+		panic("no extractor is available")
+	}
+	return fallback
+}
 func main() {
 	var downloads stringArgsArray
 	var directories stringArgsArray
 	var outputFormat stringArgsArray
+	var languages stringArgsArray
+	var extractors stringArgsArray
 	flag.Var(&downloads, "d", "url to download")
 	flag.Var(&directories, "n", "directories names")
 	flag.Var(&outputFormat, "f", "output file format")
+	flag.Var(&languages, "l", "preferred audio language")
+	flag.Var(&extractors, "x", "extractor to use (youtube-dl or yt-dlp) optionally with its path as name=path, later extractors are used as fallback")
+	profileName := flag.String("p", "", "device compatibility profile ("+strings.Join(CompatibilityProfilesNames(), ", ")+")")
+	formatSelector := flag.String("s", "", "format selector like bestvideo[height<=1080]+bestaudio/best")
+	ranking := flag.String("r", "", "formats ranking criteria order separated by comma (resolution,fps,hdr,codec,bitrate,container,protocol)")
+	muxLanguages := flag.Bool("m", false, "merge the audio of every preferred language as a separate track")
+	archivePath := flag.String("a", "", "download archive file, videos in it are skipped and downloaded videos are added to it")
+	force := flag.Bool("force", false, "download videos that are in the download archive")
+	template := flag.String("o", DefaultOutputTemplate, "output file name template under the directory like {uploader}/{upload_date} - {title} [{id}].{ext}")
 	flag.Parse()
 	l, err := zap.NewProduction(zap.WithCaller(false))
 	if err != nil {
 		panic(err)
 	}
-	youtube := CreateYoutubeDlWrapper()
+	var formatRanking *FormatRanking
+	if *ranking != "" {
+		formatRanking = &FormatRanking{}
+		*formatRanking = DefaultFormatRanking
+		formatRanking.Criteria, err = ParseRankCriteria(*ranking)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var selector *FormatSelector
+	if *formatSelector != "" {
+		selector, err = ParseFormatSelector(*formatSelector)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var profile *CompatibilityProfile
+	if *profileName != "" {
+		p, err := GetCompatibilityProfile(*profileName)
+		if err != nil {
+			panic(err)
+		}
+		profile = &p
+	}
+	var archive *DownloadArchive
+	if *archivePath != "" {
+		archive, err = OpenDownloadArchive(*archivePath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	youtube := createExtractor(l, extractors)
 	ffmpeg := CreateFfmpegWrapper(-1, false)
 	curl := CreateCurlWrapper(3)
-	videoUtils := VideoUtils{Youtube: &youtube, Ffmpeg: &ffmpeg, Curl: &curl}
+	outputs := make([]*OutputTemplate, 0, len(directories))
+	// Every directory has its own utils so the files are downloaded in the directory and only renamed when they finish.
+	directoriesUtils := make([]*VideoUtils, 0, len(directories))
+	for _, directory := range directories {
+		output, err := ParseOutputTemplate(directory, *template)
+		if err != nil {
+			panic(err)
+		}
+		if err = os.MkdirAll(directory, 0755); err != nil {
+			panic(err)
+		}
+		outputs = append(outputs, output)
+		directoriesUtils = append(directoriesUtils, &VideoUtils{Extractor: youtube, Ffmpeg: &ffmpeg, Curl: &curl, Ranking: formatRanking, OutputDir: directory})
+	}
 	var pendingUrlAsync []*Async
 	liveDownChan := make(chan outputVideo)
 	var wg sync.WaitGroup
@@ -108,38 +190,70 @@ func main() {
 	var pendingDownloadNames []string
 	var pendingLiveAsync []*Async
 	var pendingLiveNames []string
-	go liveDownload(l, liveDownChan, &videoUtils, &wg)
+	go liveDownload(l, liveDownChan, archive, &wg)
 	for i, a := range pendingUrlAsync {
-		urls := getAsyncData(a, downloads[i]).([]VideoUrl)
+		urlsI, err, warn := a.Get()
+		urls, _ := urlsI.([]VideoUrl)
+		if _, isNotStarted := err.(*LiveNotStartedError); isNotStarted && len(urls) == 0 {
+			// The extractor does not return any data about lives that did not start.
+			urls = append(urls, VideoUrl{Name: downloads[i], WebPageURL: downloads[i], IsUpcoming: true})
+			validateAsync(nil, warn, downloads[i])
+		} else {
+			validateAsync(err, warn, downloads[i])
+		}
+		videoUtils := directoriesUtils[i]
 		for _, url := range urls {
-			fileName := directories[i] + string(os.PathSeparator) + validateFileName(url.Name)
+			if archive != nil && !*force && archive.ContainsVideo(url) {
+				fmt.Println(url.Name + ": already in the download archive")
+				continue
+			}
+			if url.IsUpcoming {
+				as, err := videoUtils.WaitForLive(url.WebPageURL, 60, 15*60)
+				if err != nil {
+					panic(err)
+				}
+				url = getAsyncData(as, url.Name).(VideoUrl)
+			}
 			if url.IsLive {
-				liveDownChan <- outputVideo{video: url, fileName: fileName, format: outputFormat[i]}
-				as, err := videoUtils.DownloadLiveUntilNow(url, GetBestFormat(url.Formats, true, true), outputFormat[i])
+				liveDownChan <- outputVideo{video: url, output: outputs[i], videoUtils: videoUtils, format: outputFormat[i]}
+				as, err := videoUtils.DownloadLiveUntilNow(url, videoUtils.GetBestFormat(url.Formats, true, true), outputFormat[i])
 				if err != nil {
 					panic(err)
 				}
-				pendingLiveAsync = append(pendingLiveAsync, as)
-				pendingLiveNames = append(pendingLiveNames, fileName)
+				pendingLiveAsync = append(pendingLiveAsync, outputs[i].OutputOnFinish(url, as))
+				pendingLiveNames = append(pendingLiveNames, url.Name)
 			} else {
-				as := downloadBestAndMerge(url, &videoUtils, outputFormat[i])
+				var as *Async
+				if selector != nil {
+					as, err = videoUtils.DownloadSelector(url, selector, -1, outputFormat[i])
+					if err != nil {
+						panic(err)
+					}
+				} else if profile != nil {
+					as, err = videoUtils.DownloadProfile(url, *profile, -1)
+					if err != nil {
+						panic(err)
+					}
+				} else {
+					as = downloadBestAndMerge(url, videoUtils, outputFormat[i], languages, *muxLanguages)
+				}
+				as = outputs[i].OutputOnFinish(url, as)
+				if archive != nil {
+					as = archive.ArchiveOnFinish(url, as)
+				}
 				pendingDownloadAsync = append(pendingDownloadAsync, as)
-				pendingDownloadNames = append(pendingDownloadNames, fileName)
+				pendingDownloadNames = append(pendingDownloadNames, url.Name)
 			}
 		}
 	}
 	for i, a := range pendingLiveAsync {
-		output, err, warn := a.Get()
-		if err != nil {
-			if _, ok := err.(*UnsupportedSeekError); !ok {
-				validateAsync(err, warn, pendingLiveNames[i])
-			}
+		_, err, warn := a.Get()
+		if _, ok := err.(*UnsupportedSeekError); !ok {
+			validateAsync(err, warn, pendingLiveNames[i])
 		}
-		os.Rename(output.(string), pendingLiveNames[i]+filepath.Ext(output.(string)))
 	}
 	for i, a := range pendingDownloadAsync {
-		output := getAsyncData(a, pendingDownloadNames[i]).(string)
-		os.Rename(output, pendingDownloadNames[i]+filepath.Ext(output))
+		getAsyncData(a, pendingDownloadNames[i])
 	}
 	close(liveDownChan)
 	wg.Wait()
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/samitc/vigoler/2/vigoler"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -15,6 +16,31 @@ func (l *logger) withVideo(vid *video) *zap.Logger {
 func (l *logger) logError(message string, err error) {
 	l.logger.Error(message, zap.Error(err))
 }
+func (l *logger) extractorInfo(info vigoler.ExtractorInfo, err error) {
+	if err != nil {
+		l.logger.Error("Extractor is not available", zap.Any("extractor", info), zap.Error(err))
+	} else {
+		l.logger.Info("Extractor detected", zap.Any("extractor", info))
+	}
+}
+func (l *logger) rollbackExtractor(status vigoler.ManagedExtractorStatus) {
+	l.logger.Info("Extractor rolled back", zap.Any("extractor", status))
+}
+func (l *logger) rollbackExtractorError(name string, err error) {
+	l.logger.Error("Error on extractor rollback", zap.String("extractor", name), zap.Error(err))
+}
+func (l *logger) newSubscription(sub *subscription) {
+	l.logger.Info("New subscription", zap.String("id", sub.ID), zap.Any("options", sub.subscriptionOptions))
+}
+func (l *logger) deleteSubscription(sub *subscription) {
+	l.logger.Info("Delete subscription", zap.String("id", sub.ID), zap.String("url", sub.URL))
+}
+func (l *logger) subscriptionError(sub *subscription, url string, err error) {
+	l.logger.Error("Error in subscription", zap.String("id", sub.ID), zap.String("url", url), zap.Error(err))
+}
+func (l *logger) saveSubscriptionsError(path string, err error) {
+	l.logger.Error("Error on save subscriptions", zap.String("path", path), zap.Error(err))
+}
 func (l *logger) deleteVideo(vid *video) {
 	l.logger.Info("Delete video", zap.Any("video", vid))
 }
@@ -55,6 +81,15 @@ func (l *logger) errorInVideoCreate(url string, err error) {
 func (l *logger) downloadVideoError(vid *video, downloadType string, err error) {
 	l.logger.Error("Error while downloading video", zap.Any("video", vid), zap.String("type", downloadType), zap.Error(err))
 }
+func (l *logger) waitForLive(vid *video) {
+	l.logger.Info("Wait for live to start", zap.Any("video", vid))
+}
+func (l *logger) removeLivePart(vid *video) {
+	l.logger.Info("Remove live part because of retention", zap.Any("video", vid))
+}
+func (l *logger) pinLiveParts(vid *video, windowInSec int) {
+	l.logger.Info("Pin live parts", zap.Any("video", vid), zap.Int("window", windowInSec))
+}
 func (l *logger) startDownloadVideo(vid *video) {
 	l.logger.Info("Start downloading video", zap.Any("video", vid))
 }
@@ -62,6 +97,9 @@ func (v video) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("ID", v.ID)
 	enc.AddString("name", v.Name)
 	enc.AddBool("is_live", v.IsLive)
+	if v.Status != "" {
+		enc.AddString("status", v.Status)
+	}
 	if v.Ids != nil {
 		err := enc.AddArray("ids", stringArray(v.Ids))
 		if err != nil {
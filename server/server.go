@@ -3,13 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -31,14 +35,27 @@ var (
 	maxTime = time.Unix(1<<63-1-unixToInternal, 999999999)
 )
 var (
-	liveFormat = os.Getenv("VIGOLER_LIVE_FORMAT")
+	liveFormat     = os.Getenv("VIGOLER_LIVE_FORMAT")
+	audioLanguages = splitEnvList(os.Getenv("VIGOLER_AUDIO_LANGUAGES"))
+	mergeLanguages = strings.ToLower(os.Getenv("VIGOLER_MERGE_LANGUAGES")) == "true"
 )
 
+const statusWaitingForLive = "waiting_for_live"
+
 type video struct {
-	ID         string   `json:"id"`
-	Name       string   `json:"name"`
-	IsLive     bool     `json:"is_live"`
-	Ids        []string `json:"ids,omitempty"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	IsLive      bool       `json:"is_live"`
+	IsUpcoming  bool       `json:"is_upcoming,omitempty"`
+	ReleaseTime *time.Time `json:"release_time,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	Ids         []string   `json:"ids,omitempty"`
+	Pinned      bool       `json:"pinned,omitempty"`
+	// PlaylistIndex and PlaylistTitle are set for videos that were listed from playlist.
+	PlaylistIndex int    `json:"playlist_index,omitempty"`
+	PlaylistTitle string `json:"playlist_title,omitempty"`
+	// Gaps is the missing parts of the live recording.
+	Gaps       []vigoler.LiveGap `json:"gaps,omitempty"`
 	ext        string
 	parentID   string
 	videoURL   vigoler.VideoUrl
@@ -46,10 +63,26 @@ type video struct {
 	updateTime time.Time
 	isLogged   bool
 	fileName   string
+	// placed is true when the output template moved the file of the video to its name under the output root.
+	placed bool
+	// starting is true while the download of the video is started without videosMutex.
+	starting  bool
+	liveParts *vigoler.LiveParts
+	// playlistEntry is set until the video is resolved.
+	playlistEntry  *vigoler.PlaylistEntry
+	playlistFilter vigoler.PlaylistFilter
 }
 
+// videosMutex guard videosMap and the fields of its videos. It is not held while waiting for downloads or extractors.
+var videosMutex sync.Mutex
 var videosMap map[string]*video
 var videoUtils vigoler.VideoUtils
+var extractors []vigoler.Extractor
+var cache *vigoler.CachedExtractor
+var archive *vigoler.DownloadArchive
+
+// outputTemplate name the downloaded files, nil if the files keep their temporary names.
+var outputTemplate *vigoler.OutputTemplate
 var supportLive = strings.ToLower(os.Getenv("VIGOLER_SUPPORT_LIVE")) == "true"
 var log = createLogger()
 
@@ -61,7 +94,36 @@ func createLogger() logger {
 	return logger{logger: l}
 }
 func deleteVideo(videosMap map[string]*video, id string, v *video) {
+	videosMutex.Lock()
+	removeVideo(videosMap, id, v)
+	videosMutex.Unlock()
+	stopVideo(v)
+}
+
+// removeVideo remove the video from videosMap and from its parent, videosMutex must be held.
+func removeVideo(videosMap map[string]*video, id string, v *video) {
 	log.deleteVideo(v)
+	if v.parentID != "" {
+		if val, ok := videosMap[v.parentID]; ok {
+			i := 0
+			size := len(val.Ids)
+			for ; i < size; i++ {
+				if v.ID == val.Ids[i] {
+					break
+				}
+			}
+			if i < size {
+				val.Ids[i] = val.Ids[size-1]
+				val.Ids = val.Ids[:size-1]
+			}
+		}
+	}
+	delete(videosMap, id)
+}
+
+// stopVideo stop the download of removed video and delete its file. Files that were placed in the output root by the
+// output template are kept. videosMutex must not be held because stopping live download wait for its callbacks.
+func stopVideo(v *video) {
 	if v.async != nil {
 		err := v.async.Stop()
 		if err != nil {
@@ -71,33 +133,39 @@ func deleteVideo(videosMap map[string]*video, id string, v *video) {
 		if _, ok := err.(*vigoler.CancelError); err != nil && !ok {
 			log.deleteVideoError(v, warn, err)
 		}
+		if v.placed && err == nil {
+			return
+		}
 		err = os.Remove(v.fileName)
 		if err != nil && !os.IsNotExist(err) {
 			log.deleteVideoFileError(v, err)
 		}
 	}
-	if v.parentID != "" {
-		if val, ok := videosMap[v.parentID]; ok {
-			i := 0
-			size := len(val.Ids)
-			for ; i < size; i++ {
-				if v.ID == val.Ids[i] {
-					break
-				}
-			}
-			val.Ids[i] = val.Ids[size-1]
-			val.Ids = val.Ids[:size-1]
-		}
-	}
-	delete(videosMap, id)
 }
 func serverCleaner(videosMap map[string]*video, maxTimeDiff int) {
 	curTime := time.Now()
+	var removed []*video
+	videosMutex.Lock()
 	for k, v := range videosMap {
-		if (int)(curTime.Sub(v.updateTime).Seconds()) > maxTimeDiff {
-			deleteVideo(videosMap, k, v)
+		// Pinned live parts are kept until they are deleted explicitly.
+		if !v.Pinned && (int)(curTime.Sub(v.updateTime).Seconds()) > maxTimeDiff {
+			removeVideo(videosMap, k, v)
+			removed = append(removed, v)
+		}
+	}
+	videosMutex.Unlock()
+	for _, v := range removed {
+		stopVideo(v)
+	}
+}
+func splitEnvList(env string) []string {
+	var list []string
+	for _, value := range strings.Split(env, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
 		}
 	}
+	return list
 }
 func createID() string {
 	return ksuid.New().String()
@@ -117,7 +185,7 @@ func logVid(vid *video, warn string, err error) {
 	}
 }
 func createVideos(url string) ([]video, error) {
-	async, err := videoUtils.Youtube.GetUrls(url)
+	async, err := videoUtils.Extractor.GetUrls(url)
 	if err != nil {
 		return nil, err
 	}
@@ -126,18 +194,85 @@ func createVideos(url string) ([]video, error) {
 		log.warnInVideoCreate(url, warn)
 	}
 	videoUrls := urls.([]vigoler.VideoUrl)
-	if len(videoUrls) == 0 && err != nil {
+	if _, isNotStarted := err.(*vigoler.LiveNotStartedError); isNotStarted && len(videoUrls) == 0 {
+		// The extractor does not return any data about lives that did not start.
+		videoUrls = append(videoUrls, vigoler.VideoUrl{Name: url, WebPageURL: url, IsUpcoming: true})
+	} else if len(videoUrls) == 0 && err != nil {
 		return nil, err
 	}
 	videos := make([]video, 0)
 	for _, url := range videoUrls {
-		if supportLive || !url.IsLive {
-			vid := video{videoURL: url, ID: createID(), Name: url.Name, IsLive: url.IsLive, isLogged: false}
-			videos = append(videos, vid)
+		if supportLive || (!url.IsLive && !url.IsUpcoming) {
+			videos = append(videos, createVideo(url))
 		}
 	}
 	return videos, nil
 }
+func createVideo(url vigoler.VideoUrl) video {
+	vid := video{videoURL: url, ID: createID(), Name: url.Name, IsLive: url.IsLive, IsUpcoming: url.IsUpcoming, isLogged: false,
+		PlaylistIndex: url.PlaylistIndex, PlaylistTitle: url.PlaylistTitle}
+	if !url.ReleaseTime.IsZero() {
+		releaseTime := url.ReleaseTime
+		vid.ReleaseTime = &releaseTime
+	}
+	return vid
+}
+func parsePlaylistFilter(r *http.Request) (vigoler.PlaylistFilter, error) {
+	query := r.URL.Query()
+	filter := vigoler.PlaylistFilter{DateAfter: query.Get("date_after"), DateBefore: query.Get("date_before")}
+	var err error
+	for name, value := range map[string]*int{"start": &filter.Start, "end": &filter.End} {
+		if query.Get(name) != "" {
+			if *value, err = validateInt(query.Get(name)); err != nil {
+				return filter, err
+			}
+		}
+	}
+	for name, value := range map[string]*float64{"min_duration": &filter.MinDuration, "max_duration": &filter.MaxDuration} {
+		if query.Get(name) != "" {
+			if *value, err = strconv.ParseFloat(query.Get(name), 64); err != nil {
+				return filter, err
+			}
+		}
+	}
+	if title := query.Get("title"); title != "" {
+		if filter.Title, err = regexp.Compile(title); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// resolveVideo extract the formats of video that was listed from playlist. videosMutex must not be held.
+func resolveVideo(vid *video) error {
+	videosMutex.Lock()
+	entry, filter := vid.playlistEntry, vid.playlistFilter
+	videosMutex.Unlock()
+	if entry == nil {
+		return nil
+	}
+	async, err := vigoler.ResolvePlaylistEntry(videoUtils.Extractor, *entry, filter)
+	if err != nil {
+		return err
+	}
+	urls, err, warn := async.Get()
+	if warn != "" {
+		log.warnInVideoCreate(entry.URL, warn)
+	}
+	videoUrls := urls.([]vigoler.VideoUrl)
+	if len(videoUrls) == 0 {
+		if err == nil {
+			err = &vigoler.VideoUnavailableError{Video: entry.URL, ErrorMessage: "video does not match the playlist filter"}
+		}
+		return err
+	}
+	resolved := createVideo(videoUrls[0])
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	vid.videoURL, vid.Name, vid.IsLive, vid.IsUpcoming, vid.ReleaseTime = resolved.videoURL, resolved.Name, resolved.IsLive, resolved.IsUpcoming, resolved.ReleaseTime
+	vid.playlistEntry = nil
+	return nil
+}
 func extractLiveParameter() (maxSizeInKb, sizeSplit, maxTimeInSec, timeSplit int, err error) {
 	maxSizeInKb, err = validateInt(os.Getenv("VIGOLER_LIVE_MAX_SIZE"))
 	if err != nil {
@@ -166,100 +301,480 @@ func addIndexToFileName(name string) string {
 		return name[:lastDot+1] + strconv.Itoa(curIndex+1)
 	}
 }
-func downloadLiveUntilNow(vid *video) error {
-	async, err := videoUtils.DownloadLiveUntilNow(vid.videoURL, vigoler.GetBestFormat(vid.videoURL.Formats, true, true), liveFormat)
-	if err != nil {
-		return err
-	}
+func addLiveChildOnFinish(vid *video, name string, async *vigoler.Async) {
 	go func() {
 		outputI, err, _ := async.Get()
 		if err == nil {
 			output := outputI.(string)
 			ext := path.Ext(output)[1:]
+			videosMutex.Lock()
+			defer videosMutex.Unlock()
 			id := createID()
 			vid.Ids = append(vid.Ids, id)
-			nVid := &video{Name: vid.Name + ".0", fileName: output, ext: ext, IsLive: false, ID: id, updateTime: time.Now(), async: async, parentID: vid.ID}
+			nVid := &video{Name: name, fileName: output, ext: ext, IsLive: false, ID: id, updateTime: time.Now(), async: async, parentID: vid.ID}
 			videosMap[id] = nVid
 			log.newVideo(nVid)
 		}
 	}()
+}
+func downloadLiveUntilNow(vid *video, url vigoler.VideoUrl, name string) error {
+	async, err := videoUtils.DownloadLiveUntilNow(url, videoUtils.GetBestFormat(url.Formats, true, true), liveFormat)
+	if err != nil {
+		return err
+	}
+	addLiveChildOnFinish(vid, name+".0", async)
 	return nil
 }
-func downloadVideoLive(w http.ResponseWriter, vid *video) {
+
+// downloadLiveWindow download the last windowInSec seconds of the live as child of vid. videosMutex must not be held
+// because starting the download fetch the playlist of the live.
+func downloadLiveWindow(vid *video, windowInSec int) error {
+	videosMutex.Lock()
+	url, name := vid.videoURL, vid.Name
+	videosMutex.Unlock()
+	async, err := videoUtils.DownloadLiveWindow(url, videoUtils.GetBestFormat(url.Formats, true, true), liveFormat, windowInSec)
+	if err != nil {
+		return err
+	}
+	addLiveChildOnFinish(vid, name+".last"+strconv.Itoa(windowInSec), async)
+	return nil
+}
+func extractLiveRetention() (vigoler.LiveRetention, error) {
+	maxParts, err := getDefaultNumericEnv("VIGOLER_LIVE_RETENTION_PARTS", 0)
+	if err != nil {
+		return vigoler.LiveRetention{}, err
+	}
+	maxSizeInKb, err := getDefaultNumericEnv("VIGOLER_LIVE_RETENTION_SIZE", 0)
+	if err != nil {
+		return vigoler.LiveRetention{}, err
+	}
+	maxAgeInSec, err := getDefaultNumericEnv("VIGOLER_LIVE_RETENTION_AGE", 0)
+	if err != nil {
+		return vigoler.LiveRetention{}, err
+	}
+	return vigoler.LiveRetention{MaxParts: maxParts, MaxSizeInKb: maxSizeInKb, MaxAge: time.Duration(maxAgeInSec) * time.Second}, nil
+}
+func extractSplitSettings() (vigoler.SplitSettings, error) {
+	maxSizeInKb, err := getDefaultNumericEnv("VIGOLER_SPLIT_SIZE", 0)
+	if err != nil {
+		return vigoler.SplitSettings{}, err
+	}
+	maxTimeInSec, err := getDefaultNumericEnv("VIGOLER_SPLIT_TIME", 0)
+	if err != nil {
+		return vigoler.SplitSettings{}, err
+	}
+	return vigoler.SplitSettings{MaxSizeInKb: maxSizeInKb, MaxTimeInSec: maxTimeInSec,
+		ByChapters: strings.ToLower(os.Getenv("VIGOLER_SPLIT_CHAPTERS")) == "true"}, nil
+}
+
+// addPartsOnFinish add the parts of split download as children of the video when the download finish.
+func addPartsOnFinish(vid *video, async *vigoler.Async) {
+	go func() {
+		result, err, _ := async.Get()
+		if parts, ok := result.([]string); err == nil && ok && len(parts) > 1 {
+			videosMutex.Lock()
+			addParts(vid, parts)
+			videosMutex.Unlock()
+		}
+	}()
+}
+func addParts(vid *video, parts []string) {
+	for i, part := range parts {
+		var wg sync.WaitGroup
+		partAsync := vigoler.CreateAsyncWaitGroup(&wg, nil)
+		partAsync.SetResult(part, nil, "")
+		id := createID()
+		vid.Ids = append(vid.Ids, id)
+		nVid := &video{Name: vid.Name + "." + strconv.Itoa(i+1), fileName: part, ext: path.Ext(part)[1:], IsLive: false, ID: id,
+			updateTime: time.Now(), async: &partAsync, parentID: vid.ID, placed: vid.placed}
+		videosMap[id] = nVid
+		log.newVideo(nVid)
+	}
+}
+
+// removeLivePart remove the child of the live with fileName and return it to be stopped, videosMutex must be held.
+func removeLivePart(vid *video, fileName string) *video {
+	for _, id := range vid.Ids {
+		if child, ok := videosMap[id]; ok && child.fileName == fileName {
+			log.removeLivePart(child)
+			removeVideo(videosMap, id, child)
+			return child
+		}
+	}
+	return nil
+}
+
+// reserveStart mark vid as starting a download and return false if it already has a download or is starting one.
+// videosMutex must be held.
+func reserveStart(vid *video) bool {
+	if vid.async != nil || vid.starting {
+		return false
+	}
+	vid.starting = true
+	return true
+}
+
+// finishStart set async that was started without videosMutex as the download of the reserved vid and call update
+// while videosMutex is held. async is nil when the start failed. When vid was removed meanwhile async is stopped and
+// false is returned. videosMutex must not be held.
+func finishStart(vid *video, async *vigoler.Async, update func()) bool {
+	videosMutex.Lock()
+	vid.starting = false
+	if videosMap[vid.ID] != vid {
+		videosMutex.Unlock()
+		if async != nil {
+			_ = async.Stop()
+		}
+		return false
+	}
+	if async != nil {
+		vid.async = async
+		vid.updateTime = time.Now()
+		if update != nil {
+			update()
+		}
+	}
+	videosMutex.Unlock()
+	return true
+}
+
+// startLiveDownload start the live download of the reserved vid. videosMutex must not be held because starting the
+// download fetch the playlist of the live.
+func startLiveDownload(vid *video) error {
 	maxSizeInKb, sizeSplit, maxTimeInSec, timeSplit, err := extractLiveParameter()
 	if err != nil {
 		panic(err)
-	} else {
-		lastName := ""
-		fileDownloadedCallback := func(data interface{}, fileName string, async *vigoler.Async) {
-			_, err, _ = async.Get()
-			if err == nil {
-				vid := data.(*video)
-				ext := path.Ext(fileName)[1:]
-				var name string
-				if lastName == "" {
-					name = vid.Name
-				} else {
-					name = addIndexToFileName(lastName)
+	}
+	lastName := ""
+	retention, err := extractLiveRetention()
+	if err != nil {
+		panic(err)
+	}
+	videosMutex.Lock()
+	vid.liveParts = &vigoler.LiveParts{Retention: retention}
+	url, name := vid.videoURL, vid.Name
+	logger := &vigoler.Logger{Logger: log.withVideo(vid)}
+	videosMutex.Unlock()
+	fileDownloadedCallback := func(data interface{}, fileName string, async *vigoler.Async) {
+		result, err, _ := async.Get()
+		if err == nil {
+			var removed []*video
+			defer func() {
+				for _, child := range removed {
+					stopVideo(child)
+				}
+			}()
+			videosMutex.Lock()
+			defer videosMutex.Unlock()
+			vid := data.(*video)
+			ext := path.Ext(fileName)[1:]
+			var name string
+			if lastName == "" {
+				name = vid.Name
+			} else {
+				name = addIndexToFileName(lastName)
+			}
+			lastName = name
+			id := createID()
+			vid.Ids = append(vid.Ids, id)
+			nVid := &video{Name: name, fileName: fileName, ext: ext, IsLive: false, ID: id, updateTime: time.Now(), async: async, parentID: vid.ID}
+			videosMap[id] = nVid
+			log.newVideo(nVid)
+			part, ok := result.(vigoler.LivePart)
+			if !ok {
+				part = vigoler.LivePart{FileName: fileName}
+				if info, err := os.Stat(fileName); err == nil {
+					part.SizeInKb = int(info.Size() / 1024)
 				}
-				lastName = name
-				id := createID()
-				vid.Ids = append(vid.Ids, id)
-				nVid := &video{Name: name, fileName: fileName, ext: ext, IsLive: false, ID: id, updateTime: time.Now(), async: async, parentID: vid.ID}
-				videosMap[id] = nVid
-				log.newVideo(nVid)
 			}
+			for _, part := range vid.liveParts.Add(part) {
+				if child := removeLivePart(vid, part.FileName); child != nil {
+					removed = append(removed, child)
+				}
+			}
+			nVid.Pinned = vid.liveParts.IsPinned(fileName)
 		}
-		vid.async, err = videoUtils.LiveDownload(&vigoler.Logger{Logger: log.withVideo(vid)}, vid.videoURL, vigoler.GetBestFormat(vid.videoURL.Formats, true, true), liveFormat, maxSizeInKb, sizeSplit, maxTimeInSec, timeSplit, fileDownloadedCallback, vid)
+	}
+	async, err := videoUtils.LiveDownload(logger, url, videoUtils.GetBestFormat(url.Formats, true, true), liveFormat, maxSizeInKb, sizeSplit, maxTimeInSec, timeSplit, fileDownloadedCallback, vid)
+	if err != nil {
+		finishStart(vid, nil, nil)
+		logVideoError(vid, "live", err)
+		return err
+	}
+	if archive != nil {
+		async = archive.ArchiveOnFinish(url, async)
+	}
+	if !finishStart(vid, async, nil) {
+		return nil
+	}
+	if strings.ToLower(os.Getenv("VIGOLER_LIVE_FROM_START")) == "true" {
+		err = downloadLiveUntilNow(vid, url, name)
 		if err != nil {
-			log.downloadVideoError(vid, "live", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logVideoError(vid, "live from start", err)
+		}
+	}
+	return nil
+}
+
+// logVideoError log the error of the download of vid with videosMutex held, videosMutex must not be held.
+func logVideoError(vid *video, operation string, err error) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	log.downloadVideoError(vid, operation, err)
+}
+
+// encodeVideo write vid to the client with videosMutex held, videosMutex must not be held.
+func encodeVideo(w http.ResponseWriter, vid *video) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	json.NewEncoder(w).Encode(vid)
+}
+func downloadVideoLive(w http.ResponseWriter, vid *video) {
+	if err := startLiveDownload(vid); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		encodeVideo(w, vid)
+	}
+}
+
+// waitForLive wait until the upcoming reserved video start and then download it as live, or by the selector or the
+// profile when it is not live. videosMutex must not be held.
+func waitForLive(vid *video, selector *vigoler.FormatSelector, profile *vigoler.CompatibilityProfile) error {
+	minPoll, err := getDefaultNumericEnv("VIGOLER_LIVE_WAIT_MIN_POLL", 60)
+	if err != nil {
+		panic(err)
+	}
+	maxPoll, err := getDefaultNumericEnv("VIGOLER_LIVE_WAIT_MAX_POLL", 15*60)
+	if err != nil {
+		panic(err)
+	}
+	videosMutex.Lock()
+	pageURL := vid.videoURL.WebPageURL
+	videosMutex.Unlock()
+	async, err := videoUtils.WaitForLive(pageURL, minPoll, maxPoll)
+	if err != nil {
+		finishStart(vid, nil, nil)
+		return err
+	}
+	if !finishStart(vid, async, func() {
+		vid.Status = statusWaitingForLive
+		log.waitForLive(vid)
+	}) {
+		return nil
+	}
+	go func() {
+		urlI, err, warn := async.Get()
+		videosMutex.Lock()
+		vid.Status = ""
+		if err != nil || vid.async != async || videosMap[vid.ID] != vid {
+			logVid(vid, warn, err)
+			videosMutex.Unlock()
+			return
+		}
+		vid.videoURL = urlI.(vigoler.VideoUrl)
+		vid.Name = vid.videoURL.Name
+		vid.IsLive = vid.videoURL.IsLive
+		vid.IsUpcoming = false
+		vid.async = nil
+		vid.starting = true
+		isLive := vid.IsLive
+		videosMutex.Unlock()
+		if isLive {
+			_ = startLiveDownload(vid)
+		} else if err = startDownload(vid, selector, profile); err != nil {
+			logVideoError(vid, "download after wait for live", err)
 		} else {
-			if strings.ToLower(os.Getenv("VIGOLER_LIVE_FROM_START")) == "true" {
-				err = downloadLiveUntilNow(vid)
-				if err != nil {
-					log.downloadVideoError(vid, "live from start", err)
-				}
+			logStartDownload(vid)
+		}
+	}()
+	return nil
+}
+
+// logStartDownload log the start of the download of vid with videosMutex held, videosMutex must not be held.
+func logStartDownload(vid *video) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	log.startDownloadVideo(vid)
+}
+
+// formatChoice return the format selector or the compatibility profile of the request, the request options are
+// preferred over the defaults from the environment. Both are nil if there is no choice.
+func formatChoice(r *http.Request) (*vigoler.FormatSelector, *vigoler.CompatibilityProfile, error) {
+	query := r.URL.Query()
+	return parseFormatChoice(query.Get("format"), query.Get("profile"))
+}
+
+// parseFormatChoice return the format selector or the profile, when both are empty the server default is used.
+func parseFormatChoice(format, profileName string) (*vigoler.FormatSelector, *vigoler.CompatibilityProfile, error) {
+	for _, choice := range [][2]string{{format, profileName}, {os.Getenv("VIGOLER_FORMAT_SELECTOR"), os.Getenv("VIGOLER_PROFILE")}} {
+		if choice[0] != "" {
+			selector, err := vigoler.ParseFormatSelector(choice[0])
+			return selector, nil, err
+		}
+		if choice[1] != "" {
+			profile, err := vigoler.GetCompatibilityProfile(choice[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("unknown profile %s, supported profiles: %s", choice[1], strings.Join(vigoler.CompatibilityProfilesNames(), ","))
 			}
-			json.NewEncoder(w).Encode(vid)
+			return nil, &profile, nil
 		}
 	}
+	return nil, nil, nil
+}
+
+// startDownload start the download of the reserved video that is not live by the selector, the profile or the server
+// settings. videosMutex must not be held because starting the download can run the extractor to refresh the formats.
+func startDownload(vid *video, selector *vigoler.FormatSelector, profile *vigoler.CompatibilityProfile) error {
+	sizeInKb, err := validateInt(os.Getenv("VIGOLER_MAX_FILE_SIZE"))
+	if err != nil {
+		panic(err)
+	}
+	videosMutex.Lock()
+	url := vid.videoURL
+	videosMutex.Unlock()
+	var async *vigoler.Async
+	if selector != nil {
+		async, err = videoUtils.DownloadSelector(url, selector, sizeInKb, os.Getenv("VIGOLER_MERGE_FORMAT"))
+	} else if profile != nil {
+		async, err = videoUtils.DownloadProfile(url, *profile, sizeInKb)
+	} else if strings.ToLower(os.Getenv("VIGOLER_DOWNLOAD_AND_MERGE")) == "true" {
+		async, err = videoUtils.DownloadBestAndMergeLanguages(url, sizeInKb, os.Getenv("VIGOLER_MERGE_FORMAT"), true, audioLanguages, mergeLanguages)
+	} else if sizeInKb == -1 {
+		async, err = videoUtils.DownloadBest(url, "")
+	} else {
+		async, err = videoUtils.DownloadBestMaxSize(url, sizeInKb, "")
+	}
+	if err != nil {
+		finishStart(vid, nil, nil)
+		return err
+	}
+	placed := outputTemplate != nil
+	if placed {
+		async = outputTemplate.OutputOnFinish(url, async)
+	}
+	split, err := extractSplitSettings()
+	if err != nil {
+		panic(err)
+	}
+	if split.IsEnabled() {
+		async = videoUtils.SplitDownload(url, async, split)
+		addPartsOnFinish(vid, async)
+	}
+	if archive != nil {
+		async = archive.ArchiveOnFinish(url, async)
+	}
+	finishStart(vid, async, func() { vid.placed = placed })
+	return nil
+}
+
+// isArchived return if the video is in the download archive.
+func isArchived(url vigoler.VideoUrl) bool {
+	return archive != nil && archive.ContainsVideo(url)
+}
+
+// getVideo return the video with the id of the request.
+func getVideo(r *http.Request) *video {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	return videosMap[mux.Vars(r)["ID"]]
 }
 func downloadVideo(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	vid := videosMap[vars["ID"]]
+	vid := getVideo(r)
 	if vid == nil {
 		w.WriteHeader(http.StatusNotFound)
-	} else {
-		if vid.async != nil {
+		return
+	}
+	if err := resolveVideo(vid); err != nil {
+		log.downloadVideoError(vid, "resolve playlist entry", err)
+		writeErrorToClient(w, err)
+		return
+	}
+	videosMutex.Lock()
+	isLive := vid.IsLive
+	videosMutex.Unlock()
+	if window := r.URL.Query().Get("window"); window != "" && isLive {
+		windowInSec, err := validateInt(window)
+		if err != nil || windowInSec <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err = downloadLiveWindow(vid, windowInSec); err != nil {
+			logVideoError(vid, "live window", err)
+			writeErrorToClient(w, err)
+		} else {
+			videosMutex.Lock()
+			vid.updateTime = time.Now()
 			json.NewEncoder(w).Encode(vid)
+			videosMutex.Unlock()
+		}
+		return
+	}
+	videosMutex.Lock()
+	if !reserveStart(vid) {
+		json.NewEncoder(w).Encode(vid)
+		videosMutex.Unlock()
+		return
+	}
+	url, isLive := vid.videoURL, vid.IsLive
+	videosMutex.Unlock()
+	selector, profile, err := formatChoice(r)
+	if err != nil {
+		finishStart(vid, nil, nil)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if url.IsUpcoming {
+		if err := waitForLive(vid, selector, profile); err != nil {
+			logVideoError(vid, "wait for live", err)
+			writeErrorToClient(w, err)
 		} else {
-			if vid.IsLive {
-				downloadVideoLive(w, vid)
-			} else {
-				sizeInKb, err := validateInt(os.Getenv("VIGOLER_MAX_FILE_SIZE"))
-				if err != nil {
-					panic(err)
-				} else {
-					vid.updateTime = time.Now()
-					if strings.ToLower(os.Getenv("VIGOLER_DOWNLOAD_AND_MERGE")) == "true" {
-						vid.async, err = videoUtils.DownloadBestAndMerge(vid.videoURL, sizeInKb, os.Getenv("VIGOLER_MERGE_FORMAT"), true)
-					} else if sizeInKb == -1 {
-						vid.async, err = videoUtils.DownloadBest(vid.videoURL, "")
-					} else {
-						vid.async, err = videoUtils.DownloadBestMaxSize(vid.videoURL, sizeInKb, "")
-					}
-					if err != nil {
-						log.downloadVideoError(vid, "download", err)
-						writeErrorToClient(w, err)
-					} else {
-						json.NewEncoder(w).Encode(vid)
-					}
+			encodeVideo(w, vid)
+		}
+	} else if isLive {
+		downloadVideoLive(w, vid)
+	} else {
+		if r.URL.Query().Get("force") != "true" && isArchived(url) {
+			finishStart(vid, nil, nil)
+			writeErrorToClient(w, &vigoler.AlreadyDownloadedError{Video: url.WebPageURL})
+			return
+		}
+		if err = startDownload(vid, selector, profile); err != nil {
+			logVideoError(vid, "download", err)
+			writeErrorToClient(w, err)
+		} else {
+			encodeVideo(w, vid)
+		}
+	}
+	logStartDownload(vid)
+}
+func pinLiveParts(w http.ResponseWriter, r *http.Request) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	vars := mux.Vars(r)
+	vid := videosMap[vars["ID"]]
+	if vid == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	windowInSec, err := validateInt(r.URL.Query().Get("window"))
+	if err != nil || windowInSec <= 0 || vid.liveParts == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	pinned := vid.liveParts.Pin(time.Duration(windowInSec) * time.Second)
+	now := time.Now()
+	for _, id := range vid.Ids {
+		if child, ok := videosMap[id]; ok {
+			for _, part := range pinned {
+				if child.fileName == part.FileName {
+					child.Pinned = true
+					child.updateTime = now
 				}
 			}
-			log.startDownloadVideo(vid)
 		}
 	}
+	vid.updateTime = now
+	log.pinLiveParts(vid, windowInSec)
+	json.NewEncoder(w).Encode(vid)
 }
 func checkIfVideoExist(videosMap map[string]*video, vid *video) *string {
 	for k, v := range videosMap {
@@ -269,8 +784,33 @@ func checkIfVideoExist(videosMap map[string]*video, vid *video) *string {
 	}
 	return nil
 }
+
+// errorCodesStatus map the codes of the extractor errors to http status.
+var errorCodesStatus = map[string]int{
+	vigoler.ErrorCodeVideoUnavailable:  http.StatusNotFound,
+	vigoler.ErrorCodePrivateVideo:      http.StatusForbidden,
+	vigoler.ErrorCodeGeoBlocked:        http.StatusUnavailableForLegalReasons,
+	vigoler.ErrorCodeAgeRestricted:     http.StatusForbidden,
+	vigoler.ErrorCodeLoginRequired:     http.StatusUnauthorized,
+	vigoler.ErrorCodeRateLimited:       http.StatusTooManyRequests,
+	vigoler.ErrorCodeUnsupportedURL:    http.StatusUnprocessableEntity,
+	vigoler.ErrorCodeLiveNotStarted:    http.StatusTooEarly,
+	vigoler.ErrorCodeAlreadyDownloaded: http.StatusConflict,
+}
+
+func errorStatus(err error) int {
+	if codedError, ok := err.(vigoler.CodedError); ok {
+		if status, ok := errorCodesStatus[codedError.Code()]; ok {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
 func writeErrorToClient(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
+	if codedError, ok := err.(vigoler.CodedError); ok {
+		w.Header().Set("X-Error-Code", codedError.Code())
+	}
+	w.WriteHeader(errorStatus(err))
 	if typedError, ok := err.(vigoler.TypedError); ok {
 		w.Write([]byte(typedError.Type()))
 	}
@@ -292,11 +832,15 @@ func addVideos(videosMap map[string]*video, videos []video) {
 	}
 }
 func videos(w http.ResponseWriter, r *http.Request) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
 	json.NewEncoder(w).Encode(videosMap)
 }
 func process(w http.ResponseWriter, r *http.Request) {
 	youtubeURL := readBody(r)
 	videos, err := createVideos(youtubeURL)
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
 	if err != nil {
 		log.errorInVideoCreate(youtubeURL, err)
 		writeErrorToClient(w, err)
@@ -305,32 +849,135 @@ func process(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(videos)
 }
+
+// addPlaylistEntry add video of the entry that is resolved when it is downloaded, videosMutex must be held.
+func addPlaylistEntry(entry vigoler.PlaylistEntry, filter vigoler.PlaylistFilter) *video {
+	vid := createVideo(vigoler.VideoUrl{ID: entry.ID, Name: entry.Title, WebPageURL: entry.URL, Duration: entry.Duration,
+		UploadDate: entry.UploadDate, PlaylistIndex: entry.Index, PlaylistTitle: entry.PlaylistTitle, Extractor: entry.Extractor})
+	vid.playlistEntry = &entry
+	vid.playlistFilter = filter
+	videos := []video{vid}
+	addVideos(videosMap, videos)
+	return videosMap[videos[0].ID]
+}
+
+// processPlaylist list the playlist and stream every entry as json line. The entries are resolved when they are
+// downloaded.
+func processPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistURL := readBody(r)
+	filter, err := parsePlaylistFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	extractor, ok := videoUtils.Extractor.(vigoler.PlaylistExtractor)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	async, entries, err := extractor.GetPlaylist(playlistURL, filter)
+	if err != nil {
+		log.errorInVideoCreate(playlistURL, err)
+		writeErrorToClient(w, err)
+		return
+	}
+	// The listing is stopped when the client disconnect, the entries are still drained so the extractor can finish.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			async.Stop()
+		case <-finished:
+		}
+	}()
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	sent := 0
+	for entry := range entries {
+		if r.Context().Err() != nil {
+			continue
+		}
+		if sent == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		videosMutex.Lock()
+		encoder.Encode(addPlaylistEntry(entry, filter))
+		videosMutex.Unlock()
+		sent++
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_, err, warn := async.Get()
+	if warn != "" {
+		log.warnInVideoCreate(playlistURL, warn)
+	}
+	if err != nil {
+		log.errorInVideoCreate(playlistURL, err)
+		if sent == 0 {
+			writeErrorToClient(w, err)
+		} else {
+			encoder.Encode(playlistErrorLine(err))
+		}
+	}
+}
+
+// playlistError is the last line of playlist listing that failed after some entries were sent.
+type playlistError struct {
+	Error string `json:"error"`
+	Type  string `json:"type,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+func playlistErrorLine(err error) playlistError {
+	line := playlistError{Error: err.Error()}
+	if typedError, ok := err.(vigoler.TypedError); ok {
+		line.Type = typedError.Type()
+	}
+	if codedError, ok := err.(vigoler.CodedError); ok {
+		line.Code = codedError.Code()
+	}
+	return line
+}
 func deleteVideoRequest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	vidId := vars["ID"]
+	videosMutex.Lock()
 	vid := videosMap[vidId]
+	if vid != nil {
+		removeVideo(videosMap, vidId, vid)
+	}
+	videosMutex.Unlock()
 	if vid == nil {
 		w.WriteHeader(http.StatusNotFound)
 	} else {
-		deleteVideo(videosMap, vidId, vid)
+		stopVideo(vid)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 func stopVideoDownload(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	vid := videosMap[vars["ID"]]
+	vid := getVideo(r)
 	if vid == nil {
 		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	videosMutex.Lock()
+	async := vid.async
+	videosMutex.Unlock()
+	// Stopping live download wait for its callbacks that take videosMutex.
+	if async == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	} else if err := async.Stop(); err != nil {
+		writeErrorToClient(w, err)
 	} else {
-		err := vid.async.Stop()
-		if err != nil {
-			writeErrorToClient(w, err)
-		} else {
-			json.NewEncoder(w).Encode(vid)
-		}
+		encodeVideo(w, vid)
 	}
 }
 func checkFileDownloaded(w http.ResponseWriter, r *http.Request) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
 	vars := mux.Vars(r)
 	vid := videosMap[vars["ID"]]
 	if vid == nil {
@@ -350,35 +997,129 @@ func checkFileDownloaded(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+func videoInfo(w http.ResponseWriter, r *http.Request) {
+	vid := getVideo(r)
+	if vid == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := resolveVideo(vid); err != nil {
+		log.downloadVideoError(vid, "resolve playlist entry", err)
+		writeErrorToClient(w, err)
+		return
+	}
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
+	vid.updateTime = time.Now()
+	json.NewEncoder(w).Encode(vid.videoURL)
+}
+
+// extractorStatus is the info of an extractor and the versions of managed extractor.
+type extractorStatus struct {
+	vigoler.ExtractorInfo
+	Managed *vigoler.ManagedExtractorStatus `json:"managed,omitempty"`
+}
+
+func extractorsStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]extractorStatus, 0, len(extractors))
+	for _, extractor := range extractors {
+		status := extractorStatus{ExtractorInfo: extractor.Info()}
+		if managed, ok := extractor.(*vigoler.ManagedExtractor); ok {
+			managedStatus := managed.Status()
+			status.Managed = &managedStatus
+		}
+		statuses = append(statuses, status)
+	}
+	json.NewEncoder(w).Encode(statuses)
+}
+func rollbackExtractor(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	for _, extractor := range extractors {
+		if managed, ok := extractor.(*vigoler.ManagedExtractor); ok && managed.Status().Name == name {
+			if err := managed.Rollback(); err != nil {
+				log.rollbackExtractorError(name, err)
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			status := managed.Status()
+			log.rollbackExtractor(status)
+			json.NewEncoder(w).Encode(extractorStatus{ExtractorInfo: managed.Info(), Managed: &status})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+func cacheStats(w http.ResponseWriter, r *http.Request) {
+	if cache == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(cache.Stats())
+}
+
+// invalidateCache remove the cached result of the url query parameter or all the results if there is no url.
+func invalidateCache(w http.ResponseWriter, r *http.Request) {
+	if cache == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if url := r.URL.Query().Get("url"); url != "" {
+		cache.Invalidate(url)
+	} else {
+		cache.Clear()
+	}
+	json.NewEncoder(w).Encode(cache.Stats())
+}
 func finishAsync(vid *video) (string, error) {
-	fileName, err, warn := vid.async.Get()
+	result, err, warn := vid.async.Get()
+	fileName := ""
+	switch res := result.(type) {
+	case string:
+		fileName = res
+	case vigoler.LivePart:
+		fileName = res.FileName
+	case []string:
+		// Video that was split to parts is downloaded by its children.
+		if len(res) == 1 {
+			fileName = res[0]
+		}
+	case *vigoler.LiveReport:
+		vid.Gaps = res.Gaps
+	}
 	// Get file extension and remove the '.'
-	if fileName != nil {
-		vid.fileName = fileName.(string)
-		vid.ext = path.Ext(fileName.(string))[1:]
+	if fileName != "" {
+		vid.fileName = fileName
+		vid.ext = path.Ext(fileName)[1:]
 	}
 	logVid(vid, warn, err)
 	return warn, err
 }
 func download(w http.ResponseWriter, r *http.Request) {
+	videosMutex.Lock()
+	defer videosMutex.Unlock()
 	vars := mux.Vars(r)
 	vid := videosMap[vars["ID"]]
 	if vid == nil {
 		w.WriteHeader(http.StatusNotFound)
-	} else if !vid.async.WillBlock() && !vid.IsLive {
+	} else if vid.async != nil && !vid.async.WillBlock() && !vid.IsLive {
 		warn, err := finishAsync(vid)
 		if err != nil {
 			log.videoAsyncError(vid, err, warn)
 			w.WriteHeader(http.StatusInternalServerError)
+		} else if vid.fileName == "" {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(vid)
 		} else {
 			fileName := vid.Name + "." + vid.ext
+			if outputTemplate != nil {
+				fileName = filepath.Base(vid.fileName)
+			}
 			file, err := os.Open(vid.fileName)
 			if err != nil {
 				log.errorOpenVideoOutputFile(vid, fileName, err)
 				w.WriteHeader(http.StatusInternalServerError)
 			} else {
 				defer file.Close()
-				defer func() { vid.updateTime = time.Now() }()
 				vid.updateTime = maxTime
 				w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
 				fs, err := file.Stat()
@@ -387,7 +1128,11 @@ func download(w http.ResponseWriter, r *http.Request) {
 				} else {
 					w.Header().Set("Content-Length", strconv.FormatInt(fs.Size(), 10))
 				}
+				// The file is sent without holding the lock, the cleaner skip the video until it is sent.
+				videosMutex.Unlock()
 				io.Copy(w, file)
+				videosMutex.Lock()
+				vid.updateTime = time.Now()
 			}
 
 		}
@@ -412,8 +1157,74 @@ func getDefaultNumericEnv(name string, defaultValue int) (int, error) {
 		return defaultValue, nil
 	}
 }
+func createExtractor() (vigoler.Extractor, error) {
+	names := splitEnvList(os.Getenv("VIGOLER_EXTRACTORS"))
+	if len(names) == 0 {
+		names = []string{vigoler.YtDlpExtractor, vigoler.YoutubeDlExtractor}
+	}
+	paths := map[string]string{vigoler.YoutubeDlExtractor: os.Getenv("VIGOLER_YOUTUBEDL_PATH"), vigoler.YtDlpExtractor: os.Getenv("VIGOLER_YTDLP_PATH")}
+	pins := map[string]string{vigoler.YoutubeDlExtractor: os.Getenv("VIGOLER_YOUTUBEDL_PIN"), vigoler.YtDlpExtractor: os.Getenv("VIGOLER_YTDLP_PIN")}
+	managedDir := os.Getenv("VIGOLER_EXTRACTORS_DIR")
+	var available, all []vigoler.Extractor
+	for _, name := range names {
+		var extractor vigoler.Extractor
+		var err error
+		if managedDir != "" {
+			var managed *vigoler.ManagedExtractor
+			// Managed extractor that is not available can become available after update.
+			managed, err = vigoler.CreateManagedExtractor(name, paths[name], managedDir, pins[name])
+			if managed == nil {
+				return nil, err
+			}
+			extractor = managed
+			available = append(available, extractor)
+		} else {
+			var wrapper *vigoler.YoutubeDlWrapper
+			wrapper, err = vigoler.CreateExtractor(name, paths[name])
+			if wrapper == nil {
+				return nil, err
+			}
+			extractor = wrapper
+			if err == nil {
+				available = append(available, extractor)
+			}
+		}
+		log.extractorInfo(extractor.Info(), err)
+		all = append(all, extractor)
+	}
+	extractors = all
+	if len(available) == 0 {
+		available = all
+	}
+	var extractor vigoler.Extractor = &vigoler.FallbackExtractor{Extractors: available}
+	ttl, err := getDefaultNumericEnv("VIGOLER_CACHE_TTL", 5*secondsPerMinute)
+	if err != nil {
+		return nil, err
+	}
+	maxEntries, err := getDefaultNumericEnv("VIGOLER_CACHE_MAX_ENTRIES", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		cache = vigoler.CreateCachedExtractor(extractor, time.Duration(ttl)*time.Second, maxEntries)
+		extractor = cache
+	}
+	return extractor, nil
+}
 func main() {
-	you := vigoler.CreateYoutubeDlWrapper()
+	you, err := createExtractor()
+	if err != nil {
+		panic(err)
+	}
+	var formatRanking *vigoler.FormatRanking
+	if ranking, ok := os.LookupEnv("VIGOLER_FORMAT_RANKING"); ok {
+		formatRanking = &vigoler.FormatRanking{}
+		*formatRanking = vigoler.DefaultFormatRanking
+		formatRanking.Criteria, err = vigoler.ParseRankCriteria(ranking)
+		if err != nil {
+			panic(err)
+		}
+	}
 	maxLiveWithoutOutput, err := getDefaultNumericEnv("VIGOLER_LIVE_STOP_TIMEOUT", -1)
 	if err != nil {
 		panic(err)
@@ -428,16 +1239,61 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	videoUtils = vigoler.VideoUtils{Youtube: &you, Ffmpeg: &ff, Curl: &curl, MinLiveErrorRetryingTime: maxRetry}
+	videoUtils = vigoler.VideoUtils{Extractor: you, Ffmpeg: &ff, Curl: &curl, MinLiveErrorRetryingTime: maxRetry, Ranking: formatRanking,
+		FitToSize:                strings.ToLower(os.Getenv("VIGOLER_FIT_TO_SIZE")) == "true",
+		FitToSizeLowerResolution: strings.ToLower(os.Getenv("VIGOLER_FIT_TO_SIZE_LOWER_RESOLUTION")) == "true",
+		RefreshOnFallback:        strings.ToLower(os.Getenv("VIGOLER_REFRESH_ON_FALLBACK")) == "true"}
+	if path, ok := os.LookupEnv("VIGOLER_DOWNLOAD_ARCHIVE"); ok {
+		if archive, err = vigoler.OpenDownloadArchive(path); err != nil {
+			panic(err)
+		}
+	}
+	// Files are downloaded to VIGOLER_OUTPUT_DIR and moved to their templated names there. The cleaner keep the moved
+	// files after VIGOLER_MAX_TIME_DIFF and delete only the files that were not moved.
+	template, hasTemplate := os.LookupEnv("VIGOLER_OUTPUT_TEMPLATE")
+	if dir, hasDir := os.LookupEnv("VIGOLER_OUTPUT_DIR"); hasTemplate || hasDir {
+		if outputTemplate, err = vigoler.ParseOutputTemplate(dir, template); err != nil {
+			panic(err)
+		}
+		if dir != "" {
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				panic(err)
+			}
+		}
+		videoUtils.OutputDir = dir
+	}
+	if path, ok := os.LookupEnv("VIGOLER_SUBSCRIPTIONS_FILE"); ok {
+		subscriptionsPath = path
+	} else if path, ok = os.LookupEnv("VIGOLER_DOWNLOAD_ARCHIVE"); ok {
+		// The subscriptions are saved next to the download archive because both track the downloaded entries.
+		subscriptionsPath = filepath.Join(filepath.Dir(path), "subscriptions.json")
+	}
 	videosMap = make(map[string]*video)
+	if subscriptionsPath != "" {
+		if err = loadSubscriptions(subscriptionsPath); err != nil {
+			panic(err)
+		}
+	}
 	router := mux.NewRouter()
 	router.HandleFunc("/videos", videos).Methods(http.MethodGet)
 	router.HandleFunc("/videos", process).Methods(http.MethodPost)
+	router.HandleFunc("/playlists", processPlaylist).Methods(http.MethodPost)
 	router.HandleFunc("/videos/{ID}", checkFileDownloaded).Methods(http.MethodGet)
 	router.HandleFunc("/videos/{ID}", downloadVideo).Methods(http.MethodPost)
 	router.HandleFunc("/videos/{ID}", stopVideoDownload).Methods(http.MethodPatch)
 	router.HandleFunc("/videos/{ID}", deleteVideoRequest).Methods(http.MethodDelete)
 	router.HandleFunc("/videos/{ID}/download", download).Methods(http.MethodGet)
+	router.HandleFunc("/videos/{ID}/info", videoInfo).Methods(http.MethodGet)
+	router.HandleFunc("/videos/{ID}/pin", pinLiveParts).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions", listSubscriptions).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions", addSubscription).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions/{ID}", subscriptionInfo).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions/{ID}", deleteSubscription).Methods(http.MethodDelete)
+	router.HandleFunc("/subscriptions/{ID}/poll", pollSubscription).Methods(http.MethodPost)
+	router.HandleFunc("/extractors", extractorsStatus).Methods(http.MethodGet)
+	router.HandleFunc("/cache", cacheStats).Methods(http.MethodGet)
+	router.HandleFunc("/cache", invalidateCache).Methods(http.MethodDelete)
+	router.HandleFunc("/extractors/{name}/rollback", rollbackExtractor).Methods(http.MethodPost)
 	maxTimeDiff, err := strconv.Atoi(os.Getenv("VIGOLER_MAX_TIME_DIFF"))
 	if err != nil {
 		panic(err)
@@ -453,9 +1309,9 @@ func main() {
 	go func() {
 		for true {
 			if value, ok := os.LookupEnv("VIGOLER_YOUTUBEDL_UPDATE_PERIODIC"); ok {
-				waitAndExecute(value, you.UpdateYoutubeDl)
+				waitAndExecute(value, you.Update)
 			} else {
-				waitAndExecute(os.Getenv("VIGOLER_CLEANER_PERIODIC"), you.UpdateYoutubeDl)
+				waitAndExecute(os.Getenv("VIGOLER_CLEANER_PERIODIC"), you.Update)
 			}
 		}
 	}()
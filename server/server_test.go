@@ -1,9 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
 	"github.com/samitc/vigoler/2/vigoler"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unsafe"
@@ -23,12 +34,15 @@ func Test_serverCleaner(t *testing.T) {
 	testMap := make(map[string]*video)
 	cleanTime := time.Now().Add(-10 * time.Second)
 	testMap["1"] = &video{ID: "1", fileName: "file name", ext: "mp4", Name: "name", IsLive: false, isLogged: false, updateTime: cleanTime}
+	pinnedMap := map[string]*video{"1": {ID: "1", fileName: "pinned part", ext: "mp4", Pinned: true, updateTime: cleanTime}}
+	pinnedExpected := map[string]*video{"1": pinnedMap["1"]}
 	tests := []struct {
 		name        string
 		args        args
 		expectedMap map[string]*video
 	}{
 		{name: "video with out async", args: args{videosMap: testMap, maxTimeDiff: 5}, expectedMap: map[string]*video{}},
+		{name: "pinned live part", args: args{videosMap: pinnedMap, maxTimeDiff: 5}, expectedMap: pinnedExpected},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -78,3 +92,322 @@ func Test_duplicate(t *testing.T) {
 		t.Errorf("video duplicate add changed video in map")
 	}
 }
+func Test_writeErrorToClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"video unavailable", &vigoler.VideoUnavailableError{}, http.StatusNotFound, vigoler.ErrorCodeVideoUnavailable},
+		{"private video", &vigoler.PrivateVideoError{}, http.StatusForbidden, vigoler.ErrorCodePrivateVideo},
+		{"geo blocked", &vigoler.GeoBlockedError{}, http.StatusUnavailableForLegalReasons, vigoler.ErrorCodeGeoBlocked},
+		{"login required", &vigoler.LoginRequiredError{}, http.StatusUnauthorized, vigoler.ErrorCodeLoginRequired},
+		{"rate limited", &vigoler.RateLimitedError{}, http.StatusTooManyRequests, vigoler.ErrorCodeRateLimited},
+		{"live not started", &vigoler.LiveNotStartedError{}, http.StatusTooEarly, vigoler.ErrorCodeLiveNotStarted},
+		{"http error", &vigoler.HttpError{}, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			writeErrorToClient(recorder, tt.err)
+			if recorder.Code != tt.status {
+				t.Errorf("writeErrorToClient() status = %d, want %d", recorder.Code, tt.status)
+			}
+			if code := recorder.Header().Get("X-Error-Code"); code != tt.code {
+				t.Errorf("writeErrorToClient() code = %s, want %s", code, tt.code)
+			}
+			if body := recorder.Body.String(); body != tt.err.(vigoler.TypedError).Type() {
+				t.Errorf("writeErrorToClient() body = %s", body)
+			}
+		})
+	}
+}
+func Test_parsePlaylistFilter(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/playlists?start=2&end=10&date_after=20210101&title=^Episode&min_duration=60.5", nil)
+	filter, err := parsePlaylistFilter(r)
+	if err != nil {
+		t.Fatalf("parsePlaylistFilter() error = %v", err)
+	}
+	if filter.Start != 2 || filter.End != 10 || filter.DateAfter != "20210101" || filter.MinDuration != 60.5 || filter.MaxDuration != 0 ||
+		filter.Title.String() != "^Episode" {
+		t.Errorf("parsePlaylistFilter() = %+v", filter)
+	}
+	for _, query := range []string{"start=a", "max_duration=long", "title=("} {
+		if _, err = parsePlaylistFilter(httptest.NewRequest(http.MethodPost, "/playlists?"+query, nil)); err == nil {
+			t.Errorf("parsePlaylistFilter(%s) error = nil", query)
+		}
+	}
+}
+
+type testPlaylistExtractor struct {
+	entries []vigoler.PlaylistEntry
+	videos  []vigoler.VideoUrl
+	err     error
+}
+
+func (te *testPlaylistExtractor) GetUrls(url string) (*vigoler.Async, error) {
+	var wg sync.WaitGroup
+	async := vigoler.CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(te.videos, nil, "")
+	return &async, nil
+}
+func (te *testPlaylistExtractor) Update() error {
+	return nil
+}
+func (te *testPlaylistExtractor) Info() vigoler.ExtractorInfo {
+	return vigoler.ExtractorInfo{}
+}
+func (te *testPlaylistExtractor) GetPlaylist(url string, filter vigoler.PlaylistFilter) (*vigoler.Async, <-chan vigoler.PlaylistEntry, error) {
+	entries := make(chan vigoler.PlaylistEntry, len(te.entries))
+	for _, entry := range te.entries {
+		entries <- entry
+	}
+	close(entries)
+	var wg sync.WaitGroup
+	async := vigoler.CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(len(te.entries), te.err, "")
+	return &async, entries, nil
+}
+func Test_processPlaylistError(t *testing.T) {
+	defer func() {
+		videoUtils = vigoler.VideoUtils{}
+	}()
+	tests := []struct {
+		name       string
+		entries    []vigoler.PlaylistEntry
+		wantStatus int
+		wantLines  int
+	}{
+		{"no entries", nil, http.StatusInternalServerError, 0},
+		{"after entries", []vigoler.PlaylistEntry{{ID: "a", URL: "https://example.com/a"}}, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videoUtils = vigoler.VideoUtils{Extractor: &testPlaylistExtractor{entries: tt.entries, err: errors.New("listing failed")}}
+			videosMap = make(map[string]*video)
+			w := httptest.NewRecorder()
+			processPlaylist(w, httptest.NewRequest(http.MethodPost, "/playlists", strings.NewReader("https://example.com/playlist")))
+			if w.Code != tt.wantStatus {
+				t.Errorf("processPlaylist() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			if tt.wantLines == 0 {
+				return
+			}
+			var last playlistError
+			if len(lines) != tt.wantLines || json.Unmarshal([]byte(lines[len(lines)-1]), &last) != nil || last.Error != "listing failed" {
+				t.Errorf("processPlaylist() body = %s", w.Body.String())
+			}
+		})
+	}
+}
+func Test_subscriptionPoll(t *testing.T) {
+	extractor := &testPlaylistExtractor{entries: []vigoler.PlaylistEntry{{ID: "old", URL: "https://example.com/old"}}}
+	videoUtils = vigoler.VideoUtils{Extractor: extractor}
+	videosMap = make(map[string]*video)
+	defer func() {
+		videoUtils = vigoler.VideoUtils{}
+	}()
+	sub, err := createSubscription(subscriptionOptions{URL: "https://example.com/channel", PollInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.PollInterval != minSubscriptionPoll {
+		t.Errorf("createSubscription() poll interval = %d", sub.PollInterval)
+	}
+	sub.poll()
+	if len(videosMap) != 0 || sub.isNew(extractor.entries[0]) || sub.LastPoll == nil {
+		t.Errorf("poll() downloaded existing entries: %v", videosMap)
+	}
+	live := vigoler.PlaylistEntry{ID: "live", URL: "https://example.com/live"}
+	extractor.entries = append(extractor.entries, live)
+	extractor.videos = []vigoler.VideoUrl{{ID: "live", IsLive: true, WebPageURL: live.URL}}
+	sub.poll()
+	if len(videosMap) != 0 || !sub.isNew(live) {
+		t.Errorf("poll() live entry videos = %v, new = %v", videosMap, sub.isNew(live))
+	}
+	if _, err = createSubscription(subscriptionOptions{URL: "https://example.com/channel", Title: "("}); err == nil {
+		t.Errorf("createSubscription() error = nil for invalid title")
+	}
+}
+func Test_subscriptionArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.txt")
+	if err = ioutil.WriteFile(path, []byte("youtube listed\nyoutube resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if archive, err = vigoler.OpenDownloadArchive(path); err != nil {
+		t.Fatal(err)
+	}
+	listed := vigoler.PlaylistEntry{ID: "listed", URL: "https://example.com/listed", Extractor: "Youtube"}
+	resolved := vigoler.PlaylistEntry{ID: "resolved", URL: "https://example.com/resolved"}
+	extractor := &testPlaylistExtractor{entries: []vigoler.PlaylistEntry{listed, resolved},
+		videos: []vigoler.VideoUrl{{ID: "resolved", Extractor: "Youtube", WebPageURL: resolved.URL}}}
+	videoUtils = vigoler.VideoUtils{Extractor: extractor}
+	videosMap = make(map[string]*video)
+	defer func() {
+		videoUtils = vigoler.VideoUtils{}
+		archive = nil
+	}()
+	sub, err := createSubscription(subscriptionOptions{URL: "https://example.com/channel", DownloadExisting: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.poll()
+	if sub.isNew(listed) || sub.isNew(resolved) {
+		t.Errorf("poll() archived entries are new")
+	}
+	for _, vid := range videosMap {
+		if vid.async != nil {
+			t.Errorf("poll() downloaded archived video %s", vid.videoURL.ID)
+		}
+	}
+	if len(sub.Videos) != 0 {
+		t.Errorf("poll() subscription videos = %v", sub.Videos)
+	}
+}
+func Test_subscriptionsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	subscriptionsPath = filepath.Join(dir, "subscriptions.json")
+	defer func() {
+		subscriptionsPath = ""
+		subscriptions = make(map[string]*subscription)
+	}()
+	sub, err := createSubscription(subscriptionOptions{URL: "https://example.com/channel", Title: "^Episode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.markSeen(vigoler.PlaylistEntry{ID: "seen"})
+	sub.polled = true
+	subscriptions = map[string]*subscription{sub.ID: sub}
+	saveSubscriptions()
+	subscriptions = make(map[string]*subscription)
+	if err = loadSubscriptions(subscriptionsPath); err != nil {
+		t.Fatalf("loadSubscriptions() error = %v", err)
+	}
+	restored := subscriptions[sub.ID]
+	if restored == nil {
+		t.Fatalf("loadSubscriptions() = %v, want subscription %s", subscriptions, sub.ID)
+	}
+	close(restored.stopChan)
+	<-restored.doneChan
+	if restored.isNew(vigoler.PlaylistEntry{ID: "seen"}) || !restored.polled || restored.filter.Title.String() != "^Episode" {
+		t.Errorf("loadSubscriptions() = %+v", restored)
+	}
+	if err = loadSubscriptions(filepath.Join(dir, "missing.json")); err != nil {
+		t.Errorf("loadSubscriptions() of missing file error = %v", err)
+	}
+}
+func Test_addParts(t *testing.T) {
+	videosMap = make(map[string]*video)
+	vid := &video{ID: "parent", Name: "name"}
+	videosMap[vid.ID] = vid
+	addParts(vid, []string{"out.1.mp4", "out.2.mp4"})
+	if len(vid.Ids) != 2 || len(videosMap) != 3 {
+		t.Fatalf("addParts() ids = %v, videos = %v", vid.Ids, videosMap)
+	}
+	for i, id := range vid.Ids {
+		part := videosMap[id]
+		want := "name." + strconv.Itoa(i+1)
+		if part.Name != want || part.parentID != vid.ID || part.ext != "mp4" || part.async.WillBlock() {
+			t.Errorf("addParts() part = %+v, want name %s", part, want)
+		}
+		if fileName, _, _ := part.async.Get(); fileName != part.fileName {
+			t.Errorf("addParts() part result = %v, want %v", fileName, part.fileName)
+		}
+	}
+	deleteVideo(videosMap, vid.Ids[0], videosMap[vid.Ids[0]])
+	if len(vid.Ids) != 1 {
+		t.Errorf("deleteVideo() parent ids = %v", vid.Ids)
+	}
+}
+func Test_downloadOutputTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if outputTemplate, err = vigoler.ParseOutputTemplate(dir, "{uploader}/{title} [{id}].{ext}"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { outputTemplate = nil }()
+	downloaded := filepath.Join(dir, "123.mp4")
+	if err = ioutil.WriteFile(downloaded, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	async := vigoler.CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(downloaded, nil, "")
+	videoURL := vigoler.VideoUrl{ID: "id", Name: "title", Uploader: "uploader"}
+	vid := &video{ID: "vid", Name: "title", videoURL: videoURL, async: outputTemplate.OutputOnFinish(videoURL, &async), placed: true}
+	videosMap = map[string]*video{vid.ID: vid}
+	vid.async.Get()
+	recorder := httptest.NewRecorder()
+	download(recorder, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/videos/vid/download", nil), map[string]string{"ID": vid.ID}))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "video" {
+		t.Fatalf("download() code = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if want := filepath.Join(dir, "uploader", "title [id].mp4"); vid.fileName != want {
+		t.Errorf("download() file = %s, want %s", vid.fileName, want)
+	}
+	if disposition := recorder.Header().Get("Content-Disposition"); disposition != `attachment; filename="title [id].mp4"` {
+		t.Errorf("download() Content-Disposition = %s", disposition)
+	}
+	deleteVideo(videosMap, vid.ID, vid)
+	if _, err = os.Stat(vid.fileName); err != nil {
+		t.Errorf("deleteVideo() removed the placed file: %v", err)
+	}
+}
+func Test_waitForLiveNotLive(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("video"))
+	}))
+	defer server.Close()
+	os.Setenv("VIGOLER_MAX_FILE_SIZE", "-1")
+	defer os.Unsetenv("VIGOLER_MAX_FILE_SIZE")
+	premiere := vigoler.VideoUrl{ID: "premiere", Name: "premiere", WebPageURL: "https://example.com/premiere",
+		Formats: []vigoler.Format{{FormatID: "18", URL: server.URL + "/video.mp4", Protocol: "https", Ext: "mp4", HasVideo: true, HasAudio: true, FileSize: -1}}}
+	curl := vigoler.CreateCurlWrapper(1)
+	videoUtils = vigoler.VideoUtils{Extractor: &testPlaylistExtractor{videos: []vigoler.VideoUrl{premiere}}, Curl: &curl}
+	defer func() { videoUtils = vigoler.VideoUtils{} }()
+	vid := createVideo(vigoler.VideoUrl{WebPageURL: premiere.WebPageURL, IsUpcoming: true})
+	videosMutex.Lock()
+	videosMap = map[string]*video{vid.ID: &vid}
+	reserveStart(&vid)
+	videosMutex.Unlock()
+	if err := waitForLive(&vid, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var async *vigoler.Async
+	for i := 0; i < 100 && async == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		videosMutex.Lock()
+		if vid.Status == "" {
+			async = vid.async
+		}
+		videosMutex.Unlock()
+	}
+	if async == nil {
+		t.Fatalf("waitForLive() did not start the download, status = %s", vid.Status)
+	}
+	output, err, warn := async.Get()
+	if err != nil {
+		t.Fatalf("waitForLive() download error = %v, warn = %s", err, warn)
+	}
+	defer os.Remove(output.(string))
+	if data, _ := ioutil.ReadFile(output.(string)); string(data) != "video" {
+		t.Errorf("waitForLive() downloaded %s", data)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samitc/vigoler/2/vigoler"
+)
+
+const (
+	defaultSubscriptionPoll = secondsPerHour
+	minSubscriptionPoll     = secondsPerMinute
+)
+
+// subscriptionOptions is what the client send to create a subscription.
+type subscriptionOptions struct {
+	URL string `json:"url"`
+	// PollInterval is in seconds.
+	PollInterval int     `json:"poll_interval"`
+	Start        int     `json:"start,omitempty"`
+	End          int     `json:"end,omitempty"`
+	DateAfter    string  `json:"date_after,omitempty"`
+	DateBefore   string  `json:"date_before,omitempty"`
+	Title        string  `json:"title,omitempty"`
+	MinDuration  float64 `json:"min_duration,omitempty"`
+	MaxDuration  float64 `json:"max_duration,omitempty"`
+	Format       string  `json:"format,omitempty"`
+	Profile      string  `json:"profile,omitempty"`
+	// DownloadExisting download the entries that exist when the subscription is created, otherwise only entries that
+	// are added later are downloaded.
+	DownloadExisting bool `json:"download_existing,omitempty"`
+}
+
+// subscription poll channel or playlist and download its new entries.
+type subscription struct {
+	subscriptionOptions
+	ID        string     `json:"id"`
+	LastPoll  *time.Time `json:"last_poll,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	// Videos is the ids of the videos that were downloaded by the subscription.
+	Videos   []string `json:"videos,omitempty"`
+	mutex    sync.Mutex
+	filter   vigoler.PlaylistFilter
+	selector *vigoler.FormatSelector
+	profile  *vigoler.CompatibilityProfile
+	seen     map[string]bool
+	polled   bool
+	pollChan chan struct{}
+	stopChan chan struct{}
+	// doneChan is closed when run return after stopChan is closed.
+	doneChan chan struct{}
+}
+
+// savedSubscription is the subscription and the entries that it saw as it is saved in subscriptionsPath.
+type savedSubscription struct {
+	subscriptionOptions
+	ID     string   `json:"id"`
+	Seen   []string `json:"seen,omitempty"`
+	Polled bool     `json:"polled,omitempty"`
+}
+
+var subscriptionsMutex sync.Mutex
+var subscriptions = make(map[string]*subscription)
+
+// subscriptionsPath is the file that the subscriptions are saved to, empty if they are not saved.
+var subscriptionsPath string
+var subscriptionsSaveMutex sync.Mutex
+
+func createSubscription(options subscriptionOptions) (*subscription, error) {
+	if options.PollInterval == 0 {
+		options.PollInterval = defaultSubscriptionPoll
+	} else if options.PollInterval < minSubscriptionPoll {
+		options.PollInterval = minSubscriptionPoll
+	}
+	sub := &subscription{subscriptionOptions: options, ID: createID(), seen: make(map[string]bool),
+		pollChan: make(chan struct{}, 1), stopChan: make(chan struct{}), doneChan: make(chan struct{}),
+		filter: vigoler.PlaylistFilter{Start: options.Start, End: options.End, DateAfter: options.DateAfter,
+			DateBefore: options.DateBefore, MinDuration: options.MinDuration, MaxDuration: options.MaxDuration}}
+	var err error
+	if options.Title != "" {
+		if sub.filter.Title, err = regexp.Compile(options.Title); err != nil {
+			return nil, err
+		}
+	}
+	if sub.selector, sub.profile, err = parseFormatChoice(options.Format, options.Profile); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+func (sub *subscription) run() {
+	defer close(sub.doneChan)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sub.stopChan:
+			return
+		case <-timer.C:
+		case <-sub.pollChan:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		sub.poll()
+		timer.Reset(time.Duration(sub.PollInterval) * time.Second)
+	}
+}
+
+// isNew return if the entry was not seen by the subscription and is not in the download archive.
+func (sub *subscription) isNew(entry vigoler.PlaylistEntry) bool {
+	if archive != nil && archive.Contains(entry.Extractor, entry.ID) {
+		return false
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return !sub.seen[entry.ID]
+}
+func (sub *subscription) markSeen(entry vigoler.PlaylistEntry) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	sub.seen[entry.ID] = true
+}
+
+// download start the download of the entry. The entry is not marked as seen if it should be tried in the next poll.
+func (sub *subscription) download(entry vigoler.PlaylistEntry) {
+	videosMutex.Lock()
+	vid := addPlaylistEntry(entry, sub.filter)
+	videosMutex.Unlock()
+	if err := resolveVideo(vid); err != nil {
+		log.subscriptionError(sub, entry.URL, err)
+		if _, isNotStarted := err.(*vigoler.LiveNotStartedError); !isNotStarted {
+			sub.markSeen(entry)
+		}
+		return
+	}
+	videosMutex.Lock()
+	if vid.IsUpcoming || vid.IsLive {
+		// Lives are downloaded when they become videos.
+		if vid.async == nil && !vid.starting {
+			removeVideo(videosMap, vid.ID, vid)
+		}
+		videosMutex.Unlock()
+		return
+	}
+	sub.markSeen(entry)
+	if isArchived(vid.videoURL) || !reserveStart(vid) {
+		videosMutex.Unlock()
+		return
+	}
+	videosMutex.Unlock()
+	if err := startDownload(vid, sub.selector, sub.profile); err != nil {
+		logVideoError(vid, "subscription", err)
+		return
+	}
+	logStartDownload(vid)
+	sub.mutex.Lock()
+	sub.Videos = append(sub.Videos, vid.ID)
+	sub.mutex.Unlock()
+}
+
+// poll list the entries of the subscription and download the new entries.
+func (sub *subscription) poll() {
+	extractor, ok := videoUtils.Extractor.(vigoler.PlaylistExtractor)
+	if !ok {
+		return
+	}
+	async, entries, err := extractor.GetPlaylist(sub.URL, sub.filter)
+	if err == nil {
+		for entry := range entries {
+			if !sub.isNew(entry) {
+				continue
+			}
+			if sub.polled || sub.DownloadExisting {
+				sub.download(entry)
+			} else {
+				sub.markSeen(entry)
+			}
+		}
+		var warn string
+		_, err, warn = async.Get()
+		if warn != "" {
+			log.warnInVideoCreate(sub.URL, warn)
+		}
+	}
+	pollTime := time.Now()
+	sub.mutex.Lock()
+	sub.LastPoll = &pollTime
+	sub.LastError = ""
+	if err != nil {
+		sub.LastError = err.Error()
+		log.subscriptionError(sub, sub.URL, err)
+	} else {
+		sub.polled = true
+	}
+	sub.mutex.Unlock()
+	saveSubscriptions()
+}
+
+// saveSubscriptions write the subscriptions and the entries that they saw to subscriptionsPath so they are restored
+// after restart.
+func saveSubscriptions() {
+	if subscriptionsPath == "" {
+		return
+	}
+	subscriptionsSaveMutex.Lock()
+	defer subscriptionsSaveMutex.Unlock()
+	subscriptionsMutex.Lock()
+	saved := make([]savedSubscription, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		sub.mutex.Lock()
+		s := savedSubscription{subscriptionOptions: sub.subscriptionOptions, ID: sub.ID, Polled: sub.polled}
+		for id := range sub.seen {
+			s.Seen = append(s.Seen, id)
+		}
+		sub.mutex.Unlock()
+		sort.Strings(s.Seen)
+		saved = append(saved, s)
+	}
+	subscriptionsMutex.Unlock()
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].ID < saved[j].ID
+	})
+	data, err := json.Marshal(saved)
+	if err == nil {
+		tempPath := subscriptionsPath + ".tmp"
+		if err = ioutil.WriteFile(tempPath, data, 0644); err == nil {
+			err = os.Rename(tempPath, subscriptionsPath)
+		}
+	}
+	if err != nil {
+		log.saveSubscriptionsError(subscriptionsPath, err)
+	}
+}
+
+// loadSubscriptions restore the subscriptions that were saved in path and start to poll them.
+func loadSubscriptions(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved []savedSubscription
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	for _, s := range saved {
+		sub, err := createSubscription(s.subscriptionOptions)
+		if err != nil {
+			return err
+		}
+		sub.ID = s.ID
+		sub.polled = s.Polled
+		for _, id := range s.Seen {
+			sub.seen[id] = true
+		}
+		subscriptions[sub.ID] = sub
+		go sub.run()
+	}
+	return nil
+}
+func encodeSubscription(w http.ResponseWriter, sub *subscription) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	json.NewEncoder(w).Encode(sub)
+}
+func getSubscription(r *http.Request) *subscription {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	return subscriptions[mux.Vars(r)["ID"]]
+}
+func listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	list := make([]*subscription, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		sub.mutex.Lock()
+		defer sub.mutex.Unlock()
+		list = append(list, sub)
+	}
+	json.NewEncoder(w).Encode(list)
+}
+func addSubscription(w http.ResponseWriter, r *http.Request) {
+	var options subscriptionOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil || options.URL == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sub, err := createSubscription(options)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	subscriptionsMutex.Lock()
+	subscriptions[sub.ID] = sub
+	subscriptionsMutex.Unlock()
+	saveSubscriptions()
+	log.newSubscription(sub)
+	go sub.run()
+	w.WriteHeader(http.StatusCreated)
+	encodeSubscription(w, sub)
+}
+func subscriptionInfo(w http.ResponseWriter, r *http.Request) {
+	sub := getSubscription(r)
+	if sub == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	encodeSubscription(w, sub)
+}
+
+// pollSubscription poll the subscription now instead of waiting for its interval.
+func pollSubscription(w http.ResponseWriter, r *http.Request) {
+	sub := getSubscription(r)
+	if sub == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	select {
+	case sub.pollChan <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+func deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionsMutex.Lock()
+	id := mux.Vars(r)["ID"]
+	sub, ok := subscriptions[id]
+	if ok {
+		close(sub.stopChan)
+		delete(subscriptions, id)
+	}
+	subscriptionsMutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	saveSubscriptions()
+	log.deleteSubscription(sub)
+}
@@ -0,0 +1,120 @@
+package vigoler
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	str "strings"
+	"sync"
+)
+
+// ErrorCodeAlreadyDownloaded is the code of AlreadyDownloadedError.
+const ErrorCodeAlreadyDownloaded = "already_downloaded"
+
+// DownloadArchive is a file of the videos that were downloaded. The file has the format of youtube-dl
+// --download-archive, every line is the lower case extractor key and the video id separated by space.
+type DownloadArchive struct {
+	path  string
+	mutex sync.RWMutex
+	ids   map[string]bool
+}
+type AlreadyDownloadedError struct {
+	Video string
+}
+
+// OpenDownloadArchive read the archive in path. The file is created when the first video is added.
+func OpenDownloadArchive(path string) (*DownloadArchive, error) {
+	archive := &DownloadArchive{path: path, ids: make(map[string]bool)}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return archive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := str.TrimSpace(scanner.Text()); line != "" {
+			archive.ids[line] = true
+		}
+	}
+	return archive, scanner.Err()
+}
+
+// ArchiveID return the line of the video in the archive, empty if the video can not be archived.
+func ArchiveID(extractorKey, id string) string {
+	if extractorKey == "" || id == "" {
+		return ""
+	}
+	return str.ToLower(extractorKey) + " " + id
+}
+func (da *DownloadArchive) Contains(extractorKey, id string) bool {
+	archiveID := ArchiveID(extractorKey, id)
+	if archiveID == "" {
+		return false
+	}
+	da.mutex.RLock()
+	defer da.mutex.RUnlock()
+	return da.ids[archiveID]
+}
+
+// Add append the video to the archive. Videos that can not be archived are ignored.
+func (da *DownloadArchive) Add(extractorKey, id string) error {
+	archiveID := ArchiveID(extractorKey, id)
+	if archiveID == "" {
+		return nil
+	}
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+	if da.ids[archiveID] {
+		return nil
+	}
+	file, err := os.OpenFile(da.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(archiveID + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	da.ids[archiveID] = true
+	return nil
+}
+func (da *DownloadArchive) ContainsVideo(video VideoUrl) bool {
+	return da.Contains(video.Extractor, video.ID)
+}
+func (da *DownloadArchive) AddVideo(video VideoUrl) error {
+	return da.Add(video.Extractor, video.ID)
+}
+
+// ArchiveOnFinish return async with the result of async that add the video to the archive when async finish
+// successfully.
+func (da *DownloadArchive) ArchiveOnFinish(video VideoUrl, async *Async) *Async {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	archiveAsync := CreateAsyncFromAsyncAsWaitAble(&wg, async)
+	go func() {
+		defer wg.Done()
+		result, err, warn := async.Get()
+		if err == nil && !archiveAsync.isStopped {
+			if archiveErr := da.AddVideo(video); archiveErr != nil {
+				warn += fmt.Sprintf("Failed to add video %s to the download archive: %s\n", video.ID, archiveErr)
+			}
+		}
+		archiveAsync.SetResult(result, err, warn)
+	}()
+	return &archiveAsync
+}
+func (e *AlreadyDownloadedError) Error() string {
+	return fmt.Sprintf("Video %s is in the download archive", e.Video)
+}
+func (e *AlreadyDownloadedError) Type() string {
+	return "Already downloaded error"
+}
+func (e *AlreadyDownloadedError) Code() string {
+	return ErrorCodeAlreadyDownloaded
+}
@@ -0,0 +1,138 @@
+package vigoler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.txt")
+	if err = ioutil.WriteFile(path, []byte("youtube dQw4w9WgXcQ\n\nvimeo 123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := OpenDownloadArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		extractorKey string
+		id           string
+		want         bool
+	}{
+		{"existing", "Youtube", "dQw4w9WgXcQ", true},
+		{"other extractor", "Vimeo", "dQw4w9WgXcQ", false},
+		{"other id", "Vimeo", "123", true},
+		{"missing", "Youtube", "abc", false},
+		{"no extractor", "", "dQw4w9WgXcQ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := archive.Contains(tt.extractorKey, tt.id); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	video := VideoUrl{ID: "abc", Extractor: "Youtube"}
+	if err = archive.AddVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.AddVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.Add("", "no-extractor"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "youtube dQw4w9WgXcQ\n\nvimeo 123\nyoutube abc\n"; string(data) != want {
+		t.Errorf("archive file = %q, want %q", data, want)
+	}
+	reopened, err := OpenDownloadArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.ContainsVideo(video) {
+		t.Errorf("ContainsVideo() = false after reopen, want true")
+	}
+}
+func TestDownloadArchive_missingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, err := OpenDownloadArchive(filepath.Join(dir, "archive.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Contains("Youtube", "abc") {
+		t.Errorf("Contains() = true, want false")
+	}
+	if err = archive.Add("Youtube", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !archive.Contains("Youtube", "abc") {
+		t.Errorf("Contains() = false after Add, want true")
+	}
+}
+func TestDownloadArchive_ArchiveOnFinish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, _ := OpenDownloadArchive(filepath.Join(dir, "archive.txt"))
+	tests := []struct {
+		name string
+		id   string
+		err  error
+		want bool
+	}{
+		{"success", "a", nil, true},
+		{"failure", "b", &CancelError{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			async := CreateAsyncWaitGroup(&wg, nil)
+			async.SetResult("output", tt.err, "")
+			video := VideoUrl{ID: tt.id, Extractor: "Youtube"}
+			result, err, _ := archive.ArchiveOnFinish(video, &async).Get()
+			if result != "output" || err != tt.err {
+				t.Errorf("ArchiveOnFinish() = %v, %v, want output, %v", result, err, tt.err)
+			}
+			if got := archive.ContainsVideo(video); got != tt.want {
+				t.Errorf("ContainsVideo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_extractorKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		video youtubeDlVideo
+		want  string
+	}{
+		{"extractor key", youtubeDlVideo{ExtractorKey: "Youtube", IEKey: "YoutubeTab"}, "Youtube"},
+		{"flat playlist entry", youtubeDlVideo{IEKey: "Youtube"}, "Youtube"},
+		{"missing key", youtubeDlVideo{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.video.extractorKey(); got != tt.want {
+				t.Errorf("extractorKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
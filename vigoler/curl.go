@@ -301,3 +301,33 @@ func (curl *CurlWrapper) GetInputSizeHeaders(url string, headers map[string]stri
 	}()
 	return &async, nil
 }
+
+// URLStatus return the http status code of the url after redirects.
+func (curl *CurlWrapper) URLStatus(url string, headers map[string]string) (int, error) {
+	args := addCurlHeaders([]string{"-L", "-s", "-o", os.DevNull, "-w", "%{http_code}", "--range", "0-0"}, &headers)
+	args = append(args, url)
+	wa, oChan, err := curl.curl.runCommandRead(context.Background(), false, args...)
+	if err != nil {
+		return 0, err
+	}
+	var sb strings.Builder
+	for s := range oChan {
+		sb.WriteString(s)
+	}
+	if err = wa.Wait(); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(sb.String()))
+}
+
+// ResumeHeaders continue the download of url to output from the current size of output.
+func (curl *CurlWrapper) ResumeHeaders(url string, headers map[string]string, output string) (*Async, error) {
+	args := addCurlHeaders([]string{"-L", "-s", "-f", "-C", "-", "-o", output}, &headers)
+	args = append(args, url)
+	wa, err := curl.curl.runCommandWait(context.Background(), args...)
+	if err != nil {
+		return nil, err
+	}
+	async := createAsyncWaitAble(wa)
+	return &async, nil
+}
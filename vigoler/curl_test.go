@@ -3,12 +3,30 @@ package vigoler
 import (
 	"bytes"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"reflect"
 	"testing"
 	"time"
 )
 
+// startCurlTestServer start server of handler for the tests that download with curl, the test is skipped when curl is
+// not installed. The server is closed when the test finish.
+func startCurlTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testVideoContent return content of video with size bytes.
+func testVideoContent(size int) []byte {
+	return bytes.Repeat([]byte("0123456789"), size/10)
+}
 func Test_finishManagerDownload(t *testing.T) {
 	tempFilesNum := []int{0, 1, 2, 3, 4, 5}
 	testDownloadGo := make([]downloadGo, 0, len(tempFilesNum))
@@ -0,0 +1,198 @@
+package vigoler
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	str "strings"
+	"sync"
+)
+
+const (
+	YoutubeDlExtractor = "youtube-dl"
+	YtDlpExtractor     = "yt-dlp"
+)
+
+// Extractor extract the metadata and the formats of the videos in url.
+type Extractor interface {
+	// GetUrls return async that its result is []VideoUrl.
+	GetUrls(url string) (*Async, error)
+	// Update the extractor to the latest version.
+	Update() error
+	Info() ExtractorInfo
+}
+
+// ExtractorInfo is the version and the capabilities of an extractor that were detected when it was created.
+type ExtractorInfo struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	// Available is false when the extractor binary could not be run.
+	Available bool `json:"available"`
+	// LiveStatus is true when the extractor report live_status and release_timestamp of lives, GetUrls then return
+	// upcoming lives without formats instead of LiveNotStartedError.
+	LiveStatus bool `json:"live_status"`
+}
+type ExtractorNotAvailableError struct {
+	Name string
+	err  error
+}
+
+func (e *ExtractorNotAvailableError) Error() string {
+	return fmt.Sprintf("Extractor %s is not available: %v", e.Name, e.err)
+}
+func (e *ExtractorNotAvailableError) Type() string {
+	return "Extractor not available error"
+}
+
+// CreateExtractor create extractor by its name (youtube-dl or yt-dlp) that run the binary in path and detect its version.
+// Empty path use the name of the extractor as the binary.
+func CreateExtractor(name, path string) (*YoutubeDlWrapper, error) {
+	if name != YoutubeDlExtractor && name != YtDlpExtractor {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "name", argValue: name}
+	}
+	if path == "" {
+		path = name
+	}
+	wrapper := &YoutubeDlWrapper{app: externalApp{appLocation: path}, info: ExtractorInfo{Name: name, Path: path}}
+	version, err := wrapper.detectVersion()
+	if err != nil {
+		return wrapper, &ExtractorNotAvailableError{Name: name, err: err}
+	}
+	wrapper.info.Version = version
+	wrapper.info.Available = true
+	wrapper.info.LiveStatus = wrapper.detectLiveStatus()
+	return wrapper, nil
+}
+func (you *YoutubeDlWrapper) runOutput(args ...string) (string, error) {
+	wa, output, err := you.app.runCommandRead(context.Background(), false, args...)
+	if err != nil {
+		return "", err
+	}
+	var sb str.Builder
+	for s := range output {
+		sb.WriteString(s)
+	}
+	if err = wa.Wait(); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+func (you *YoutubeDlWrapper) detectVersion() (string, error) {
+	output, err := you.runOutput("--version")
+	if err != nil {
+		return "", err
+	}
+	return str.TrimSpace(str.SplitN(str.TrimSpace(output), "\n", 2)[0]), nil
+}
+
+// detectLiveStatus return if the extractor support waiting for lives (--wait-for-video), the versions that support it
+// also report live_status and release_timestamp.
+func (you *YoutubeDlWrapper) detectLiveStatus() bool {
+	output, err := you.runOutput("--help")
+	return err == nil && str.Contains(output, "--wait-for-video")
+}
+
+// FallbackExtractor try every extractor by order until one of them return videos.
+type FallbackExtractor struct {
+	Extractors []Extractor
+}
+type fallbackWaitAble struct {
+	mutex     sync.Mutex
+	current   *Async
+	isStopped bool
+	done      chan struct{}
+}
+
+func (fwa *fallbackWaitAble) Wait() error {
+	<-fwa.done
+	return nil
+}
+func (fwa *fallbackWaitAble) Stop() error {
+	fwa.mutex.Lock()
+	defer fwa.mutex.Unlock()
+	fwa.isStopped = true
+	if fwa.current != nil {
+		return fwa.current.Stop()
+	}
+	return nil
+}
+func (fwa *fallbackWaitAble) setCurrent(async *Async) bool {
+	fwa.mutex.Lock()
+	defer fwa.mutex.Unlock()
+	fwa.current = async
+	return !fwa.isStopped
+}
+
+// shouldFallback return if another extractor should be tried after the extractor returned videos and err.
+func shouldFallback(videos []VideoUrl, err error) bool {
+	if err == nil {
+		return len(videos) == 0
+	}
+	switch err.(type) {
+	case *LiveNotStartedError, *CancelError:
+		return false
+	}
+	return true
+}
+func (fe *FallbackExtractor) GetUrls(url string) (*Async, error) {
+	if len(fe.Extractors) == 0 {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "Extractors", argValue: fe.Extractors}
+	}
+	fwa := &fallbackWaitAble{done: make(chan struct{})}
+	async := createAsyncWaitAble(fwa)
+	go func() {
+		defer close(fwa.done)
+		var videos []VideoUrl
+		var err error
+		warn := ""
+		for _, extractor := range fe.Extractors {
+			videos = nil
+			var extractorAsync *Async
+			extractorAsync, err = extractor.GetUrls(url)
+			if err == nil {
+				if !fwa.setCurrent(extractorAsync) {
+					_ = extractorAsync.Stop()
+					err = &CancelError{}
+					break
+				}
+				var result interface{}
+				var extractorWarn string
+				result, err, extractorWarn = extractorAsync.Get()
+				if !fwa.setCurrent(nil) {
+					err = &CancelError{}
+					break
+				}
+				videos, _ = result.([]VideoUrl)
+				warn += extractorWarn
+			}
+			if !shouldFallback(videos, err) {
+				break
+			}
+			if err != nil {
+				warn += fmt.Sprintf("Extractor %s failed: %v\n", extractor.Info().Name, err)
+			}
+		}
+		async.SetResult(videos, err, warn)
+	}()
+	return &async, nil
+}
+
+// Update all the extractors and return the first error.
+func (fe *FallbackExtractor) Update() error {
+	var firstErr error
+	for _, extractor := range fe.Extractors {
+		if err := extractor.Update(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Info return the info of the first extractor.
+func (fe *FallbackExtractor) Info() ExtractorInfo {
+	if len(fe.Extractors) == 0 {
+		return ExtractorInfo{}
+	}
+	return fe.Extractors[0].Info()
+}
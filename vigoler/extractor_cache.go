@@ -0,0 +1,297 @@
+package vigoler
+
+import (
+	"container/list"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	str "strings"
+	"sync"
+	"time"
+)
+
+// expireMargin is the time before the expiry of the format urls that the cached result is not used anymore.
+const expireMargin = time.Minute
+
+// trackingParameters are query parameters that does not change the video.
+var trackingParameters = []string{"utm_"}
+
+// youtubeTrackingParameters are query parameters of youtube urls that does not change the video.
+var youtubeTrackingParameters = []string{"feature", "si"}
+
+// CachedExtractor cache the results of the extractor by the normalized url. A result is used until its ttl pass or
+// until the first format url expire. Results with errors or with live or upcoming videos are not cached because their
+// status change. When there are more than MaxEntries results the least recently used is removed. Requests of url that
+// is already extracted wait for the running extraction instead of running the extractor again.
+type CachedExtractor struct {
+	Extractor  Extractor
+	TTL        time.Duration
+	MaxEntries int
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	inFlight   map[string]*inFlightCall
+	stats      CacheStats
+	now        func() time.Time
+}
+
+// CacheStats is the metrics of CachedExtractor.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+type cacheEntry struct {
+	key    string
+	videos []VideoUrl
+	warn   string
+	expire time.Time
+}
+
+// inFlightCall is running extraction, done is closed when the result is set.
+type inFlightCall struct {
+	done   chan struct{}
+	videos []VideoUrl
+	err    error
+	warn   string
+}
+
+func CreateCachedExtractor(extractor Extractor, ttl time.Duration, maxEntries int) *CachedExtractor {
+	return &CachedExtractor{Extractor: extractor, TTL: ttl, MaxEntries: maxEntries, entries: make(map[string]*list.Element),
+		lru: list.New(), inFlight: make(map[string]*inFlightCall), now: time.Now}
+}
+
+// NormalizeURL return the url in a form that is the same for urls of the same video. The host is lower case, the
+// fragment and tracking parameters are removed and the query is sorted. Youtube urls are also https without www or m
+// and youtu.be links are expanded.
+func NormalizeURL(rawURL string) string {
+	u, err := url.Parse(str.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return str.TrimSpace(rawURL)
+	}
+	u.Host = str.ToLower(u.Host)
+	u.Fragment = ""
+	query := u.Query()
+	tracking := trackingParameters
+	if host := str.TrimPrefix(str.TrimPrefix(u.Host, "www."), "m."); host == "youtube.com" || host == "youtu.be" {
+		u.Scheme, u.Host = "https", host
+		tracking = append(append([]string(nil), trackingParameters...), youtubeTrackingParameters...)
+	}
+	if u.Host == "youtu.be" {
+		query.Set("v", str.Trim(u.Path, "/"))
+		u.Host, u.Path = "youtube.com", "/watch"
+	}
+	for key := range query {
+		for _, parameter := range tracking {
+			if key == parameter || (str.HasSuffix(parameter, "_") && str.HasPrefix(key, parameter)) {
+				query.Del(key)
+			}
+		}
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			values = append(values, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	u.RawQuery = str.Join(values, "&")
+	u.Path = str.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// urlExpire return the expiry of signed url from its expire query parameter or expire path segment, zero if the url
+// does not expire.
+func urlExpire(rawURL string) time.Time {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}
+	}
+	value := u.Query().Get("expire")
+	if value == "" {
+		segments := str.Split(u.Path, "/")
+		for i := 0; i < len(segments)-1; i++ {
+			if segments[i] == "expire" {
+				value = segments[i+1]
+				break
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// videosExpire return when the cached videos should not be used. ok is false if the videos should not be cached.
+func (ce *CachedExtractor) videosExpire(videos []VideoUrl) (expire time.Time, ok bool) {
+	expire = ce.now().Add(ce.TTL)
+	for _, video := range videos {
+		if video.IsUpcoming || video.IsLive {
+			return time.Time{}, false
+		}
+		for _, format := range video.Formats {
+			if formatExpire := urlExpire(format.URL); !formatExpire.IsZero() && formatExpire.Add(-expireMargin).Before(expire) {
+				expire = formatExpire.Add(-expireMargin)
+			}
+		}
+	}
+	return expire, len(videos) != 0 && expire.After(ce.now())
+}
+func (ce *CachedExtractor) get(key string) (*cacheEntry, bool) {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	if element, ok := ce.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if ce.now().Before(entry.expire) {
+			ce.lru.MoveToFront(element)
+			ce.stats.Hits++
+			return entry, true
+		}
+		ce.removeElement(element)
+	}
+	ce.stats.Misses++
+	return nil, false
+}
+
+// removeElement must be called when the mutex is locked.
+func (ce *CachedExtractor) removeElement(element *list.Element) {
+	ce.lru.Remove(element)
+	delete(ce.entries, element.Value.(*cacheEntry).key)
+}
+func (ce *CachedExtractor) set(entry *cacheEntry) {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	if element, ok := ce.entries[entry.key]; ok {
+		ce.removeElement(element)
+	}
+	ce.entries[entry.key] = ce.lru.PushFront(entry)
+	for ce.MaxEntries > 0 && ce.lru.Len() > ce.MaxEntries {
+		ce.removeElement(ce.lru.Back())
+		ce.stats.Evictions++
+	}
+}
+
+// copyVideos return copy of the videos that does not share their formats with the cache, so callers can change them.
+func copyVideos(videos []VideoUrl) []VideoUrl {
+	if videos == nil {
+		return nil
+	}
+	copied := make([]VideoUrl, len(videos))
+	for i, video := range videos {
+		video.Formats = append([]Format(nil), video.Formats...)
+		copied[i] = video
+	}
+	return copied
+}
+
+// startCall return the running extraction of the key and false, or new extraction and true if there is none.
+func (ce *CachedExtractor) startCall(key string) (*inFlightCall, bool) {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	if call, ok := ce.inFlight[key]; ok {
+		return call, false
+	}
+	if ce.inFlight == nil {
+		ce.inFlight = make(map[string]*inFlightCall)
+	}
+	call := &inFlightCall{done: make(chan struct{})}
+	ce.inFlight[key] = call
+	return call, true
+}
+func (ce *CachedExtractor) finishCall(key string, call *inFlightCall, videos []VideoUrl, err error, warn string) {
+	ce.mutex.Lock()
+	delete(ce.inFlight, key)
+	ce.mutex.Unlock()
+	call.videos, call.err, call.warn = videos, err, warn
+	close(call.done)
+}
+func (ce *CachedExtractor) GetUrls(url string) (*Async, error) {
+	key := NormalizeURL(url)
+	var wg sync.WaitGroup
+	if entry, ok := ce.get(key); ok {
+		async := CreateAsyncWaitGroup(&wg, nil)
+		async.SetResult(copyVideos(entry.videos), nil, entry.warn)
+		return &async, nil
+	}
+	call, isNew := ce.startCall(key)
+	if !isNew {
+		wg.Add(1)
+		async := CreateAsyncWaitGroup(&wg, nil)
+		go func() {
+			defer wg.Done()
+			<-call.done
+			async.SetResult(copyVideos(call.videos), call.err, call.warn)
+		}()
+		return &async, nil
+	}
+	as, err := ce.Extractor.GetUrls(url)
+	if err != nil {
+		ce.finishCall(key, call, nil, err, "")
+		return nil, err
+	}
+	wg.Add(1)
+	async := CreateAsyncFromAsyncAsWaitAble(&wg, as)
+	go func() {
+		defer wg.Done()
+		result, err, warn := as.Get()
+		videos, _ := result.([]VideoUrl)
+		if err == nil && result != nil {
+			if expire, ok := ce.videosExpire(videos); ok {
+				ce.set(&cacheEntry{key: key, videos: copyVideos(videos), warn: warn, expire: expire})
+			}
+		}
+		ce.finishCall(key, call, copyVideos(videos), err, warn)
+		async.SetResult(result, err, warn)
+	}()
+	return &async, nil
+}
+
+// Invalidate remove the cached result of the url.
+func (ce *CachedExtractor) Invalidate(url string) {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	if element, ok := ce.entries[NormalizeURL(url)]; ok {
+		ce.removeElement(element)
+	}
+}
+
+// Clear remove all the cached results.
+func (ce *CachedExtractor) Clear() {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	ce.entries = make(map[string]*list.Element)
+	ce.lru.Init()
+}
+func (ce *CachedExtractor) Stats() CacheStats {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	stats := ce.stats
+	stats.Entries = ce.lru.Len()
+	return stats
+}
+
+// Update update the extractor and clear the cache because the new version can extract different results.
+func (ce *CachedExtractor) Update() error {
+	err := ce.Extractor.Update()
+	ce.Clear()
+	return err
+}
+func (ce *CachedExtractor) Info() ExtractorInfo {
+	return ce.Extractor.Info()
+}
+
+// GetPlaylist list the playlist by the extractor without caching.
+func (ce *CachedExtractor) GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error) {
+	if playlistExtractor, ok := ce.Extractor.(PlaylistExtractor); ok {
+		return playlistExtractor.GetPlaylist(url, filter)
+	}
+	return nil, nil, &ArgumentError{stackTrack: debug.Stack(), argName: "Extractor", argValue: ce.Extractor}
+}
@@ -0,0 +1,175 @@
+package vigoler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.youtube.com/watch?v=id", "https://youtube.com/watch?v=id"},
+		{"http://m.YouTube.com/watch?feature=share&v=id#t=10", "https://youtube.com/watch?v=id"},
+		{"https://youtu.be/id?si=abc", "https://youtube.com/watch?v=id"},
+		{"https://www.youtube.com/watch?v=id&list=pl&utm_source=x", "https://youtube.com/watch?list=pl&v=id"},
+		{"https://example.com/videos/1/", "https://example.com/videos/1"},
+		{"http://www.Example.com/watch?v=1&feature=x&si=y&utm_medium=z", "http://www.example.com/watch?feature=x&si=y&v=1"},
+		{"https://m.example.com/video", "https://m.example.com/video"},
+		{" not a url ", "not a url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := NormalizeURL(tt.url); got != tt.want {
+				t.Errorf("NormalizeURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_urlExpire(t *testing.T) {
+	tests := []struct {
+		url  string
+		want time.Time
+	}{
+		{"https://rr1.googlevideo.com/videoplayback?expire=1600000000&ei=x", time.Unix(1600000000, 0)},
+		{"https://manifest.googlevideo.com/api/manifest/hls_variant/expire/1600000100/ei/x/index.m3u8", time.Unix(1600000100, 0)},
+		{"https://example.com/video.mp4", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := urlExpire(tt.url); !got.Equal(tt.want) {
+				t.Errorf("urlExpire() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func getCachedUrls(t *testing.T, ce *CachedExtractor, url string) ([]VideoUrl, error) {
+	async, err := ce.GetUrls(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err, _ := async.Get()
+	return result.([]VideoUrl), err
+}
+func TestCachedExtractor_GetUrls(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	extractor := &testExtractor{videos: []VideoUrl{{ID: "id", Formats: []Format{{FormatID: "18", URL: "https://example.com/18"}}}}}
+	ce := CreateCachedExtractor(extractor, time.Hour, 2)
+	ce.now = func() time.Time {
+		return now
+	}
+	for _, url := range []string{"https://www.youtube.com/watch?v=id", "https://youtu.be/id"} {
+		if videos, err := getCachedUrls(t, ce, url); err != nil || len(videos) != 1 {
+			t.Fatalf("GetUrls() = %v, %v", videos, err)
+		}
+	}
+	if stats := ce.Stats(); extractor.calls != 1 || stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("GetUrls() calls = %v, stats = %+v", extractor.calls, stats)
+	}
+	t.Run("copy formats", func(t *testing.T) {
+		videos, _ := getCachedUrls(t, ce, "https://youtu.be/id")
+		videos[0].Formats[0].URL = "changed"
+		if videos, _ = getCachedUrls(t, ce, "https://youtu.be/id"); videos[0].Formats[0].URL != "https://example.com/18" {
+			t.Errorf("GetUrls() cached format was changed to %s", videos[0].Formats[0].URL)
+		}
+	})
+	t.Run("ttl", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		getCachedUrls(t, ce, "https://youtu.be/id")
+		if extractor.calls != 2 {
+			t.Errorf("GetUrls() calls = %v after ttl", extractor.calls)
+		}
+	})
+	t.Run("invalidate", func(t *testing.T) {
+		ce.Invalidate("https://www.youtube.com/watch?v=id")
+		getCachedUrls(t, ce, "https://youtu.be/id")
+		if extractor.calls != 3 {
+			t.Errorf("GetUrls() calls = %v after invalidate", extractor.calls)
+		}
+	})
+	t.Run("format url expire", func(t *testing.T) {
+		ce.Clear()
+		extractor.videos = []VideoUrl{{ID: "id", Formats: []Format{{URL: fmt.Sprintf("https://example.com/?expire=%d", now.Add(10*time.Minute).Unix())}}}}
+		getCachedUrls(t, ce, "https://youtu.be/expire")
+		now = now.Add(9 * time.Minute)
+		getCachedUrls(t, ce, "https://youtu.be/expire")
+		if extractor.calls != 5 {
+			t.Errorf("GetUrls() calls = %v, expired format url was used", extractor.calls)
+		}
+	})
+	t.Run("not cached", func(t *testing.T) {
+		calls := extractor.calls
+		extractor.videos = []VideoUrl{{ID: "id", IsUpcoming: true}}
+		getCachedUrls(t, ce, "https://youtu.be/upcoming")
+		getCachedUrls(t, ce, "https://youtu.be/upcoming")
+		extractor.videos = []VideoUrl{{ID: "id", IsLive: true}}
+		getCachedUrls(t, ce, "https://youtu.be/live")
+		getCachedUrls(t, ce, "https://youtu.be/live")
+		extractor.videos, extractor.err = []VideoUrl{{ID: "id"}}, errors.New("ERROR: failed")
+		getCachedUrls(t, ce, "https://youtu.be/error")
+		getCachedUrls(t, ce, "https://youtu.be/error")
+		if extractor.calls != calls+6 {
+			t.Errorf("GetUrls() calls = %v, want %v", extractor.calls, calls+6)
+		}
+		extractor.err = nil
+	})
+	t.Run("max entries", func(t *testing.T) {
+		ce.Clear()
+		for _, id := range []string{"1", "2", "1", "3"} {
+			getCachedUrls(t, ce, "https://youtu.be/"+id)
+		}
+		calls := extractor.calls
+		getCachedUrls(t, ce, "https://youtu.be/1")
+		getCachedUrls(t, ce, "https://youtu.be/2")
+		if stats := ce.Stats(); extractor.calls != calls+1 || stats.Entries != 2 || stats.Evictions < 1 {
+			t.Errorf("GetUrls() calls = %v, want %v, stats = %+v", extractor.calls, calls+1, stats)
+		}
+	})
+}
+
+type blockingExtractor struct {
+	testExtractor
+	release chan struct{}
+}
+
+func (be *blockingExtractor) GetUrls(url string) (*Async, error) {
+	be.calls++
+	var wg sync.WaitGroup
+	wg.Add(1)
+	async := CreateAsyncWaitGroup(&wg, nil)
+	go func() {
+		defer wg.Done()
+		<-be.release
+		async.SetResult(be.videos, nil, "")
+	}()
+	return &async, nil
+}
+func TestCachedExtractor_GetUrlsInFlight(t *testing.T) {
+	extractor := &blockingExtractor{testExtractor: testExtractor{videos: []VideoUrl{{ID: "id", IsLive: true}}}, release: make(chan struct{})}
+	ce := CreateCachedExtractor(extractor, time.Hour, 0)
+	first, err := ce.GetUrls("https://www.youtube.com/watch?v=id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ce.GetUrls("https://youtu.be/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(extractor.release)
+	for _, async := range []*Async{first, second} {
+		if result, err, _ := async.Get(); err != nil || len(result.([]VideoUrl)) != 1 {
+			t.Errorf("GetUrls() = %v, %v", result, err)
+		}
+	}
+	if extractor.calls != 1 {
+		t.Errorf("GetUrls() calls = %v, want one extraction for concurrent requests", extractor.calls)
+	}
+	getCachedUrls(t, ce, "https://youtu.be/id")
+	if extractor.calls != 2 {
+		t.Errorf("GetUrls() calls = %v, live result was cached", extractor.calls)
+	}
+}
@@ -0,0 +1,174 @@
+package vigoler
+
+import (
+	"errors"
+	"fmt"
+	str "strings"
+)
+
+// Codes of the extractor errors. The codes are stable and can be used by clients to identify the error.
+const (
+	ErrorCodeVideoUnavailable = "video_unavailable"
+	ErrorCodePrivateVideo     = "private_video"
+	ErrorCodeGeoBlocked       = "geo_blocked"
+	ErrorCodeAgeRestricted    = "age_restricted"
+	ErrorCodeLoginRequired    = "login_required"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeUnsupportedURL   = "unsupported_url"
+	ErrorCodeLiveNotStarted   = "live_not_started"
+)
+
+// CodedError is an error with a stable code.
+type CodedError interface {
+	TypedError
+	Code() string
+}
+type VideoUnavailableError struct {
+	Video        string
+	ErrorMessage string
+}
+type PrivateVideoError struct {
+	Video        string
+	ErrorMessage string
+}
+type GeoBlockedError struct {
+	Video        string
+	ErrorMessage string
+}
+type AgeRestrictedError struct {
+	Video        string
+	ErrorMessage string
+}
+type LoginRequiredError struct {
+	Video        string
+	ErrorMessage string
+}
+type RateLimitedError struct {
+	Video        string
+	ErrorMessage string
+}
+type UnsupportedURLError struct {
+	Video        string
+	ErrorMessage string
+}
+type LiveNotStartedError struct {
+	Video        string
+	ErrorMessage string
+}
+
+// extractorErrorMessages map parts of the extractor error messages to the error they represent. The messages are
+// checked by order so more specific messages are first.
+var extractorErrorMessages = []struct {
+	messages []string
+	create   func(video, message string) error
+}{
+	{[]string{"live event will begin in", "Premieres in", "Premiere will begin"}, func(video, message string) error {
+		return &LiveNotStartedError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"Unsupported URL"}, func(video, message string) error {
+		return &UnsupportedURLError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"HTTP Error 429", "Too Many Requests", "rate-limit", "rate limit"}, func(video, message string) error {
+		return &RateLimitedError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"available in your country", "geo restriction", "geo-restricted", "not available from your location"}, func(video, message string) error {
+		return &GeoBlockedError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"confirm your age", "age-restricted", "age restricted", "inappropriate for some users"}, func(video, message string) error {
+		return &AgeRestrictedError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"Private video", "video is private", "granted you access"}, func(video, message string) error {
+		return &PrivateVideoError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"Sign in", "sign in", "login required", "log in", "members-only", "Join this channel", "--cookies", "--username"}, func(video, message string) error {
+		return &LoginRequiredError{Video: video, ErrorMessage: message}
+	}},
+	{[]string{"Video unavailable", "video is unavailable", "video is not available", "has been removed", "does not exist",
+		"HTTP Error 404", "account associated with this video has been terminated"}, func(video, message string) error {
+		return &VideoUnavailableError{Video: video, ErrorMessage: message}
+	}},
+}
+
+// classifyExtractorError return the typed error of the extractor error message. Unknown messages are returned as is.
+func classifyExtractorError(video, message string) error {
+	for _, errorMessages := range extractorErrorMessages {
+		for _, m := range errorMessages.messages {
+			if str.Contains(message, m) {
+				return errorMessages.create(video, message)
+			}
+		}
+	}
+	return errors.New(message)
+}
+func (e *VideoUnavailableError) Error() string {
+	return fmt.Sprintf("Video %s is unavailable. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *VideoUnavailableError) Type() string {
+	return "Video unavailable error"
+}
+func (e *VideoUnavailableError) Code() string {
+	return ErrorCodeVideoUnavailable
+}
+func (e *PrivateVideoError) Error() string {
+	return fmt.Sprintf("Video %s is private. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *PrivateVideoError) Type() string {
+	return "Private video error"
+}
+func (e *PrivateVideoError) Code() string {
+	return ErrorCodePrivateVideo
+}
+func (e *GeoBlockedError) Error() string {
+	return fmt.Sprintf("Video %s is not available in this location. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *GeoBlockedError) Type() string {
+	return "Geo blocked error"
+}
+func (e *GeoBlockedError) Code() string {
+	return ErrorCodeGeoBlocked
+}
+func (e *AgeRestrictedError) Error() string {
+	return fmt.Sprintf("Video %s is age restricted. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *AgeRestrictedError) Type() string {
+	return "Age restricted error"
+}
+func (e *AgeRestrictedError) Code() string {
+	return ErrorCodeAgeRestricted
+}
+func (e *LoginRequiredError) Error() string {
+	return fmt.Sprintf("Video %s require login. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *LoginRequiredError) Type() string {
+	return "Login required error"
+}
+func (e *LoginRequiredError) Code() string {
+	return ErrorCodeLoginRequired
+}
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("Rate limited while requested %s. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *RateLimitedError) Type() string {
+	return "Rate limited error"
+}
+func (e *RateLimitedError) Code() string {
+	return ErrorCodeRateLimited
+}
+func (e *UnsupportedURLError) Error() string {
+	return fmt.Sprintf("Url %s is not supported. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *UnsupportedURLError) Type() string {
+	return "Unsupported url error"
+}
+func (e *UnsupportedURLError) Code() string {
+	return ErrorCodeUnsupportedURL
+}
+func (e *LiveNotStartedError) Error() string {
+	return fmt.Sprintf("Live %s did not start yet. error message is: %s", e.Video, e.ErrorMessage)
+}
+func (e *LiveNotStartedError) Type() string {
+	return "Live not started error"
+}
+func (e *LiveNotStartedError) Code() string {
+	return ErrorCodeLiveNotStarted
+}
@@ -0,0 +1,46 @@
+package vigoler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_classifyExtractorError(t *testing.T) {
+	video := "https://www.youtube.com/watch?v=ERROR_VIDEO"
+	tests := []struct {
+		message string
+		want    error
+		code    string
+	}{
+		{"ERROR: [youtube] ERROR_VIDEO: Video unavailable", &VideoUnavailableError{}, ErrorCodeVideoUnavailable},
+		{"ERROR: [youtube] ERROR_VIDEO: Private video. Sign in if you've been granted access to this video", &PrivateVideoError{}, ErrorCodePrivateVideo},
+		{"ERROR: [youtube] ERROR_VIDEO: The uploader has not made this video available in your country", &GeoBlockedError{}, ErrorCodeGeoBlocked},
+		{"ERROR: [youtube] ERROR_VIDEO: Sign in to confirm your age. This video may be inappropriate for some users.", &AgeRestrictedError{}, ErrorCodeAgeRestricted},
+		{"ERROR: [youtube] ERROR_VIDEO: Join this channel to get access to members-only content like this video", &LoginRequiredError{}, ErrorCodeLoginRequired},
+		{"ERROR: [youtube] ERROR_VIDEO: Sign in to confirm you're not a bot. Use --cookies-from-browser or --cookies for the authentication.", &LoginRequiredError{}, ErrorCodeLoginRequired},
+		{"ERROR: Unable to download webpage: HTTP Error 429: Too Many Requests", &RateLimitedError{}, ErrorCodeRateLimited},
+		{"ERROR: Unsupported URL: https://example.com/", &UnsupportedURLError{}, ErrorCodeUnsupportedURL},
+		{"ERROR: [youtube] ERROR_VIDEO: This live event will begin in 3 hours.", &LiveNotStartedError{}, ErrorCodeLiveNotStarted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			err := classifyExtractorError(video, tt.message)
+			if reflect.TypeOf(err) != reflect.TypeOf(tt.want) {
+				t.Fatalf("classifyExtractorError() = %T, want %T", err, tt.want)
+			}
+			coded := err.(CodedError)
+			if coded.Code() != tt.code {
+				t.Errorf("Code() = %s, want %s", coded.Code(), tt.code)
+			}
+			if reflect.ValueOf(err).Elem().FieldByName("ErrorMessage").String() != tt.message {
+				t.Errorf("ErrorMessage = %s, want %s", err, tt.message)
+			}
+		})
+	}
+	if err := classifyExtractorError(video, "ERROR: something else"); err.Error() != "ERROR: something else" {
+		t.Errorf("classifyExtractorError() = %v for unknown message", err)
+	}
+	if _, isCoded := classifyExtractorError(video, "ERROR: something else").(CodedError); isCoded {
+		t.Errorf("classifyExtractorError() return coded error for unknown message")
+	}
+}
@@ -0,0 +1,123 @@
+package vigoler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testExtractor struct {
+	name   string
+	videos []VideoUrl
+	err    error
+	calls  int
+}
+
+func (te *testExtractor) GetUrls(url string) (*Async, error) {
+	te.calls++
+	var wg sync.WaitGroup
+	async := CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(te.videos, te.err, "")
+	return &async, nil
+}
+func (te *testExtractor) Update() error {
+	return nil
+}
+func (te *testExtractor) Info() ExtractorInfo {
+	return ExtractorInfo{Name: te.name}
+}
+func TestFallbackExtractor_GetUrls(t *testing.T) {
+	videos := []VideoUrl{{ID: "id"}}
+	tests := []struct {
+		name       string
+		first      *testExtractor
+		second     *testExtractor
+		wantCalls  int
+		wantVideos int
+		wantErr    bool
+	}{
+		{"first succeed", &testExtractor{videos: videos}, &testExtractor{videos: videos}, 0, 1, false},
+		{"first failed", &testExtractor{err: errors.New("ERROR: unsupported")}, &testExtractor{videos: videos}, 1, 1, false},
+		{"first empty", &testExtractor{}, &testExtractor{videos: videos}, 1, 1, false},
+		{"live not started", &testExtractor{err: &LiveNotStartedError{}}, &testExtractor{videos: videos}, 0, 0, true},
+		{"all failed", &testExtractor{err: errors.New("first")}, &testExtractor{err: errors.New("second")}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := FallbackExtractor{Extractors: []Extractor{tt.first, tt.second}}
+			async, err := fe.GetUrls("url")
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err, _ := async.Get()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetUrls() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(result.([]VideoUrl)) != tt.wantVideos {
+				t.Errorf("GetUrls() videos = %v, want %v", result, tt.wantVideos)
+			}
+			if tt.second.calls != tt.wantCalls {
+				t.Errorf("GetUrls() fallback calls = %v, want %v", tt.second.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+func TestCreateExtractor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test use shell script as the extractor")
+	}
+	tests := []struct {
+		name           string
+		help           string
+		wantLiveStatus bool
+	}{
+		{"wait for video", "  --wait-for-video MIN[-MAX]  Wait for scheduled streams", true},
+		{"old version", "  --match-filter FILTER", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "yt-dlp")
+			script := fmt.Sprintf(`#!/bin/sh
+case "$1" in
+--help) echo '%s';;
+--version) echo 2021.12.01;;
+*) echo "{\"id\": \"id\", \"title\": \"$*\", \"live_status\": \"is_upcoming\"}";;
+esac
+`, tt.help)
+			if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			extractor, err := CreateExtractor(YtDlpExtractor, path)
+			if err != nil {
+				t.Fatalf("CreateExtractor() error = %v", err)
+			}
+			if info := extractor.Info(); info.Version != "2021.12.01" || !info.Available || info.LiveStatus != tt.wantLiveStatus {
+				t.Errorf("CreateExtractor() info = %+v", info)
+			}
+			async, err := extractor.GetUrls("https://site/upcoming")
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err, _ := async.Get()
+			videos, _ := result.([]VideoUrl)
+			if err != nil || len(videos) != 1 || !videos[0].IsUpcoming {
+				t.Fatalf("GetUrls() = %v, %v", result, err)
+			}
+			if ignoreNoFormats := strings.Contains(videos[0].Name, "--ignore-no-formats-error"); ignoreNoFormats != tt.wantLiveStatus {
+				t.Errorf("GetUrls() arguments = %s", videos[0].Name)
+			}
+		})
+	}
+	extractor, err := CreateExtractor(YoutubeDlExtractor, filepath.Join(t.TempDir(), "missing"))
+	if _, ok := err.(*ExtractorNotAvailableError); !ok || extractor.Info().Available {
+		t.Errorf("CreateExtractor() missing binary error = %v", err)
+	}
+	if _, err = CreateExtractor("unknown", ""); err == nil {
+		t.Errorf("CreateExtractor() unknown extractor error = nil")
+	}
+}
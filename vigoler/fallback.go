@@ -0,0 +1,134 @@
+package vigoler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxFormatFallbacks is the number of formats that are tried after the download of the first format failed.
+const maxFormatFallbacks = 3
+
+// isFormatError return if the download failed because of the format. Other errors like errors of the video or of the
+// disk fail the download of every format so the next formats are not tried.
+func isFormatError(err error) bool {
+	switch err.(type) {
+	case *CancelError, *os.PathError, *os.LinkError, *os.SyscallError, *FileTooBigError, *VideoUnavailableError,
+		*PrivateVideoError, *GeoBlockedError, *AgeRestrictedError, *LoginRequiredError, *RateLimitedError,
+		*LiveNotStartedError:
+		return false
+	}
+	return true
+}
+
+// fallbackFormats return the first format and the formats after it that are tried when its download fail. Formats
+// with less than half of the height of the first format, or of its audio bitrate for audio formats, are not an
+// acceptable replacement and are not tried.
+func fallbackFormats(formats []Format) []Format {
+	if len(formats) == 0 {
+		return formats
+	}
+	first := formats[0]
+	fallbacks := []Format{first}
+	for _, f := range formats[1:] {
+		if len(fallbacks) > maxFormatFallbacks {
+			break
+		}
+		if (first.Height > 0 && f.Height > 0 && f.Height < first.Height/2) ||
+			(first.Height <= 0 && first.ABR > 0 && f.ABR > 0 && f.ABR < first.ABR/2) {
+			continue
+		}
+		fallbacks = append(fallbacks, f)
+	}
+	return fallbacks
+}
+
+// sizeFallbacks return format and the formats after it in formats whose estimated size surely fit in sizeInKb.
+func sizeFallbacks(url VideoUrl, format Format, formats []Format, sizeInKb int) []Format {
+	fallbacks := []Format{format}
+	found := false
+	for _, f := range formats {
+		if f.FormatID == format.FormatID {
+			found = true
+		} else if found && EstimateFormatSize(f, url.Duration).Fits(float64(sizeInKb)) {
+			fallbacks = append(fallbacks, f)
+		}
+	}
+	return fallbacks
+}
+
+// startFormatDownload start the download of the first format from index that can be started. When RefreshOnFallback
+// is true formats after the first are extracted again before their download. The index of the started format is
+// returned.
+func (vu *VideoUtils) startFormatDownload(url VideoUrl, formats []Format, index int, ext string) (int, *Async, string, error) {
+	warn := ""
+	for ; ; index++ {
+		format := formats[index]
+		var err error
+		if vu.RefreshOnFallback && index > 0 {
+			format, err = vu.refreshFormat(url, format)
+		}
+		var download *Async
+		if err == nil {
+			if download, err = vu.downloadFormat(url, format, ext); err == nil {
+				return index, download, warn, nil
+			}
+		}
+		warn += fmt.Sprintf("Download of format %s failed: %v.\n", format.FormatID, err)
+		if index == len(formats)-1 || !isFormatError(err) {
+			return index, nil, warn, err
+		}
+	}
+}
+
+// downloadFormats download the first format of formats, formats are ordered from the most wanted. When the download
+// of format fail because of the format the next acceptable format is downloaded (see fallbackFormats) and the formats
+// that failed are reported in the warnings.
+func (vu *VideoUtils) downloadFormats(url VideoUrl, formats []Format, ext string) (*Async, error) {
+	formats = fallbackFormats(formats)
+	if len(formats) == 0 {
+		return nil, &FormatNotFoundError{videos: []VideoUrl{url}}
+	}
+	if len(formats) == 1 {
+		return vu.downloadFormat(url, formats[0], ext)
+	}
+	index, download, sWarn, err := vu.startFormatDownload(url, formats, 0, ext)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tWarn := sWarn
+		for {
+			output, err, warn := download.Get()
+			wa.remove(download)
+			tWarn += warn
+			if _, isCancel := err.(*CancelError); err != nil && !isCancel {
+				tWarn += fmt.Sprintf("Download of format %s failed: %v.\n", formats[index].FormatID, err)
+			}
+			if err == nil || async.isStopped || index == len(formats)-1 || !isFormatError(err) {
+				async.SetResult(output, err, tWarn)
+				return
+			}
+			if output != nil {
+				_ = os.Remove(output.(string))
+			}
+			index, download, warn, err = vu.startFormatDownload(url, formats, index+1, ext)
+			tWarn += warn
+			if err != nil {
+				async.SetResult(nil, err, tWarn)
+				return
+			}
+			wa.add(download)
+			if async.isStopped {
+				_ = download.Stop()
+			}
+		}
+	}()
+	return &async, nil
+}
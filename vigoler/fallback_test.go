@@ -0,0 +1,144 @@
+package vigoler
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestVideoUtils_downloadFormatsFallback(t *testing.T) {
+	content := testVideoContent(10000)
+	server := startCurlTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/good" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	})
+	format := func(id, path string) Format {
+		return Format{FormatID: id, URL: server.URL + path, Protocol: "https", HTTPHeaders: map[string]string{}}
+	}
+	curl := CreateCurlWrapper(1)
+	tests := []struct {
+		name     string
+		formats  []Format
+		wantErr  bool
+		wantWarn []string
+	}{
+		{"first", []Format{format("1", "/good"), format("2", "/bad")}, false, nil},
+		{"fallback", []Format{format("1", "/bad"), format("2", "/bad"), format("3", "/good")}, false, []string{"1", "2"}},
+		{"all fail", []Format{format("1", "/bad"), format("2", "/bad")}, true, []string{"1", "2"}},
+		{"fallbacks cap", []Format{format("1", "/bad"), format("2", "/bad"), format("3", "/bad"), format("4", "/bad"), format("5", "/good")}, true, []string{"1", "2", "3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vu := VideoUtils{Extractor: &testExtractor{}, Curl: &curl}
+			url := VideoUrl{ID: "id", WebPageURL: "https://example.com/watch?v=id", Formats: tt.formats}
+			async, err := vu.downloadFormats(url, tt.formats, "mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err, warn := async.Get()
+			if output != nil {
+				defer os.Remove(output.(string))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadFormats() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if data, _ := ioutil.ReadFile(output.(string)); !bytes.Equal(data, content) {
+					t.Errorf("downloadFormats() downloaded %d bytes, want %d", len(data), len(content))
+				}
+			}
+			var failed []string
+			for _, line := range strings.Split(warn, "\n") {
+				if strings.HasPrefix(line, "Download of format ") {
+					failed = append(failed, strings.Fields(line)[3])
+				}
+			}
+			if !reflect.DeepEqual(failed, tt.wantWarn) {
+				t.Errorf("downloadFormats() failed formats = %v, want %v", failed, tt.wantWarn)
+			}
+		})
+	}
+}
+func Test_sizeFallbacks(t *testing.T) {
+	formats := []Format{
+		{FormatID: "1", FileSize: 900},
+		{FormatID: "2", FileSize: 500},
+		{FormatID: "3", FileSize: -1},
+		{FormatID: "4", FileSize: 400},
+		{FormatID: "5", FileSize: 1000},
+	}
+	tests := []struct {
+		name   string
+		format Format
+		want   []string
+	}{
+		{"first", formats[1], []string{"2", "4"}},
+		{"unknown", formats[2], []string{"3", "4"}},
+		{"last", formats[3], []string{"4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range sizeFallbacks(VideoUrl{}, tt.format, formats, 800) {
+				got = append(got, f.FormatID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sizeFallbacks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_isFormatError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"http", &HttpError{}, true},
+		{"other", errors.New("Exit status 22"), true},
+		{"cancel", &CancelError{}, false},
+		{"disk", &os.PathError{Op: "write", Path: "video.mp4", Err: syscall.ENOSPC}, false},
+		{"geo blocked", &GeoBlockedError{}, false},
+		{"too big", &FileTooBigError{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFormatError(tt.err); got != tt.want {
+				t.Errorf("isFormatError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_fallbackFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		formats []Format
+		want    []string
+	}{
+		{"empty", nil, nil},
+		{"cap", []Format{{FormatID: "1"}, {FormatID: "2"}, {FormatID: "3"}, {FormatID: "4"}, {FormatID: "5"}}, []string{"1", "2", "3", "4"}},
+		{"height floor", []Format{{FormatID: "1", Height: 1080}, {FormatID: "2", Height: 720}, {FormatID: "3", Height: 360}, {FormatID: "4", Height: 144}, {FormatID: "5"}}, []string{"1", "2", "5"}},
+		{"audio floor", []Format{{FormatID: "1", ABR: 160}, {FormatID: "2", ABR: 128}, {FormatID: "3", ABR: 48}}, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range fallbackFormats(tt.formats) {
+				got = append(got, f.FormatID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fallbackFormats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
 	"go.uber.org/zap"
 )
 
@@ -34,6 +36,27 @@ type DownloadSettings struct {
 	returnWaitError     bool
 }
 type FFmpegState func(sizeInKb, timeInSeconds int)
+type AudioTrack struct {
+	Path     string
+	Language string
+}
+
+// TranscodeSettings is how Transcode encode the input. Empty encoder copy the stream and zero limit is ignored.
+type TranscodeSettings struct {
+	VideoEncoder string
+	AudioEncoder string
+	MaxHeight    int
+	// MaxFPS limit the frame rate of the video, videos with lower frame rate keep their frame rate.
+	MaxFPS float64
+	// MaxVideoBitrate is in KBit/s.
+	MaxVideoBitrate int
+	// VideoBitrate and AudioBitrate are the average bitrate in KBit/s. Video with average bitrate is encoded in two
+	// passes.
+	VideoBitrate int
+	AudioBitrate int
+	// CRF is the constant quality of the video when VideoBitrate is not set, lower is better.
+	CRF int
+}
 type ffmpegWaitAble struct {
 	*commandWaitAble
 }
@@ -139,19 +162,204 @@ func createFfmpegArgs(output string, args ...string) []string {
 	finalArgs = append(finalArgs, args...)
 	return append(finalArgs, "-map_metadata", "0", "-c", "copy", output)
 }
+func mergeArgs(output, video string, audios []AudioTrack) []string {
+	// [-i {input}] [-map {input index}] [-metadata:s:a:{audio index} language={language}]
+	args := make([]string, 0, (len(audios)+1)*4+len(audios)*2)
+	args = append(args, "-i", video)
+	for _, audio := range audios {
+		args = append(args, "-i", audio.Path)
+	}
+	for i := 0; i <= len(audios); i++ {
+		args = append(args, "-map", strconv.Itoa(i))
+	}
+	for i, audio := range audios {
+		if audio.Language != "" {
+			args = append(args, "-metadata:s:a:"+strconv.Itoa(i), "language="+toContainerLanguage(audio.Language))
+		}
+	}
+	return createFfmpegArgs(output, args...)
+}
+
+// Merge the first input as the video with all the other inputs as audio tracks.
 func (ff *FFmpegWrapper) Merge(output string, input ...string) (*Async, error) {
-	// [-i {input}]
-	finalArgs := make([]string, 0, len(input)*2+3)
-	for _, i := range input {
-		finalArgs = append(finalArgs, "-i", i)
+	if len(input) == 0 {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "input", argValue: input}
+	}
+	audios := make([]AudioTrack, 0, len(input)-1)
+	for _, i := range input[1:] {
+		audios = append(audios, AudioTrack{Path: i})
 	}
-	wa, err := ff.ffmpeg.runCommandWait(context.Background(), finalArgs...)
+	return ff.MergeTracks(output, input[0], audios)
+}
+
+// MergeTracks mux video with all the audios into output and set the language of every audio stream that has one.
+func (ff *FFmpegWrapper) MergeTracks(output, video string, audios []AudioTrack) (*Async, error) {
+	wa, err := ff.ffmpeg.runCommandWait(context.Background(), mergeArgs(output, video, audios)...)
 	if err != nil {
 		return nil, err
 	}
 	async := createAsyncWaitAble(wa)
 	return &async, err
 }
+
+// Remux copy all the streams of input to output without encoding them.
+func (ff *FFmpegWrapper) Remux(input, output string) (*Async, error) {
+	wa, err := ff.ffmpeg.runCommandWait(context.Background(), createFfmpegArgs(output, "-i", input)...)
+	if err != nil {
+		return nil, err
+	}
+	async := createAsyncWaitAble(wa)
+	return &async, nil
+}
+func transcodeArgs(input, output string, settings TranscodeSettings) []string {
+	// ffmpeg command template: ffmpeg -v warning -stats -i {input} -map 0 -c:v {encoder} [-vf scale] [-fpsmax] [-b:v | -crf] [-maxrate -bufsize] -c:a {encoder} [-b:a] -map_metadata 0 {output}
+	args := []string{"-v", "warning", "-stats", "-i", input, "-map", "0"}
+	if settings.VideoEncoder == "" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", settings.VideoEncoder)
+		if settings.MaxHeight > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(ih,%d)'", settings.MaxHeight))
+		}
+		if settings.MaxFPS > 0 {
+			args = append(args, "-fpsmax", strconv.FormatFloat(settings.MaxFPS, 'f', -1, 64))
+		}
+		if settings.VideoBitrate > 0 {
+			args = append(args, "-b:v", strconv.Itoa(settings.VideoBitrate)+"k")
+		} else if settings.CRF > 0 {
+			args = append(args, "-crf", strconv.Itoa(settings.CRF))
+		}
+		if settings.MaxVideoBitrate > 0 {
+			args = append(args, "-maxrate", strconv.Itoa(settings.MaxVideoBitrate)+"k", "-bufsize", strconv.Itoa(2*settings.MaxVideoBitrate)+"k")
+		}
+	}
+	if settings.AudioEncoder == "" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", settings.AudioEncoder)
+		if settings.AudioBitrate > 0 {
+			args = append(args, "-b:a", strconv.Itoa(settings.AudioBitrate)+"k")
+		}
+	}
+	return append(args, "-map_metadata", "0", output)
+}
+
+// twoPassArgs return the arguments of pass 1 or 2 of two pass encoding. The first pass only analyze the video into
+// passLog.
+func twoPassArgs(input, output string, settings TranscodeSettings, pass int, passLog string) []string {
+	args := transcodeArgs(input, output, settings)
+	args = append(args[:len(args)-1], "-pass", strconv.Itoa(pass), "-passlogfile", passLog)
+	if pass == 1 {
+		return append(args, "-an", "-f", "null", "-y", os.DevNull)
+	}
+	return append(args, output)
+}
+
+// Transcode encode input to output by settings.
+func (ff *FFmpegWrapper) Transcode(input, output string, settings TranscodeSettings) (*Async, error) {
+	if settings.VideoEncoder != "" && settings.VideoBitrate > 0 {
+		return ff.twoPassTranscode(input, output, settings)
+	}
+	wa, err := ff.ffmpeg.runCommandWait(context.Background(), transcodeArgs(input, output, settings)...)
+	if err != nil {
+		return nil, err
+	}
+	async := createAsyncWaitAble(wa)
+	return &async, nil
+}
+
+func splitArgs(input, outputPattern, segmentList string, times []float64) []string {
+	segmentTimes := make([]string, 0, len(times))
+	for _, t := range times {
+		segmentTimes = append(segmentTimes, strconv.FormatFloat(t, 'f', 3, 64))
+	}
+	return []string{"-v", "warning", "-i", input, "-map", "0", "-c", "copy", "-f", "segment", "-segment_times",
+		strings.Join(segmentTimes, ","), "-segment_start_number", "1", "-reset_timestamps", "1", "-segment_list", segmentList,
+		"-segment_list_type", "flat", outputPattern}
+}
+
+// readSegmentList return the parts in the segment list of ffmpeg, the list has the base names of the parts in dir.
+func readSegmentList(segmentList, dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(segmentList)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			parts = append(parts, filepath.Join(dir, line))
+		}
+	}
+	return parts, nil
+}
+
+// Split copy input to parts that start at times. outputPattern is the name of the parts with %d for the 1 based
+// index of the part. Parts start at the first key frame after their time and the timestamps of every part start at 0,
+// so times in the same key frame interval create one part. The result is the names of the parts that were written.
+func (ff *FFmpegWrapper) Split(input, outputPattern string, times []float64) (*Async, error) {
+	segmentList := input + ".parts"
+	wa, err := ff.ffmpeg.runCommandWait(context.Background(), splitArgs(input, outputPattern, segmentList, times)...)
+	if err != nil {
+		return nil, err
+	}
+	splitAsync := createAsyncWaitAble(wa)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	async := CreateAsyncFromAsyncAsWaitAble(&wg, &splitAsync)
+	go func() {
+		defer wg.Done()
+		defer os.Remove(segmentList)
+		_, err, warn := splitAsync.Get()
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		parts, err := readSegmentList(segmentList, strings.ReplaceAll(filepath.Dir(outputPattern), "%%", "%"))
+		async.SetResult(parts, err, warn)
+	}()
+	return &async, nil
+}
+
+// twoPassTranscode encode input to output in two passes so the video bitrate is close to settings.VideoBitrate.
+func (ff *FFmpegWrapper) twoPassTranscode(input, output string, settings TranscodeSettings) (*Async, error) {
+	passLog := output + ".passlog"
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			files, _ := filepath.Glob(passLog + "*")
+			for _, file := range files {
+				_ = os.Remove(file)
+			}
+		}()
+		warn := ""
+		for pass := 1; pass <= 2; pass++ {
+			if async.isStopped {
+				async.SetResult(nil, &CancelError{}, warn)
+				return
+			}
+			passWa, err := ff.ffmpeg.runCommandWait(context.Background(), twoPassArgs(input, output, settings, pass, passLog)...)
+			if err != nil {
+				async.SetResult(nil, err, warn)
+				return
+			}
+			passAsync := createAsyncWaitAble(passWa)
+			wa.add(&passAsync)
+			_, err, passWarn := passAsync.Get()
+			wa.remove(&passAsync)
+			warn += passWarn
+			if err != nil {
+				async.SetResult(nil, err, warn)
+				return
+			}
+		}
+		async.SetResult(output, nil, warn)
+	}()
+	return &async, nil
+}
 func (ff *FFmpegWrapper) download(logger *zap.Logger, url string, setting DownloadSettings, output string, headers map[string]string, inputArgs ...string) (*Async, error) {
 	if len(url) == 0 {
 		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "url", argValue: url}
@@ -305,62 +513,69 @@ func (wa *ffmpegLiveUntilNowWa) Stop() error {
 	}
 	return nil
 }
-func checkIsSeekable(liveDesc string) bool {
-	const maxToNotSeekable = 100
-	n := strings.Count(liveDesc, "\n")
-	return n > maxToNotSeekable
-}
-func countLength(liveDesc string) (float64, error) {
-	l := 0.0
-	const lengthMark = "#EXTINF:"
-	for {
-		curMarkIndex := strings.Index(liveDesc, lengthMark)
-		if curMarkIndex == -1 {
-			return l, nil
-		}
-		liveDesc = liveDesc[curMarkIndex+len(lengthMark):]
-		curL, err := strconv.ParseFloat(liveDesc[:strings.Index(liveDesc, ",")], 64)
-		if err != nil {
-			return l, nil
-		}
-		l += curL
-	}
-}
-func (ff *FFmpegWrapper) DownloadLiveUntilNow(url string, output string) (*Async, error) {
+
+// downloadLivePlaylist download the live of the format from the segment that chooseStart return. The variant of master
+// playlist is chosen by the height of the format and the playlist and the segments are requested with its headers.
+// chooseStart return the index of the first segment (negative index count from the live edge) and the duration to download in seconds.
+func (ff *FFmpegWrapper) downloadLivePlaylist(format Format, output string, chooseStart func(playlist *m3u8.MediaPlaylist) (int, float64, string, error)) (*Async, error) {
 	var wg sync.WaitGroup
 	wa := ffmpegLiveUntilNowWa{wg: &wg, isStopped: false}
 	wg.Add(1)
 	async := CreateAsyncWaitGroup(&wg, &wa)
 	go func() {
 		defer wg.Done()
-		resp, err := http.DefaultClient.Get(url)
+		playlist, playlistURL, err := getMediaPlaylist(format.URL, format.HTTPHeaders, int(format.Height))
 		if err != nil {
 			async.SetResult(nil, err, "")
-		} else {
-			defer resp.Body.Close()
-			data, err := ioutil.ReadAll(resp.Body)
+			return
+		}
+		startIndex, maxTime, warn, err := chooseStart(playlist)
+		if err != nil {
+			async.SetResult(nil, err, warn)
+		} else if !wa.isStopped {
+			wa.dAsync, err = ff.download(nil, playlistURL, DownloadSettings{MaxTimeInSec: int(math.Ceil(maxTime))}, output, format.HTTPHeaders, "-live_start_index", strconv.Itoa(startIndex))
 			if err != nil {
-				async.SetResult(nil, err, "")
+				async.SetResult(nil, err, warn)
 			} else {
-				stringData := string(data)
-				if !checkIsSeekable(stringData) {
-					async.SetResult(nil, &UnsupportedSeekError{}, "")
-				} else {
-					maxTime, err := countLength(stringData)
-					if err != nil {
-						async.SetResult(nil, err, "")
-					} else if !wa.isStopped {
-						wa.dAsync, err = ff.download(nil, url, DownloadSettings{MaxTimeInSec: int(maxTime) + 60}, output, nil, "-live_start_index", "0")
-						if err != nil {
-							async.SetResult(nil, err, "")
-						} else {
-							_, err, warn := wa.dAsync.Get()
-							async.SetResult(nil, err, warn)
-						}
-					}
-				}
+				_, err, dWarn := wa.dAsync.Get()
+				async.SetResult(nil, err, warn+dWarn)
 			}
 		}
 	}()
 	return &async, nil
 }
+func (ff *FFmpegWrapper) DownloadLiveUntilNow(url string, output string) (*Async, error) {
+	return ff.DownloadLiveUntilNowFormat(Format{URL: url, Height: -1}, output)
+}
+
+// DownloadLiveUntilNowFormat download the live of the format from its start until now.
+func (ff *FFmpegWrapper) DownloadLiveUntilNowFormat(format Format, output string) (*Async, error) {
+	return ff.downloadLivePlaylist(format, output, func(playlist *m3u8.MediaPlaylist) (int, float64, string, error) {
+		if !isLiveSeekable(playlist) {
+			return 0, 0, "", &UnsupportedSeekError{}
+		}
+		return 0, playlist.Duration() + 60, "", nil
+	})
+}
+
+// DownloadLiveWindow download the last windowInSec seconds of the live of the format to one file.
+func (ff *FFmpegWrapper) DownloadLiveWindow(format Format, output string, windowInSec int) (*Async, error) {
+	if windowInSec <= 0 {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "windowInSec", argValue: windowInSec}
+	}
+	return ff.downloadLivePlaylist(format, output, func(playlist *m3u8.MediaPlaylist) (int, float64, string, error) {
+		segments := playlist.TrailingSegments(float64(windowInSec))
+		if len(segments) == 0 {
+			return 0, 0, "", &UnsupportedSeekError{}
+		}
+		duration := 0.0
+		for _, segment := range segments {
+			duration += segment.Duration
+		}
+		warn := ""
+		if duration < float64(windowInSec) {
+			warn = fmt.Sprintf("The live keep only %v seconds instead of the requested %d seconds.\n", duration, windowInSec)
+		}
+		return -len(segments), duration, warn, nil
+	})
+}
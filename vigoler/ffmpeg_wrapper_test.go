@@ -1,12 +1,21 @@
 package vigoler
 
 import (
+	"errors"
 	"io/ioutil"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
 )
 
 func readTestFile(fileName string) string {
@@ -16,49 +25,50 @@ func readTestFile(fileName string) string {
 	}
 	return string(data)
 }
-func Test_checkIsSeekable(t *testing.T) {
+func readTestPlaylist(t *testing.T, fileName string) *m3u8.MediaPlaylist {
+	_, playlist, err := m3u8.Parse(strings.NewReader(readTestFile(fileName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return playlist
+}
+func Test_isLiveSeekable(t *testing.T) {
 	type args struct {
-		liveDesc string
+		fileName string
 	}
 	tests := []struct {
 		name string
 		args args
 		want bool
 	}{
-		{name: "seekable", args: args{liveDesc: readTestFile("seekable")}, want: true},
-		{name: "not seekable", args: args{liveDesc: readTestFile("not_seekable")}, want: false},
+		{name: "seekable", args: args{fileName: "seekable"}, want: true},
+		{name: "not seekable", args: args{fileName: "not_seekable"}, want: false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := checkIsSeekable(tt.args.liveDesc); got != tt.want {
-				t.Errorf("checkIsSeekable() = %v, want %v", got, tt.want)
+			if got := isLiveSeekable(readTestPlaylist(t, tt.args.fileName)); got != tt.want {
+				t.Errorf("isLiveSeekable() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_countLength(t *testing.T) {
+func Test_playlistDuration(t *testing.T) {
 	type args struct {
-		liveDesc string
+		fileName string
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    float64
-		wantErr bool
+		name string
+		args args
+		want float64
 	}{
-		{name: "not seekable", args: args{liveDesc: readTestFile("not_seekable")}, want: 44.9, wantErr: false},
-		{name: "seekable", args: args{liveDesc: readTestFile("seekable")}, want: 7229.9, wantErr: false},
+		{name: "not seekable", args: args{fileName: "not_seekable"}, want: 44.9},
+		{name: "seekable", args: args{fileName: "seekable"}, want: 7229.9},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := countLength(tt.args.liveDesc)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("countLength() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if math.Abs(got-tt.want) > 0.0001 {
-				t.Errorf("countLength() = %v, want %v", got, tt.want)
+			if got := readTestPlaylist(t, tt.args.fileName).Duration(); math.Abs(got-tt.want) > 0.0001 {
+				t.Errorf("Duration() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -113,3 +123,90 @@ func TestFFmpegWrapper_downloadStopErr(t *testing.T) {
 		t.Fatalf("downloadstop() error = %v", err)
 	}
 }
+func TestFFmpegWrapper_downloadLivePlaylistFormat(t *testing.T) {
+	var requestMutex sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestMutex.Lock()
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("User-Agent"))
+		requestMutex.Unlock()
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=4096000,RESOLUTION=1280x720\n720.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1024000,RESOLUTION=640x360\n360.m3u8\n"))
+		case "/720.m3u8", "/360.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\n1.ts\n#EXTINF:4,\n2.ts\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	chooseErr := errors.New("stop before ffmpeg")
+	var segments int
+	ff := &FFmpegWrapper{}
+	format := Format{URL: server.URL + "/master.m3u8", Height: 360, HTTPHeaders: map[string]string{"User-Agent": "agent"}}
+	async, err := ff.downloadLivePlaylist(format, "output.ts", func(playlist *m3u8.MediaPlaylist) (int, float64, string, error) {
+		segments = len(playlist.Segments)
+		return 0, 0, "", chooseErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err, _ = async.Get(); err != chooseErr || segments != 2 {
+		t.Errorf("downloadLivePlaylist() error = %v, segments = %v", err, segments)
+	}
+	if want := []string{"/master.m3u8 agent", "/360.m3u8 agent"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("downloadLivePlaylist() requests = %v, want %v", requests, want)
+	}
+}
+func Test_mergeArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		audios []AudioTrack
+		want   []string
+	}{
+		{"single audio", []AudioTrack{{Path: "a.m4a"}}, []string{"-v", "warning", "-stats", "-i", "v.mp4", "-i", "a.m4a", "-map", "0", "-map", "1", "-map_metadata", "0", "-c", "copy", "out.mkv"}},
+		{"languages", []AudioTrack{{Path: "a.m4a", Language: "en-US"}, {Path: "b.m4a", Language: "xx"}, {Path: "c.m4a"}}, []string{"-v", "warning", "-stats", "-i", "v.mp4", "-i", "a.m4a", "-i", "b.m4a", "-i", "c.m4a", "-map", "0", "-map", "1", "-map", "2", "-map", "3", "-metadata:s:a:0", "language=eng", "-metadata:s:a:1", "language=und", "-map_metadata", "0", "-c", "copy", "out.mkv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeArgs("out.mkv", "v.mp4", tt.audios); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_transcodeArgs(t *testing.T) {
+	got := transcodeArgs("in.webm", "out.mp4", TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", MaxHeight: 1080, MaxFPS: 29.97, MaxVideoBitrate: 8000, CRF: 23})
+	want := []string{"-v", "warning", "-stats", "-i", "in.webm", "-map", "0", "-c:v", "libx264", "-vf", "scale=-2:'min(ih,1080)'", "-fpsmax", "29.97", "-crf", "23", "-maxrate", "8000k", "-bufsize", "16000k", "-c:a", "aac", "-map_metadata", "0", "out.mp4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transcodeArgs() = %v, want %v", got, want)
+	}
+}
+func Test_splitArgs(t *testing.T) {
+	got := splitArgs("in.mp4", "in.%d.mp4", "in.mp4.parts", []float64{100, 212.5})
+	want := []string{"-v", "warning", "-i", "in.mp4", "-map", "0", "-c", "copy", "-f", "segment", "-segment_times", "100.000,212.500",
+		"-segment_start_number", "1", "-reset_timestamps", "1", "-segment_list", "in.mp4.parts", "-segment_list_type", "flat", "in.%d.mp4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitArgs() = %v, want %v", got, want)
+	}
+}
+func Test_twoPassArgs(t *testing.T) {
+	settings := TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", VideoBitrate: 900, AudioBitrate: 96}
+	tests := []struct {
+		pass int
+		want []string
+	}{
+		{1, []string{"-v", "warning", "-stats", "-i", "in.webm", "-map", "0", "-c:v", "libx264", "-b:v", "900k", "-c:a", "aac", "-b:a", "96k", "-map_metadata", "0",
+			"-pass", "1", "-passlogfile", "out.mp4.passlog", "-an", "-f", "null", "-y", os.DevNull}},
+		{2, []string{"-v", "warning", "-stats", "-i", "in.webm", "-map", "0", "-c:v", "libx264", "-b:v", "900k", "-c:a", "aac", "-b:a", "96k", "-map_metadata", "0",
+			"-pass", "2", "-passlogfile", "out.mp4.passlog", "out.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.pass), func(t *testing.T) {
+			if got := twoPassArgs("in.webm", "out.mp4", settings, tt.pass, "out.mp4.passlog"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("twoPassArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package vigoler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// fitToSizeOverhead is the part of the size that is left for the streams, the rest is for the container.
+	fitToSizeOverhead = 0.97
+	// minFitVideoBitrate is the lowest video bitrate in KBit/s that is worth encoding.
+	minFitVideoBitrate = 100
+	fitVideoEncoder    = "libx264"
+	fitAudioEncoder    = "aac"
+	// maxFitAttempts is the number of times the video is encoded when the output is bigger than the max size.
+	maxFitAttempts = 3
+)
+
+// fitResolutions is the highest height that is encoded at every video bitrate when the resolution can be lowered.
+var fitResolutions = []struct {
+	minBitrate int
+	height     int
+}{{4000, 1080}, {2000, 720}, {1000, 480}, {500, 360}, {0, 240}}
+
+// fitToSizeSettings return the settings that encode the video to less than sizeInKb. height is the height of the best
+// format and when lowerResolution is true it is lowered to match the video bitrate.
+func fitToSizeSettings(url VideoUrl, sizeInKb int, height float64, lowerResolution bool) (TranscodeSettings, error) {
+	if url.Duration <= 0 {
+		return TranscodeSettings{}, &FileTooBigError{url: url}
+	}
+	totalBitrate := int(float64(sizeInKb) * fitToSizeOverhead / kbitToKb / url.Duration)
+	audioBitrate := 64
+	if totalBitrate >= 1024 {
+		audioBitrate = 128
+	} else if totalBitrate >= 512 {
+		audioBitrate = 96
+	}
+	settings := TranscodeSettings{VideoEncoder: fitVideoEncoder, AudioEncoder: fitAudioEncoder,
+		VideoBitrate: totalBitrate - audioBitrate, AudioBitrate: audioBitrate}
+	if settings.VideoBitrate < minFitVideoBitrate {
+		return TranscodeSettings{}, &FileTooBigError{url: url}
+	}
+	if lowerResolution {
+		for _, resolution := range fitResolutions {
+			if settings.VideoBitrate >= resolution.minBitrate {
+				if height <= 0 || float64(resolution.height) < height {
+					settings.MaxHeight = resolution.height
+				}
+				break
+			}
+		}
+	}
+	return settings, nil
+}
+
+// DownloadFitToSize download the best formats and encode them in two passes so the output is smaller than
+// maxSizeInKb. The quality that was lost is reported in the warnings.
+func (vu *VideoUtils) DownloadFitToSize(url VideoUrl, maxSizeInKb int, ext string) (*Async, error) {
+	videos := vu.ranking().Order(url.Formats, true, false)
+	audios := vu.ranking().Order(url.Formats, false, true)
+	tracks := [][]Format{videos, audios}
+	if len(videos) == 0 || len(audios) == 0 {
+		tracks = [][]Format{vu.ranking().Order(url.Formats, true, true)}
+	}
+	if len(tracks[0]) == 0 {
+		return nil, &FormatNotFoundError{videos: []VideoUrl{url}}
+	}
+	return vu.fitTracksToSize(url, maxSizeInKb, ext, tracks, TranscodeSettings{})
+}
+
+// fitTracksToSize download the first format of every track and encode them in two passes so the output is smaller
+// than maxSizeInKb. The encoders of limits are used when they are set and its max height, fps and bitrate are kept.
+func (vu *VideoUtils) fitTracksToSize(url VideoUrl, maxSizeInKb int, ext string, tracks [][]Format, limits TranscodeSettings) (*Async, error) {
+	best := tracks[0][0]
+	settings, err := fitToSizeSettings(url, maxSizeInKb, best.Height, vu.FitToSizeLowerResolution)
+	if err != nil {
+		return nil, err
+	}
+	if limits.VideoEncoder != "" {
+		settings.VideoEncoder = limits.VideoEncoder
+	}
+	if limits.AudioEncoder != "" {
+		settings.AudioEncoder = limits.AudioEncoder
+	}
+	if limits.MaxHeight > 0 && (settings.MaxHeight <= 0 || limits.MaxHeight < settings.MaxHeight) {
+		settings.MaxHeight = limits.MaxHeight
+	}
+	if limits.MaxVideoBitrate > 0 && settings.VideoBitrate > limits.MaxVideoBitrate {
+		settings.VideoBitrate = limits.MaxVideoBitrate
+	}
+	settings.MaxFPS = limits.MaxFPS
+	settings.MaxVideoBitrate = limits.MaxVideoBitrate
+	selection := firstFormats(tracks)
+	download, err := vu.downloadSelection(url, selection, "mkv")
+	if err != nil {
+		return nil, err
+	}
+	height := fmt.Sprintf("%vp", best.Height)
+	if settings.MaxHeight > 0 && (best.Height <= 0 || float64(settings.MaxHeight) < best.Height) {
+		height = fmt.Sprintf("%dp", settings.MaxHeight)
+	}
+	warn := fmt.Sprintf("No format fit in %d KB, re-encoding format %s (%vp, %.0f KBit/s) to %s with %d KBit/s video and %d KBit/s audio.\n",
+		maxSizeInKb, best.FormatID, best.Height, best.TBR, height, settings.VideoBitrate, settings.AudioBitrate)
+	return vu.fitTranscodeDownload(url, download, ext, maxSizeInKb, settings, warn), nil
+}
+
+// fitTranscodeDownload encode the download like transcodeDownload and check the size of the output. Output that is
+// bigger than maxSizeInKb is encoded again with lower video bitrate, FileTooBigError is returned when the bitrate
+// can not be lowered anymore.
+func (vu *VideoUtils) fitTranscodeDownload(url VideoUrl, download *Async, ext string, maxSizeInKb int, settings TranscodeSettings, warn string) *Async {
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		input, err, dWarn := download.Get()
+		wa.remove(download)
+		warn += dWarn
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		defer os.Remove(input.(string))
+		for attempt := 1; ; attempt++ {
+			output, tWarn, err := vu.transcodeFile(&wa, input.(string), ext, settings)
+			warn += tWarn
+			if err != nil || async.isStopped {
+				async.SetResult(output, err, warn)
+				return
+			}
+			info, err := os.Stat(output)
+			if err != nil {
+				async.SetResult(nil, err, warn)
+				return
+			}
+			sizeInKb := int(info.Size() / 1024)
+			if sizeInKb <= maxSizeInKb {
+				async.SetResult(output, nil, warn)
+				return
+			}
+			_ = os.Remove(output)
+			// The video bitrate is lowered by the extra size and by the overhead that was missed.
+			videoBitrate := int(float64(settings.VideoBitrate)*fitToSizeOverhead - float64(sizeInKb-maxSizeInKb)/kbitToKb/url.Duration)
+			if attempt == maxFitAttempts || videoBitrate < minFitVideoBitrate {
+				warn += fmt.Sprintf("Re-encoded output is %d KB with %d KBit/s video, it does not fit in %d KB and the video bitrate can not be lowered.\n",
+					sizeInKb, settings.VideoBitrate, maxSizeInKb)
+				async.SetResult(nil, &FileTooBigError{url: url}, warn)
+				return
+			}
+			warn += fmt.Sprintf("Re-encoded output is %d KB, more than %d KB, encoding again with %d KBit/s video.\n",
+				sizeInKb, maxSizeInKb, videoBitrate)
+			settings.VideoBitrate = videoBitrate
+		}
+	}()
+	return &async
+}
+
+// fitToSizeOnTooBig download with fit when the download fail because every format is bigger than maxSizeInKb and
+// FitToSize is enabled.
+func (vu *VideoUtils) fitToSizeOnTooBig(url VideoUrl, maxSizeInKb int, ext string, download *Async, err error, fit func() (*Async, error)) (*Async, error) {
+	if !vu.FitToSize || maxSizeInKb == -1 {
+		return download, err
+	}
+	if _, isTooBig := err.(*FileTooBigError); isTooBig {
+		return fit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result, err, warn := download.Get()
+		wa.remove(download)
+		if _, isTooBig := err.(*FileTooBigError); !isTooBig || async.isStopped {
+			async.SetResult(result, err, warn)
+			return
+		}
+		fitDownload, err := fit()
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		wa.add(fitDownload)
+		result, err, fitWarn := fitDownload.Get()
+		wa.remove(fitDownload)
+		async.SetResult(result, err, warn+fitWarn)
+	}()
+	return &async, nil
+}
@@ -0,0 +1,136 @@
+package vigoler
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_fitToSizeSettings(t *testing.T) {
+	tests := []struct {
+		name            string
+		url             VideoUrl
+		sizeInKb        int
+		height          float64
+		lowerResolution bool
+		want            TranscodeSettings
+		wantErr         bool
+	}{
+		{"high bitrate", VideoUrl{Duration: 100}, 100 * 2000 * 1000 / 8 / 1024, 1080, false,
+			TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", VideoBitrate: 1811, AudioBitrate: 128}, false},
+		{"lower resolution", VideoUrl{Duration: 100}, 100 * 2000 * 1000 / 8 / 1024, 1080, true,
+			TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", VideoBitrate: 1811, AudioBitrate: 128, MaxHeight: 480}, false},
+		{"resolution already low", VideoUrl{Duration: 100}, 100 * 2000 * 1000 / 8 / 1024, 360, true,
+			TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", VideoBitrate: 1811, AudioBitrate: 128}, false},
+		{"low bitrate", VideoUrl{Duration: 100}, 100 * 600 * 1000 / 8 / 1024, 720, true,
+			TranscodeSettings{VideoEncoder: "libx264", AudioEncoder: "aac", VideoBitrate: 485, AudioBitrate: 96, MaxHeight: 240}, false},
+		{"too small", VideoUrl{Duration: 100}, 100 * 150 * 1000 / 8 / 1024, 720, false, TranscodeSettings{}, true},
+		{"unknown duration", VideoUrl{}, 1000, 720, false, TranscodeSettings{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fitToSizeSettings(tt.url, tt.sizeInKb, tt.height, tt.lowerResolution)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fitToSizeSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fitToSizeSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+func TestVideoUtils_fitToSizeOnTooBig(t *testing.T) {
+	url := VideoUrl{Name: "file", Formats: []Format{{FormatID: "1", HasVideo: true, HasAudio: true, FileSize: 1000}}}
+	tooBig := &FileTooBigError{url: url}
+	other := errors.New("other")
+	tests := []struct {
+		name      string
+		fitToSize bool
+		err       error
+		wantErr   error
+	}{
+		{"disabled", false, tooBig, tooBig},
+		{"other error", true, other, other},
+		{"success", true, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vu := VideoUtils{FitToSize: tt.fitToSize}
+			var wg sync.WaitGroup
+			download := CreateAsyncWaitGroup(&wg, nil)
+			download.SetResult("output", tt.err, "")
+			async, err := vu.fitToSizeOnTooBig(url, 100, "", &download, nil, func() (*Async, error) {
+				return vu.DownloadFitToSize(url, 100, "")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err, _ = async.Get(); err != tt.wantErr {
+				t.Errorf("fitToSizeOnTooBig() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	// Video without duration can not be re-encoded to size so the error is still too big.
+	vu := VideoUtils{FitToSize: true}
+	if _, err := vu.fitToSizeOnTooBig(url, 100, "", nil, tooBig, func() (*Async, error) {
+		return vu.DownloadFitToSize(url, 100, "")
+	}); err == nil {
+		t.Errorf("fitToSizeOnTooBig() error = nil, want too big")
+	} else if _, isTooBig := err.(*FileTooBigError); !isTooBig {
+		t.Errorf("fitToSizeOnTooBig() error = %v, want too big", err)
+	}
+}
+func TestVideoUtils_fitTranscodeDownload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test use shell script as ffmpeg")
+	}
+	// The fake ffmpeg write 10 seconds of the video bitrate to the output.
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nprev=\"\"\nfor arg in \"$@\"; do\n  if [ \"$prev\" = \"-b:v\" ]; then bitrate=${arg%k}; fi\n  prev=$arg\ndone\n" +
+		"if [ \"$prev\" != \"" + os.DevNull + "\" ]; then head -c $((bitrate * 1250)) /dev/zero > \"$prev\"; fi\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	url := VideoUrl{Name: "video", Duration: 10}
+	tests := []struct {
+		name        string
+		maxSizeInKb int
+		wantErr     bool
+		wantRetry   bool
+	}{
+		{"fit", 3000, false, false},
+		{"retry with lower bitrate", 2000, false, true},
+		{"too big", 100, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := filepath.Join(t.TempDir(), "input.mkv")
+			if err := ioutil.WriteFile(input, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			vu := VideoUtils{Ffmpeg: &FFmpegWrapper{ffmpeg: externalApp{appLocation: path}}}
+			var wg sync.WaitGroup
+			download := CreateAsyncWaitGroup(&wg, nil)
+			download.SetResult(input, nil, "")
+			settings := TranscodeSettings{VideoEncoder: fitVideoEncoder, AudioEncoder: fitAudioEncoder, VideoBitrate: 2000, AudioBitrate: 128}
+			output, err, warn := vu.fitTranscodeDownload(url, &download, "mp4", tt.maxSizeInKb, settings, "").Get()
+			if _, isTooBig := err.(*FileTooBigError); isTooBig != tt.wantErr || (!tt.wantErr && err != nil) {
+				t.Fatalf("fitTranscodeDownload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if retried := strings.Contains(warn, "encoding again"); retried != tt.wantRetry {
+				t.Errorf("fitTranscodeDownload() warn = %s, want retry %v", warn, tt.wantRetry)
+			}
+			if err == nil {
+				defer os.Remove(output.(string))
+				if info, err := os.Stat(output.(string)); err != nil || info.Size()/1024 > int64(tt.maxSizeInKb) {
+					t.Errorf("fitTranscodeDownload() output = %v, %v", info, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package vigoler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
+)
+
+// minSeekableWindowInSec is the minimal DVR window of a sliding live playlist to download it from the start.
+// Lives without DVR keep only the few segments near the live edge.
+const minSeekableWindowInSec = 5 * 60
+
+func getPlaylist(url string, headers map[string]string) (*m3u8.MasterPlaylist, *m3u8.MediaPlaylist, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, &HttpError{Video: url, ErrorMessage: resp.Status}
+	}
+	return m3u8.Parse(resp.Body)
+}
+
+// getMediaPlaylist return the media playlist in url and its url.
+// If url is a master playlist the variant with the closest height to height is used (negative height choose the best variant).
+func getMediaPlaylist(url string, headers map[string]string, height int) (*m3u8.MediaPlaylist, string, error) {
+	master, media, err := getPlaylist(url, headers)
+	if err != nil {
+		return nil, "", err
+	}
+	if master != nil {
+		variant := master.ClosestVariant(height)
+		if variant == nil {
+			return nil, "", errors.New("master playlist without variants")
+		}
+		url, err = m3u8.ResolveURI(url, variant.URI)
+		if err != nil {
+			return nil, "", err
+		}
+		_, media, err = getPlaylist(url, headers)
+		if err != nil {
+			return nil, "", err
+		}
+		if media == nil {
+			return nil, "", errors.New("variant of master playlist is not a media playlist")
+		}
+	}
+	return media, url, nil
+}
+func isLiveSeekable(playlist *m3u8.MediaPlaylist) bool {
+	return playlist.ContainsStart() || playlist.Duration() >= minSeekableWindowInSec
+}
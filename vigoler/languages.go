@@ -0,0 +1,51 @@
+package vigoler
+
+import "strings"
+
+// iso6391To6392 map the two letters language codes youtube-dl returns to the three letters codes containers expect.
+var iso6391To6392 = map[string]string{
+	"ar": "ara", "bg": "bul", "bn": "ben", "cs": "cze", "da": "dan", "de": "ger", "el": "gre", "en": "eng",
+	"es": "spa", "et": "est", "fa": "per", "fi": "fin", "fr": "fre", "he": "heb", "hi": "hin", "hr": "hrv",
+	"hu": "hun", "id": "ind", "it": "ita", "ja": "jpn", "ko": "kor", "lt": "lit", "lv": "lav", "ms": "may",
+	"nl": "dut", "no": "nor", "pl": "pol", "pt": "por", "ro": "rum", "ru": "rus", "sk": "slo", "sl": "slv",
+	"sr": "srp", "sv": "swe", "ta": "tam", "th": "tha", "tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie",
+	"zh": "chi",
+}
+
+func baseLanguage(language string) string {
+	if i := strings.IndexAny(language, "-_"); i != -1 {
+		language = language[:i]
+	}
+	return strings.ToLower(language)
+}
+
+// isLanguageMatch check if language is the wanted language or a regional variant of it (en-US match en).
+func isLanguageMatch(language, wanted string) bool {
+	if language == "" || wanted == "" {
+		return false
+	}
+	if strings.EqualFold(language, wanted) {
+		return true
+	}
+	return !strings.ContainsAny(wanted, "-_") && baseLanguage(language) == strings.ToLower(wanted)
+}
+func languageIndex(language string, languages []string) int {
+	for i, wanted := range languages {
+		if isLanguageMatch(language, wanted) {
+			return i
+		}
+	}
+	return len(languages)
+}
+
+// toContainerLanguage convert language to the ISO 639-2 code that mkv and mp4 use in the stream metadata.
+func toContainerLanguage(language string) string {
+	base := baseLanguage(language)
+	if len(base) == 3 {
+		return base
+	}
+	if code, ok := iso6391To6392[base]; ok {
+		return code
+	}
+	return "und"
+}
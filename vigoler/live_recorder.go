@@ -0,0 +1,413 @@
+package vigoler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
+)
+
+// maxLivePlaylistErrors is the number of times in a row the live playlist can fail (after recreating its url) before the recording stop.
+const maxLivePlaylistErrors = 3
+
+// liveEdgeSegments is the number of segments from the end of the first playlist that the recording start with, so the
+// DVR window of the live is not downloaded.
+const liveEdgeSegments = 3
+
+// EncryptedLiveError is returned when the segments of hls live are encrypted and can not be recorded segment by segment.
+var EncryptedLiveError = errors.New("live segments are encrypted")
+
+type LivePart struct {
+	FileName      string
+	FirstSequence int64
+	LastSequence  int64
+	// Start and End are the wall clock time of the part. They come from the program date time of the segments if the
+	// live has it and from the download time otherwise.
+	Start    time.Time
+	End      time.Time
+	SizeInKb int
+}
+
+// LiveGap is a range of segments that was missing between two segments that were downloaded.
+type LiveGap struct {
+	FromSequence int64     `json:"from_sequence"`
+	ToSequence   int64     `json:"to_sequence"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+}
+type LiveReport struct {
+	Parts []LivePart
+	Gaps  []LiveGap
+}
+
+func (g LiveGap) String() string {
+	return fmt.Sprintf("segments %d-%d are missing (%s - %s)", g.FromSequence, g.ToSequence, g.From.Format(time.RFC3339), g.To.Format(time.RFC3339))
+}
+func isHlsProtocol(protocol string) bool {
+	return strings.HasPrefix(protocol, "m3u8")
+}
+
+// isEncryptedHls return if the current segments of the hls format are encrypted. Errors are ignored so the recorder
+// will handle them.
+func isEncryptedHls(format Format) bool {
+	playlist, _, err := getMediaPlaylist(format.URL, format.HTTPHeaders, int(format.Height))
+	return err == nil && playlist.Encrypted()
+}
+
+type hlsRecorder struct {
+	vu           *VideoUtils
+	log          *Logger
+	url          VideoUrl
+	format       Format
+	ext          string
+	maxSizeInKb  int
+	maxTimeInSec int
+	callback     LiveVideoCallback
+	data         interface{}
+	pollInterval time.Duration
+	stopChan     chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
+	wg           sync.WaitGroup
+	reportMutex  sync.Mutex
+	report       LiveReport
+	warn         string
+	mapData      map[string][]byte
+	// lastPartDone is closed after the callback of the last finished part was called so parts are reported in order.
+	lastPartDone chan struct{}
+	part         *os.File
+	partInfo     LivePart
+	partDuration float64
+	partSize     int
+	nextSequence int64
+	lastEnd      time.Time
+	// segmentErrorTime is the time of the first segment error since the last segment that was added.
+	segmentErrorTime time.Time
+}
+
+func (r *hlsRecorder) Wait() error {
+	<-r.done
+	return nil
+}
+func (r *hlsRecorder) Stop() error {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+	return nil
+}
+func (r *hlsRecorder) isStopped() bool {
+	select {
+	case <-r.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+func (r *hlsRecorder) sleep(d time.Duration) {
+	select {
+	case <-r.stopChan:
+	case <-time.After(d):
+	}
+}
+func (r *hlsRecorder) get(url string, byteRange *m3u8.ByteRange) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.format.HTTPHeaders {
+		req.Header.Set(k, v)
+	}
+	if byteRange != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", byteRange.Offset, byteRange.Offset+byteRange.Length-1))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &HttpError{Video: url, ErrorMessage: resp.Status}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+func (r *hlsRecorder) partFileName() string {
+	if r.needRemux() {
+		return r.vu.createFileName("ts", r.format)
+	}
+	return r.vu.createFileName(r.ext, r.format)
+}
+func (r *hlsRecorder) needRemux() bool {
+	ext := r.ext
+	if ext == "" {
+		ext = r.format.Ext
+	}
+	return ext != "ts"
+}
+func (r *hlsRecorder) addWarn(warn string) {
+	r.reportMutex.Lock()
+	r.warn += warn
+	r.reportMutex.Unlock()
+}
+func (r *hlsRecorder) startPart(segment m3u8.Segment, segmentURL string) error {
+	var mapData []byte
+	if segment.MapURI != "" {
+		var err error
+		if mapData, err = r.getMap(segment.MapURI, segmentURL); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(r.partFileName())
+	if err != nil {
+		return err
+	}
+	r.part = file
+	r.partInfo = LivePart{FileName: file.Name(), FirstSequence: segment.SequenceNumber}
+	r.partDuration, r.partSize = 0, 0
+	r.log.startDownloadLive(r.url, file.Name())
+	if mapData != nil {
+		return r.write(mapData)
+	}
+	return nil
+}
+func (r *hlsRecorder) getMap(uri, segmentURL string) ([]byte, error) {
+	if data, ok := r.mapData[uri]; ok {
+		return data, nil
+	}
+	mapURL, err := m3u8.ResolveURI(segmentURL, uri)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.get(mapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.mapData[uri] = data
+	return data, nil
+}
+func (r *hlsRecorder) write(data []byte) error {
+	_, err := r.part.Write(data)
+	r.partSize += len(data)
+	return err
+}
+
+// finishPart close the current part and remux it to the wanted container in the background.
+func (r *hlsRecorder) finishPart() {
+	if r.part == nil {
+		return
+	}
+	part, info := r.part, r.partInfo
+	r.part = nil
+	info.SizeInKb = r.partSize / 1024
+	partErr := part.Close()
+	var wg sync.WaitGroup
+	async := CreateAsyncWaitGroup(&wg, nil)
+	wg.Add(1)
+	r.wg.Add(1)
+	previousPartDone, partDone := r.lastPartDone, make(chan struct{})
+	r.lastPartDone = partDone
+	go func() {
+		defer r.wg.Done()
+		defer close(partDone)
+		err, warn := partErr, ""
+		if err == nil && r.needRemux() {
+			output := r.vu.createFileName(r.ext, r.format)
+			var remux *Async
+			remux, err = r.vu.Ffmpeg.Remux(info.FileName, output)
+			if err == nil {
+				_, err, warn = remux.Get()
+			}
+			if err == nil {
+				_ = os.Remove(info.FileName)
+				info.FileName = output
+			} else {
+				_ = os.Remove(output)
+			}
+		}
+		if err != nil {
+			r.log.finishDownloadLiveError(r.url, info.FileName, warn, err)
+		} else {
+			r.log.finishDownloadLive(r.url, info.FileName, warn, err)
+		}
+		if previousPartDone != nil {
+			<-previousPartDone
+		}
+		r.reportMutex.Lock()
+		r.report.Parts = append(r.report.Parts, info)
+		r.reportMutex.Unlock()
+		async.SetResult(info, err, warn)
+		wg.Done()
+		if r.callback != nil {
+			r.callback(r.data, info.FileName, &async)
+		}
+	}()
+}
+func (r *hlsRecorder) needSplit(segment m3u8.Segment, size int) bool {
+	if r.part == nil {
+		return false
+	}
+	const kbToByte = 1024
+	return (r.maxSizeInKb > 0 && r.partSize+size > r.maxSizeInKb*kbToByte) ||
+		(r.maxTimeInSec > 0 && r.partDuration+segment.Duration > float64(r.maxTimeInSec))
+}
+func (r *hlsRecorder) addSegment(segment m3u8.Segment, playlistURL string) error {
+	segmentURL, err := m3u8.ResolveURI(playlistURL, segment.URI)
+	if err != nil {
+		return err
+	}
+	data, err := r.get(segmentURL, segment.ByteRange)
+	if err != nil {
+		return err
+	}
+	start := segment.ProgramDateTime
+	if start.IsZero() {
+		start = time.Now().Add(-time.Duration(segment.Duration * float64(time.Second)))
+	}
+	if r.nextSequence != -1 && segment.SequenceNumber > r.nextSequence {
+		gap := LiveGap{FromSequence: r.nextSequence, ToSequence: segment.SequenceNumber - 1, From: r.lastEnd, To: start}
+		r.log.liveGap(r.url, gap)
+		r.reportMutex.Lock()
+		r.report.Gaps = append(r.report.Gaps, gap)
+		r.reportMutex.Unlock()
+	}
+	if r.needSplit(segment, len(data)) {
+		r.finishPart()
+	}
+	if r.part == nil {
+		if err = r.startPart(segment, segmentURL); err != nil {
+			return err
+		}
+		r.partInfo.Start = start
+	}
+	if err = r.write(data); err != nil {
+		return err
+	}
+	r.nextSequence = segment.SequenceNumber + 1
+	r.lastEnd = start.Add(time.Duration(segment.Duration * float64(time.Second)))
+	r.partInfo.LastSequence = segment.SequenceNumber
+	r.partInfo.End = r.lastEnd
+	r.partDuration += segment.Duration
+	return nil
+}
+
+// refresh recreate the url of the live after the playlist stop working.
+func (r *hlsRecorder) refresh() error {
+	format, err := r.vu.recreateURL(r.url, r.format)
+	if err != nil {
+		return err
+	}
+	r.log.liveRecreated(r.url, r.partInfo.FileName)
+	r.format = format
+	return nil
+}
+func (r *hlsRecorder) record() error {
+	errorsCount := 0
+	for !r.isStopped() {
+		playlist, playlistURL, err := getMediaPlaylist(r.format.URL, r.format.HTTPHeaders, int(r.format.Height))
+		if err != nil {
+			errorsCount++
+			if errorsCount >= maxLivePlaylistErrors {
+				return err
+			}
+			r.addWarn(err.Error() + "\n")
+			if err = r.refresh(); err != nil {
+				if _, isNotFound := err.(*FormatNotFoundError); isNotFound {
+					// The live ended and its formats are not available anymore.
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		errorsCount = 0
+		if r.nextSequence == -1 && playlist.IsLive() {
+			r.nextSequence = playlist.LastSequenceNumber() - liveEdgeSegments + 1
+			if r.nextSequence < playlist.MediaSequence {
+				r.nextSequence = playlist.MediaSequence
+			}
+		} else if r.nextSequence != -1 && playlist.LastSequenceNumber() < r.nextSequence-1 {
+			// The sequence numbers restarted, the live was probably restarted by the server.
+			r.nextSequence = playlist.MediaSequence
+		}
+		segmentFailed := false
+		for _, segment := range playlist.Segments {
+			if r.isStopped() {
+				return nil
+			}
+			if segment.SequenceNumber < r.nextSequence {
+				continue
+			}
+			if segment.KeyMethod != "" {
+				return EncryptedLiveError
+			}
+			if err = r.addSegment(segment, playlistURL); err != nil {
+				if _, isFileError := err.(*os.PathError); isFileError {
+					return err
+				}
+				now := time.Now()
+				if r.segmentErrorTime.IsZero() {
+					r.segmentErrorTime = now
+				} else if int(now.Sub(r.segmentErrorTime).Seconds()) > r.vu.MinLiveErrorRetryingTime {
+					return err
+				}
+				// The segment will be downloaded in the next poll or reported as a gap if it will not be available anymore.
+				r.addWarn(err.Error() + "\n")
+				segmentFailed = true
+				break
+			}
+			r.segmentErrorTime = time.Time{}
+		}
+		if !playlist.IsLive() && !segmentFailed {
+			return nil
+		}
+		pollInterval := r.pollInterval
+		if pollInterval == 0 {
+			pollInterval = time.Duration(playlist.TargetDuration * float64(time.Second) / 2)
+		}
+		r.sleep(pollInterval)
+	}
+	return nil
+}
+
+func (vu *VideoUtils) createHlsRecorder(log *Logger, url VideoUrl, format Format, ext string, maxSizeInKb, maxTimeInSec int, liveVideoCallback LiveVideoCallback, data interface{}) *hlsRecorder {
+	return &hlsRecorder{vu: vu, log: log, url: url, format: format, ext: ext, maxSizeInKb: maxSizeInKb, maxTimeInSec: maxTimeInSec,
+		callback: liveVideoCallback, data: data, stopChan: make(chan struct{}), done: make(chan struct{}), mapData: make(map[string][]byte), nextSequence: -1}
+}
+func (r *hlsRecorder) start() *Async {
+	var wg sync.WaitGroup
+	async := CreateAsyncWaitGroup(&wg, r)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(r.done)
+		err := r.record()
+		r.finishPart()
+		r.wg.Wait()
+		r.reportMutex.Lock()
+		report, warn := r.report, r.warn
+		r.reportMutex.Unlock()
+		if len(report.Gaps) != 0 {
+			warn += "Live recording has gaps:\n"
+			for _, gap := range report.Gaps {
+				warn += gap.String() + "\n"
+			}
+		}
+		async.SetResult(&report, err, warn)
+	}()
+	return &async
+}
+
+// liveDownloadHls record hls live segment by segment so every part start exactly after the last segment of the previous part.
+// Parts are split when adding the next segment will pass maxSizeInKb or maxTimeInSec.
+func (vu *VideoUtils) liveDownloadHls(log *Logger, url VideoUrl, format Format, ext string, maxSizeInKb, maxTimeInSec int, liveVideoCallback LiveVideoCallback, data interface{}) (*Async, error) {
+	if len(format.URL) == 0 {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "format", argValue: format}
+	}
+	return vu.createHlsRecorder(log, url, format, ext, maxSizeInKb, maxTimeInSec, liveVideoCallback, data).start(), nil
+}
@@ -0,0 +1,198 @@
+package vigoler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func liveTestPlaylist(startTime time.Time, endList bool, sequences ...int) string {
+	playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequences[0])
+	for i, sequence := range sequences {
+		if i != 0 && sequences[i-1]+1 != sequence {
+			break
+		}
+		pdt := startTime.Add(time.Duration(sequence*2) * time.Second).Format(time.RFC3339)
+		playlist += fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:2.0,\nseg%d.ts\n", pdt, sequence)
+	}
+	if endList {
+		playlist += "#EXT-X-ENDLIST\n"
+	}
+	return playlist
+}
+func TestVideoUtils_liveDownloadHls(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	playlists := []string{
+		liveTestPlaylist(startTime, false, 10, 11),
+		liveTestPlaylist(startTime, false, 11, 12, 13),
+		liveTestPlaylist(startTime, true, 16, 17),
+	}
+	var requestMutex sync.Mutex
+	playlistIndex := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			requestMutex.Lock()
+			playlist := playlists[playlistIndex]
+			if playlistIndex < len(playlists)-1 {
+				playlistIndex++
+			}
+			requestMutex.Unlock()
+			_, _ = w.Write([]byte(playlist))
+		} else {
+			_, _ = w.Write([]byte(strings.Repeat(r.URL.Path[len(r.URL.Path)-4:len(r.URL.Path)-3], 1024)))
+		}
+	}))
+	defer server.Close()
+	vu := &VideoUtils{}
+	var callbackFiles []string
+	var callbackMutex sync.Mutex
+	callback := func(data interface{}, fileName string, async *Async) {
+		callbackMutex.Lock()
+		callbackFiles = append(callbackFiles, fileName)
+		callbackMutex.Unlock()
+	}
+	format := Format{URL: server.URL + "/live/index.m3u8", Protocol: "m3u8_native", Ext: "mp4"}
+	recorder := vu.createHlsRecorder(&Logger{Logger: zap.NewNop()}, VideoUrl{}, format, "ts", 2, -1, callback, nil)
+	recorder.pollInterval = 10 * time.Millisecond
+	async := recorder.start()
+	timer := time.AfterFunc(10*time.Second, func() {
+		_ = async.Stop()
+	})
+	result, err, _ := async.Get()
+	timer.Stop()
+	if err != nil {
+		t.Fatalf("liveDownloadHls() error = %v", err)
+	}
+	report := result.(*LiveReport)
+	defer func() {
+		for _, part := range report.Parts {
+			_ = os.Remove(part.FileName)
+		}
+	}()
+	wantParts := [][2]int64{{10, 11}, {12, 13}, {16, 17}}
+	if len(report.Parts) != len(wantParts) {
+		t.Fatalf("liveDownloadHls() parts = %+v", report.Parts)
+	}
+	for i, part := range report.Parts {
+		if part.FirstSequence != wantParts[i][0] || part.LastSequence != wantParts[i][1] || part.SizeInKb != 2 {
+			t.Errorf("liveDownloadHls() part %d = %+v, want sequences %v", i, part, wantParts[i])
+		}
+		info, err := os.Stat(part.FileName)
+		if err != nil || info.Size() != 2048 {
+			t.Errorf("liveDownloadHls() part %d file = %v, %v", i, info, err)
+		}
+	}
+	if !report.Parts[1].Start.Equal(report.Parts[0].End) {
+		t.Errorf("liveDownloadHls() parts are not continuous %v, %v", report.Parts[0].End, report.Parts[1].Start)
+	}
+	wantGap := LiveGap{FromSequence: 14, ToSequence: 15, From: startTime.Add(28 * time.Second), To: startTime.Add(32 * time.Second)}
+	if len(report.Gaps) != 1 || report.Gaps[0] != wantGap {
+		t.Errorf("liveDownloadHls() gaps = %+v, want %+v", report.Gaps, wantGap)
+	}
+	if len(callbackFiles) != len(wantParts) {
+		t.Errorf("liveDownloadHls() callback called %v times", len(callbackFiles))
+	}
+}
+func TestVideoUtils_liveDownloadHlsLiveEdge(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	playlists := []string{
+		liveTestPlaylist(startTime, false, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9),
+		liveTestPlaylist(startTime, true, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11),
+	}
+	var requestMutex sync.Mutex
+	playlistIndex := 0
+	var segments []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestMutex.Lock()
+		defer requestMutex.Unlock()
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			_, _ = w.Write([]byte(playlists[playlistIndex]))
+			if playlistIndex < len(playlists)-1 {
+				playlistIndex++
+			}
+		} else {
+			segments = append(segments, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			_, _ = w.Write([]byte(strings.Repeat("0", 1024)))
+		}
+	}))
+	defer server.Close()
+	vu := &VideoUtils{}
+	format := Format{URL: server.URL + "/live/index.m3u8", Protocol: "m3u8_native", Ext: "mp4"}
+	recorder := vu.createHlsRecorder(&Logger{Logger: zap.NewNop()}, VideoUrl{}, format, "ts", -1, -1, nil, nil)
+	recorder.pollInterval = 10 * time.Millisecond
+	result, err, _ := recorder.start().Get()
+	if err != nil {
+		t.Fatalf("liveDownloadHls() error = %v", err)
+	}
+	report := result.(*LiveReport)
+	for _, part := range report.Parts {
+		_ = os.Remove(part.FileName)
+	}
+	wantSegments := []string{"seg7.ts", "seg8.ts", "seg9.ts", "seg10.ts", "seg11.ts"}
+	if !reflect.DeepEqual(segments, wantSegments) {
+		t.Errorf("liveDownloadHls() downloaded %v, want %v", segments, wantSegments)
+	}
+	if len(report.Parts) != 1 || report.Parts[0].FirstSequence != 7 || report.Parts[0].LastSequence != 11 || len(report.Gaps) != 0 {
+		t.Errorf("liveDownloadHls() report = %+v", report)
+	}
+}
+func TestVideoUtils_liveDownloadHlsSegmentErrors(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	encrypted := strings.Replace(liveTestPlaylist(startTime, true, 0, 1), "#EXTINF", "#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF", 1)
+	tests := []struct {
+		name     string
+		playlist string
+		wantErr  error
+		wantLast int64
+	}{
+		{"retry", liveTestPlaylist(startTime, true, 0, 1, 2), nil, 2},
+		{"encrypted", encrypted, EncryptedLiveError, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requestMutex sync.Mutex
+			failed := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, ".m3u8") {
+					_, _ = w.Write([]byte(tt.playlist))
+					return
+				}
+				requestMutex.Lock()
+				fail := !failed && strings.HasSuffix(r.URL.Path, "seg1.ts")
+				failed = failed || fail
+				requestMutex.Unlock()
+				if fail {
+					// Send only part of the body so reading the segment fail.
+					w.Header().Set("Content-Length", "1024")
+					_, _ = w.Write([]byte("0"))
+					return
+				}
+				_, _ = w.Write([]byte(strings.Repeat("0", 1024)))
+			}))
+			defer server.Close()
+			vu := &VideoUtils{MinLiveErrorRetryingTime: 10}
+			format := Format{URL: server.URL + "/live/index.m3u8", Protocol: "m3u8_native", Ext: "mp4"}
+			recorder := vu.createHlsRecorder(&Logger{Logger: zap.NewNop()}, VideoUrl{}, format, "ts", -1, -1, nil, nil)
+			recorder.pollInterval = 10 * time.Millisecond
+			result, err, warn := recorder.start().Get()
+			report := result.(*LiveReport)
+			for _, part := range report.Parts {
+				_ = os.Remove(part.FileName)
+			}
+			if err != tt.wantErr {
+				t.Fatalf("liveDownloadHls() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (len(report.Parts) != 1 || report.Parts[0].LastSequence != tt.wantLast || len(report.Gaps) != 0 || warn == "") {
+				t.Errorf("liveDownloadHls() report = %+v, warn = %q", report, warn)
+			}
+		})
+	}
+}
@@ -33,6 +33,12 @@ func (l *Logger) finishDownloadLiveError(url VideoUrl, output string, warn strin
 func (l *Logger) liveDownloadError(url VideoUrl, output string, err error) {
 	l.Logger.Error("Start downloading live", zap.Any("video_url", url), zap.String("output", output), zap.Error(err))
 }
+func (l *Logger) liveGap(url VideoUrl, gap LiveGap) {
+	l.Logger.Warn("Gap in live recording", zap.Any("video_url", url), zap.Int64("from_sequence", gap.FromSequence), zap.Int64("to_sequence", gap.ToSequence), zap.Time("from", gap.From), zap.Time("to", gap.To))
+}
+func (l *Logger) liveEncrypted(url VideoUrl) {
+	l.Logger.Warn("Live segments are encrypted, downloading with ffmpeg", zap.Any("video_url", url))
+}
 func (l *Logger) logLogError(url VideoUrl, msg string, logError LogError) {
 	errorLog := logError.LogAttributes()
 	logAttributes := make([]zap.Field, 0, len(errorLog)+2)
@@ -64,7 +70,7 @@ func (v VideoUrl) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return enc.AddArray("formats", formatArray(v.Formats))
 }
 func (f Format) MarshalLogObject(enc zapcore.ObjectEncoder) error {
-	enc.AddString("id", f.formatID)
+	enc.AddString("id", f.FormatID)
 	return nil
 }
 
@@ -0,0 +1,369 @@
+// Package m3u8 parse HLS master and media playlists.
+package m3u8
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PlaylistType string
+
+const (
+	PlaylistTypeNone  PlaylistType = ""
+	PlaylistTypeEvent PlaylistType = "EVENT"
+	PlaylistTypeVOD   PlaylistType = "VOD"
+)
+
+var NotPlaylistError = errors.New("m3u8: missing #EXTM3U header")
+
+type ParseError struct {
+	Line    int
+	Message string
+}
+type Variant struct {
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	Width            int
+	Height           int
+	Codecs           string
+	FrameRate        float64
+}
+type MasterPlaylist struct {
+	Variants []Variant
+}
+type ByteRange struct {
+	Length int64
+	// Offset is -1 when the segment start right after the previous segment of the same resource.
+	Offset int64
+}
+type Segment struct {
+	URI             string
+	Duration        float64
+	Title           string
+	SequenceNumber  int64
+	ProgramDateTime time.Time
+	Discontinuity   bool
+	ByteRange       *ByteRange
+	// MapURI is the initialization section (EXT-X-MAP) needed to decode the segment, empty if there is none.
+	MapURI string
+	// KeyMethod is the encryption method of the segment (EXT-X-KEY), empty if the segment is not encrypted.
+	KeyMethod string
+}
+type MediaPlaylist struct {
+	Version        int
+	TargetDuration float64
+	MediaSequence  int64
+	PlaylistType   PlaylistType
+	EndList        bool
+	Segments       []Segment
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("m3u8: line %d: %s", e.Line, e.Message)
+}
+
+// Parse read a playlist from r. Exactly one of the returned playlists is not nil when err is nil.
+func Parse(r io.Reader) (*MasterPlaylist, *MediaPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	nextLine := func() (string, bool) {
+		for scanner.Scan() {
+			lineNumber++
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				return line, true
+			}
+		}
+		return "", false
+	}
+	if line, ok := nextLine(); !ok || line != "#EXTM3U" {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, NotPlaylistError
+	}
+	master := &MasterPlaylist{}
+	media := &MediaPlaylist{}
+	isMaster := false
+	var segment Segment
+	var variant *Variant
+	var lastByteRangeEnd int64
+	mapURI := ""
+	keyMethod := ""
+	var nextProgramDateTime time.Time
+	parseError := func(format string, a ...interface{}) error {
+		return &ParseError{Line: lineNumber, Message: fmt.Sprintf(format, a...)}
+	}
+	for line, ok := nextLine(); ok; line, ok = nextLine() {
+		if !strings.HasPrefix(line, "#") {
+			if variant != nil {
+				variant.URI = line
+				master.Variants = append(master.Variants, *variant)
+				variant = nil
+				continue
+			}
+			segment.URI = line
+			segment.MapURI = mapURI
+			segment.KeyMethod = keyMethod
+			segment.SequenceNumber = media.MediaSequence + int64(len(media.Segments))
+			if segment.ProgramDateTime.IsZero() && !nextProgramDateTime.IsZero() && !segment.Discontinuity {
+				segment.ProgramDateTime = nextProgramDateTime
+			}
+			if !segment.ProgramDateTime.IsZero() {
+				nextProgramDateTime = segment.ProgramDateTime.Add(time.Duration(segment.Duration * float64(time.Second)))
+			}
+			if segment.ByteRange != nil {
+				if segment.ByteRange.Offset == -1 {
+					segment.ByteRange.Offset = lastByteRangeEnd
+				}
+				lastByteRangeEnd = segment.ByteRange.Offset + segment.ByteRange.Length
+			}
+			media.Segments = append(media.Segments, segment)
+			segment = Segment{}
+			continue
+		}
+		tag, value := line, ""
+		if i := strings.Index(line, ":"); i != -1 {
+			tag, value = line[:i], line[i+1:]
+		}
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			isMaster = true
+			attributes := parseAttributes(value)
+			variant = &Variant{Codecs: attributes["CODECS"]}
+			variant.Bandwidth, _ = strconv.Atoi(attributes["BANDWIDTH"])
+			variant.AverageBandwidth, _ = strconv.Atoi(attributes["AVERAGE-BANDWIDTH"])
+			variant.FrameRate, _ = strconv.ParseFloat(attributes["FRAME-RATE"], 64)
+			if resolution := strings.SplitN(attributes["RESOLUTION"], "x", 2); len(resolution) == 2 {
+				variant.Width, _ = strconv.Atoi(resolution[0])
+				variant.Height, _ = strconv.Atoi(resolution[1])
+			}
+		case "#EXT-X-VERSION":
+			media.Version, _ = strconv.Atoi(value)
+		case "#EXT-X-TARGETDURATION":
+			targetDuration, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, nil, parseError("invalid target duration %q", value)
+			}
+			media.TargetDuration = targetDuration
+		case "#EXT-X-MEDIA-SEQUENCE":
+			mediaSequence, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, nil, parseError("invalid media sequence %q", value)
+			}
+			media.MediaSequence = mediaSequence
+		case "#EXT-X-PLAYLIST-TYPE":
+			media.PlaylistType = PlaylistType(value)
+		case "#EXT-X-ENDLIST":
+			media.EndList = true
+		case "#EXT-X-DISCONTINUITY":
+			segment.Discontinuity = true
+		case "#EXT-X-MAP":
+			mapURI = parseAttributes(value)["URI"]
+		case "#EXT-X-KEY":
+			keyMethod = parseAttributes(value)["METHOD"]
+			if keyMethod == "NONE" {
+				keyMethod = ""
+			}
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			programDateTime, err := parseTime(value)
+			if err != nil {
+				return nil, nil, parseError("invalid program date time %q", value)
+			}
+			segment.ProgramDateTime = programDateTime
+		case "#EXT-X-BYTERANGE":
+			byteRange, err := parseByteRange(value)
+			if err != nil {
+				return nil, nil, parseError("invalid byte range %q", value)
+			}
+			segment.ByteRange = byteRange
+		case "#EXTINF":
+			durationString, title := value, ""
+			if i := strings.Index(value, ","); i != -1 {
+				durationString, title = value[:i], value[i+1:]
+			}
+			duration, err := strconv.ParseFloat(durationString, 64)
+			if err != nil {
+				return nil, nil, parseError("invalid segment duration %q", durationString)
+			}
+			segment.Duration, segment.Title = duration, title
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if isMaster {
+		return master, nil, nil
+	}
+	return nil, media, nil
+}
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		// Some servers write the zone without colon (+0000).
+		t, err = time.Parse("2006-01-02T15:04:05.999999999Z0700", value)
+	}
+	return t, err
+}
+func parseByteRange(value string) (*ByteRange, error) {
+	lengthString, offsetString := value, ""
+	if i := strings.Index(value, "@"); i != -1 {
+		lengthString, offsetString = value[:i], value[i+1:]
+	}
+	length, err := strconv.ParseInt(lengthString, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(-1)
+	if offsetString != "" {
+		offset, err = strconv.ParseInt(offsetString, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ByteRange{Length: length, Offset: offset}, nil
+}
+func parseAttributes(value string) map[string]string {
+	attributes := make(map[string]string)
+	for value != "" {
+		eq := strings.Index(value, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(value[:eq])
+		value = value[eq+1:]
+		var attribute string
+		if strings.HasPrefix(value, `"`) {
+			if end := strings.Index(value[1:], `"`); end == -1 {
+				attribute, value = value[1:], ""
+			} else {
+				attribute, value = value[1:end+1], value[end+2:]
+			}
+		} else {
+			end := strings.Index(value, ",")
+			if end == -1 {
+				end = len(value)
+			}
+			attribute, value = value[:end], value[end:]
+		}
+		attributes[key] = attribute
+		value = strings.TrimPrefix(value, ",")
+	}
+	return attributes
+}
+
+// BestVariant return the variant with the highest bandwidth or nil if there are no variants.
+func (p *MasterPlaylist) BestVariant() *Variant {
+	return p.ClosestVariant(-1)
+}
+
+// ClosestVariant return the variant with the highest bandwidth between the variants that their height is the closest to height.
+// Negative height ignore the resolution of the variants.
+func (p *MasterPlaylist) ClosestVariant(height int) *Variant {
+	var best *Variant
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	for i := range p.Variants {
+		v := &p.Variants[i]
+		if best == nil {
+			best = v
+			continue
+		}
+		if height >= 0 {
+			vDiff, bestDiff := abs(v.Height-height), abs(best.Height-height)
+			if vDiff != bestDiff {
+				if vDiff < bestDiff {
+					best = v
+				}
+				continue
+			}
+		}
+		if v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+	return best
+}
+
+// Duration return the sum of all the segments duration in seconds.
+func (p *MediaPlaylist) Duration() float64 {
+	duration := 0.0
+	for _, s := range p.Segments {
+		duration += s.Duration
+	}
+	return duration
+}
+
+// IsLive return if the server may still add segments to the playlist.
+func (p *MediaPlaylist) IsLive() bool {
+	return !p.EndList && p.PlaylistType != PlaylistTypeVOD
+}
+
+// ContainsStart return if the first segment of the stream is still in the playlist.
+// Event and vod playlists never remove segments so they always contain the start.
+func (p *MediaPlaylist) ContainsStart() bool {
+	return p.PlaylistType == PlaylistTypeEvent || p.PlaylistType == PlaylistTypeVOD || p.MediaSequence == 0
+}
+
+// TrailingSegments return the shortest suffix of the segments that its duration is at least duration seconds.
+// All the segments are returned if the playlist is shorter than duration.
+func (p *MediaPlaylist) TrailingSegments(duration float64) []Segment {
+	i := len(p.Segments)
+	for total := 0.0; i > 0 && total < duration; {
+		i--
+		total += p.Segments[i].Duration
+	}
+	return p.Segments[i:]
+}
+
+// Encrypted return if any of the segments is encrypted.
+func (p *MediaPlaylist) Encrypted() bool {
+	for _, s := range p.Segments {
+		if s.KeyMethod != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// LastSequenceNumber return the sequence number of the last segment or MediaSequence-1 if the playlist is empty.
+func (p *MediaPlaylist) LastSequenceNumber() int64 {
+	return p.MediaSequence + int64(len(p.Segments)) - 1
+}
+
+// Window return the wall clock time range the segments cover.
+// ok is false if the playlist does not have program date time.
+func (p *MediaPlaylist) Window() (start, end time.Time, ok bool) {
+	if len(p.Segments) == 0 {
+		return
+	}
+	first, last := p.Segments[0], p.Segments[len(p.Segments)-1]
+	if first.ProgramDateTime.IsZero() || last.ProgramDateTime.IsZero() {
+		return
+	}
+	return first.ProgramDateTime, last.ProgramDateTime.Add(time.Duration(last.Duration * float64(time.Second))), true
+}
+
+// ResolveURI resolve uri that appear in the playlist that was downloaded from base.
+func ResolveURI(base, uri string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(ref).String(), nil
+}
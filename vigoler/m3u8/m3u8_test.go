@@ -0,0 +1,174 @@
+package m3u8
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const masterPlaylist = `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AVERAGE-BANDWIDTH=1000000,RESOLUTION=854x480,CODECS="avc1.4d401f,mp4a.40.2",FRAME-RATE=30
+480/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2"
+720/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2"
+720p60/index.m3u8
+`
+const livePlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:1200
+
+#EXT-X-PROGRAM-DATE-TIME:2020-05-01T10:00:00.000+00:00
+#EXTINF:5.0,
+seg1200.ts
+#EXTINF:5.0,
+seg1201.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2020-05-01T10:01:00.000+0000
+#EXTINF:4.5,title
+seg1202.ts
+`
+const vodPlaylist = `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:10,
+#EXT-X-BYTERANGE:1000@0
+video.mp4
+#EXTINF:10,
+#EXT-X-BYTERANGE:500
+video.mp4
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:10,
+#EXT-X-BYTERANGE:500
+video.mp4
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:10,
+#EXT-X-BYTERANGE:500
+video.mp4
+#EXT-X-ENDLIST
+`
+
+func TestParseMaster(t *testing.T) {
+	master, media, err := Parse(strings.NewReader(masterPlaylist))
+	if err != nil || media != nil || master == nil {
+		t.Fatalf("Parse() = %v, %v, %v", master, media, err)
+	}
+	want := []Variant{
+		{URI: "480/index.m3u8", Bandwidth: 1280000, AverageBandwidth: 1000000, Width: 854, Height: 480, Codecs: "avc1.4d401f,mp4a.40.2", FrameRate: 30},
+		{URI: "720/index.m3u8", Bandwidth: 2560000, Width: 1280, Height: 720, Codecs: "avc1.4d401f,mp4a.40.2"},
+		{URI: "720p60/index.m3u8", Bandwidth: 3000000, Width: 1280, Height: 720, Codecs: "avc1.64001f,mp4a.40.2"},
+	}
+	if !reflect.DeepEqual(master.Variants, want) {
+		t.Errorf("Parse() variants = %v, want %v", master.Variants, want)
+	}
+	if v := master.BestVariant(); v.URI != "720p60/index.m3u8" {
+		t.Errorf("BestVariant() = %v", v.URI)
+	}
+	if v := master.ClosestVariant(500); v.URI != "480/index.m3u8" {
+		t.Errorf("ClosestVariant() = %v", v.URI)
+	}
+}
+func TestParseLive(t *testing.T) {
+	_, media, err := Parse(strings.NewReader(livePlaylist))
+	if err != nil || media == nil {
+		t.Fatalf("Parse() = %v, %v", media, err)
+	}
+	if media.TargetDuration != 5 || media.MediaSequence != 1200 || !media.IsLive() || media.ContainsStart() {
+		t.Errorf("Parse() media = %+v", media)
+	}
+	if len(media.Segments) != 3 {
+		t.Fatalf("Parse() number of segments = %v", len(media.Segments))
+	}
+	second := media.Segments[1]
+	if second.SequenceNumber != 1201 || !second.ProgramDateTime.Equal(time.Date(2020, 5, 1, 10, 0, 5, 0, time.UTC)) {
+		t.Errorf("Parse() second segment = %+v", second)
+	}
+	third := media.Segments[2]
+	if !third.Discontinuity || third.Title != "title" || !third.ProgramDateTime.Equal(time.Date(2020, 5, 1, 10, 1, 0, 0, time.UTC)) {
+		t.Errorf("Parse() third segment = %+v", third)
+	}
+	if math.Abs(media.Duration()-14.5) > 0.0001 {
+		t.Errorf("Duration() = %v", media.Duration())
+	}
+	if media.LastSequenceNumber() != 1202 {
+		t.Errorf("LastSequenceNumber() = %v", media.LastSequenceNumber())
+	}
+	start, end, ok := media.Window()
+	if !ok || !start.Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2020, 5, 1, 10, 1, 4, 500000000, time.UTC)) {
+		t.Errorf("Window() = %v, %v, %v", start, end, ok)
+	}
+}
+func TestParseVod(t *testing.T) {
+	_, media, err := Parse(strings.NewReader(vodPlaylist))
+	if err != nil || media == nil {
+		t.Fatalf("Parse() = %v, %v", media, err)
+	}
+	if media.IsLive() || !media.ContainsStart() || media.PlaylistType != PlaylistTypeVOD {
+		t.Errorf("Parse() media = %+v", media)
+	}
+	if r := media.Segments[1].ByteRange; r == nil || r.Offset != 1000 || r.Length != 500 {
+		t.Errorf("Parse() byte range = %+v", r)
+	}
+	if media.Segments[1].MapURI != "init.mp4" {
+		t.Errorf("Parse() map uri = %v", media.Segments[1].MapURI)
+	}
+	if _, _, ok := media.Window(); ok {
+		t.Errorf("Window() ok without program date time")
+	}
+	if media.Segments[1].KeyMethod != "" || media.Segments[2].KeyMethod != "AES-128" || media.Segments[3].KeyMethod != "" || !media.Encrypted() {
+		t.Errorf("Parse() key methods = %+v", media.Segments)
+	}
+}
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+	}{
+		{"empty", ""},
+		{"not playlist", "<html></html>"},
+		{"bad duration", "#EXTM3U\n#EXTINF:abc,\nseg.ts"},
+		{"bad sequence", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(strings.NewReader(tt.playlist)); err == nil {
+				t.Errorf("Parse() error = nil")
+			}
+		})
+	}
+}
+func TestResolveURI(t *testing.T) {
+	got, err := ResolveURI("https://host/live/master.m3u8?token=1", "720/index.m3u8")
+	if err != nil || got != "https://host/live/720/index.m3u8" {
+		t.Errorf("ResolveURI() = %v, %v", got, err)
+	}
+}
+func TestTrailingSegments(t *testing.T) {
+	_, media, err := Parse(strings.NewReader(livePlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		duration float64
+		want     int64
+	}{
+		{"last segment", 4, 1202},
+		{"exact", 9.5, 1201},
+		{"partial segment", 9.6, 1200},
+		{"longer than playlist", 100, 1200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := media.TrailingSegments(tt.duration)
+			if len(segments) == 0 || segments[0].SequenceNumber != tt.want {
+				t.Errorf("TrailingSegments() = %v, want start at %v", segments, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package vigoler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ExtractorReleasesURLs is the releases page of every extractor. Binaries are downloaded from
+// <releases>/latest/download/<name> and pinned versions from <releases>/download/<version>/<name>.
+var ExtractorReleasesURLs = map[string]string{
+	YoutubeDlExtractor: "https://github.com/ytdl-org/youtube-dl/releases",
+	YtDlpExtractor:     "https://github.com/yt-dlp/yt-dlp/releases",
+}
+
+const managedStateFile = "state.json"
+
+// ExtractorVersion is an installed version of an extractor.
+type ExtractorVersion struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+// ExtractorUpdateResult is the result of the last update of a managed extractor.
+type ExtractorUpdateResult struct {
+	Time    time.Time `json:"time"`
+	Version string    `json:"version,omitempty"`
+	// Updated is true when a new version was installed.
+	Updated bool `json:"updated"`
+	// RolledBack is true when the new version was downloaded but failed the smoke test.
+	RolledBack bool   `json:"rolled_back"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ManagedExtractorStatus is the installed versions and the last update result of a managed extractor.
+type ManagedExtractorStatus struct {
+	Name       string                 `json:"name"`
+	Current    ExtractorVersion       `json:"current"`
+	Previous   *ExtractorVersion      `json:"previous,omitempty"`
+	Pinned     string                 `json:"pinned,omitempty"`
+	LastUpdate *ExtractorUpdateResult `json:"last_update,omitempty"`
+}
+type managedState struct {
+	Current  ExtractorVersion  `json:"current"`
+	Previous *ExtractorVersion `json:"previous,omitempty"`
+	// Failed is the versions that failed the smoke test, they are not installed again.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// ManagedExtractor is an extractor whose versions are installed in its own directory. Update download the latest
+// version (or the pinned version), check it against a local fixture and use it only if the check pass.
+type ManagedExtractor struct {
+	name        string
+	dir         string
+	pin         string
+	releasesURL string
+	mutex       sync.RWMutex
+	extractor   *YoutubeDlWrapper
+	state       managedState
+	lastUpdate  *ExtractorUpdateResult
+	// smokeTest check that the extractor work, it is replaced in tests.
+	smokeTest func(extractor *YoutubeDlWrapper) error
+}
+type SmokeTestError struct {
+	Version string
+	err     error
+}
+
+func (e *SmokeTestError) Error() string {
+	return fmt.Sprintf("Smoke test of version %s failed: %v", e.Version, e.err)
+}
+func (e *SmokeTestError) Type() string {
+	return "Smoke test error"
+}
+
+var errSmokeTestFailedBefore = errors.New("the version failed the smoke test before")
+
+// CreateManagedExtractor create extractor that install its versions in dir/name. When there is no installed version
+// the binary in path is used until the first update. pin is the version to install, empty for the latest version.
+func CreateManagedExtractor(name, path, dir, pin string) (*ManagedExtractor, error) {
+	releasesURL, ok := ExtractorReleasesURLs[name]
+	if !ok {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "name", argValue: name}
+	}
+	me := &ManagedExtractor{name: name, dir: filepath.Join(dir, name), pin: pin, releasesURL: releasesURL, smokeTest: fixtureSmokeTest}
+	if err := os.MkdirAll(me.dir, 0755); err != nil {
+		return nil, err
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(me.dir, managedStateFile)); err == nil {
+		if err = json.Unmarshal(data, &me.state); err != nil {
+			return nil, err
+		}
+	}
+	if me.state.Current.Path != "" {
+		extractor, err := CreateExtractor(name, me.state.Current.Path)
+		if err == nil {
+			me.extractor = extractor
+			return me, nil
+		}
+	}
+	extractor, err := CreateExtractor(name, path)
+	if extractor == nil {
+		return nil, err
+	}
+	me.extractor = extractor
+	me.state.Current = ExtractorVersion{Version: extractor.Info().Version, Path: extractor.Info().Path}
+	return me, err
+}
+func (me *ManagedExtractor) current() *YoutubeDlWrapper {
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+	return me.extractor
+}
+func (me *ManagedExtractor) GetUrls(url string) (*Async, error) {
+	return me.current().GetUrls(url)
+}
+func (me *ManagedExtractor) Info() ExtractorInfo {
+	return me.current().Info()
+}
+
+// Status return the installed versions and the result of the last update.
+func (me *ManagedExtractor) Status() ManagedExtractorStatus {
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+	status := ManagedExtractorStatus{Name: me.name, Current: me.state.Current, Pinned: me.pin}
+	if me.state.Previous != nil {
+		previous := *me.state.Previous
+		status.Previous = &previous
+	}
+	if me.lastUpdate != nil {
+		lastUpdate := *me.lastUpdate
+		status.LastUpdate = &lastUpdate
+	}
+	return status
+}
+func (me *ManagedExtractor) downloadURL() string {
+	if me.pin != "" {
+		return fmt.Sprintf("%s/download/%s/%s", me.releasesURL, me.pin, me.name)
+	}
+	return fmt.Sprintf("%s/latest/download/%s", me.releasesURL, me.name)
+}
+func downloadExecutable(url, path string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &HttpError{Video: url, ErrorMessage: resp.Status}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// isFailed return if the version failed the smoke test before.
+func (me *ManagedExtractor) isFailed(version string) bool {
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+	for _, failed := range me.state.Failed {
+		if failed == version {
+			return true
+		}
+	}
+	return false
+}
+
+// install download the new version and return the extractor of it, nil if the version is already installed. When
+// the new version is the previous version, the installed previous version is returned.
+func (me *ManagedExtractor) install() (*YoutubeDlWrapper, error) {
+	downloadPath := filepath.Join(me.dir, "."+me.name+".download")
+	defer os.Remove(downloadPath)
+	if err := downloadExecutable(me.downloadURL(), downloadPath); err != nil {
+		return nil, err
+	}
+	downloaded, err := CreateExtractor(me.name, downloadPath)
+	if err != nil {
+		return nil, err
+	}
+	version := downloaded.Info().Version
+	status := me.Status()
+	if version == status.Current.Version {
+		return nil, nil
+	}
+	if me.isFailed(version) {
+		return nil, &SmokeTestError{Version: version, err: errSmokeTestFailedBefore}
+	}
+	if status.Previous != nil && version == status.Previous.Version {
+		return CreateExtractor(me.name, status.Previous.Path)
+	}
+	versionDir := filepath.Join(me.dir, version)
+	if err = os.MkdirAll(versionDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(versionDir, me.name)
+	if err = os.Rename(downloadPath, path); err != nil {
+		return nil, err
+	}
+	return CreateExtractor(me.name, path)
+}
+
+// Update install the latest version or the pinned version. The new version is used only if it pass the smoke test,
+// otherwise it is removed, the current version is kept and the new version is not installed again.
+func (me *ManagedExtractor) Update() error {
+	if me.pin != "" && me.Status().Current.Version == me.pin {
+		me.setLastUpdate(ExtractorUpdateResult{Version: me.pin})
+		return nil
+	}
+	if me.pin != "" && me.isFailed(me.pin) {
+		err := &SmokeTestError{Version: me.pin, err: errSmokeTestFailedBefore}
+		me.setLastUpdate(ExtractorUpdateResult{Version: me.pin, RolledBack: true, Error: err.Error()})
+		return nil
+	}
+	extractor, err := me.install()
+	if smokeErr, ok := err.(*SmokeTestError); ok {
+		me.setLastUpdate(ExtractorUpdateResult{Version: smokeErr.Version, RolledBack: true, Error: err.Error()})
+		return nil
+	}
+	if err != nil {
+		me.setLastUpdate(ExtractorUpdateResult{Error: err.Error()})
+		return err
+	}
+	if extractor == nil {
+		me.setLastUpdate(ExtractorUpdateResult{Version: me.Status().Current.Version})
+		return nil
+	}
+	version := extractor.Info().Version
+	if err = me.smokeTest(extractor); err != nil {
+		me.mutex.Lock()
+		me.state.Failed = append(me.state.Failed, version)
+		me.saveState()
+		me.mutex.Unlock()
+		me.removeVersion(&ExtractorVersion{Version: version, Path: extractor.Info().Path})
+		err = &SmokeTestError{Version: version, err: err}
+		me.setLastUpdate(ExtractorUpdateResult{Version: version, RolledBack: true, Error: err.Error()})
+		return err
+	}
+	me.mutex.Lock()
+	removed := me.state.Previous
+	previous := me.state.Current
+	me.state = managedState{Current: ExtractorVersion{Version: version, Path: extractor.Info().Path}, Previous: &previous, Failed: me.state.Failed}
+	me.extractor = extractor
+	err = me.saveState()
+	me.mutex.Unlock()
+	me.removeVersion(removed)
+	result := ExtractorUpdateResult{Version: version, Updated: true}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	me.setLastUpdate(result)
+	return err
+}
+
+// Rollback use the previous version instead of the current version.
+func (me *ManagedExtractor) Rollback() error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if me.state.Previous == nil {
+		return &ArgumentError{stackTrack: debug.Stack(), argName: "Previous", argValue: nil}
+	}
+	extractor, err := CreateExtractor(me.name, me.state.Previous.Path)
+	if err != nil {
+		return err
+	}
+	current := me.state.Current
+	me.state = managedState{Current: *me.state.Previous, Previous: &current, Failed: me.state.Failed}
+	me.extractor = extractor
+	return me.saveState()
+}
+func (me *ManagedExtractor) setLastUpdate(result ExtractorUpdateResult) {
+	result.Time = time.Now()
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.lastUpdate = &result
+}
+
+// saveState must be called when the mutex is locked.
+func (me *ManagedExtractor) saveState() error {
+	data, err := json.Marshal(me.state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(me.dir, managedStateFile), data, 0644)
+}
+
+// removeVersion remove version that is installed in the managed directory.
+func (me *ManagedExtractor) removeVersion(version *ExtractorVersion) {
+	if version == nil {
+		return
+	}
+	me.mutex.RLock()
+	inUse := version.Path == me.state.Current.Path || (me.state.Previous != nil && version.Path == me.state.Previous.Path)
+	me.mutex.RUnlock()
+	versionDir := filepath.Dir(version.Path)
+	if !inUse && filepath.Dir(versionDir) == me.dir {
+		os.RemoveAll(versionDir)
+	}
+}
+
+// smokeTestFixture is a tiny file that is served as a video so the generic extractor can extract it.
+var smokeTestFixture = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+
+// fixtureSmokeTest serve a local video file and check that the extractor return it.
+func fixtureSmokeTest(extractor *YoutubeDlWrapper) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(smokeTestFixture)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+	async, err := extractor.GetUrls(fmt.Sprintf("http://%s/smoke.mp4", listener.Addr()))
+	if err != nil {
+		return err
+	}
+	result, err, _ := async.Get()
+	if err != nil {
+		return err
+	}
+	if videos := result.([]VideoUrl); len(videos) == 0 || len(videos[0].Formats) == 0 {
+		return fmt.Errorf("no formats were extracted from the fixture")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package vigoler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeExtractorScript return extractor that print version and extract every url as a single format video. Broken
+// extractor fail to extract.
+func fakeExtractorScript(version string, broken bool) string {
+	extract := `echo "{\"id\": \"smoke\", \"title\": \"smoke\", \"url\": \"$3\", \"ext\": \"mp4\"}"`
+	if broken {
+		extract = `echo "ERROR: broken release"; exit 1`
+	}
+	return fmt.Sprintf("#!/bin/sh\nif [ \"$1\" = \"--version\" ]; then echo %s; exit 0; fi\n%s\n", version, extract)
+}
+func TestManagedExtractor_Update(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test use shell script as the extractor")
+	}
+	latest := fakeExtractorScript("2.0", false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/download/yt-dlp":
+			w.Write([]byte(latest))
+		case "/download/1.5/yt-dlp":
+			w.Write([]byte(fakeExtractorScript("1.5", false)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	releasesURL := ExtractorReleasesURLs[YtDlpExtractor]
+	ExtractorReleasesURLs[YtDlpExtractor] = server.URL
+	defer func() {
+		ExtractorReleasesURLs[YtDlpExtractor] = releasesURL
+	}()
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "yt-dlp")
+	if err := ioutil.WriteFile(basePath, []byte(fakeExtractorScript("1.0", false)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	managedDir := filepath.Join(dir, "managed")
+	me, err := CreateManagedExtractor(YtDlpExtractor, basePath, managedDir, "")
+	if err != nil {
+		t.Fatalf("CreateManagedExtractor() error = %v", err)
+	}
+	if status := me.Status(); status.Current.Version != "1.0" || status.Previous != nil {
+		t.Fatalf("Status() = %+v before update", status)
+	}
+	if err = me.Update(); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	status := me.Status()
+	if status.Current.Version != "2.0" || status.Previous == nil || status.Previous.Version != "1.0" || !status.LastUpdate.Updated {
+		t.Fatalf("Status() = %+v after update", status)
+	}
+	if me.Info().Version != "2.0" || me.Info().Path != filepath.Join(managedDir, YtDlpExtractor, "2.0", YtDlpExtractor) {
+		t.Errorf("Info() = %+v after update", me.Info())
+	}
+	t.Run("same version", func(t *testing.T) {
+		if err := me.Update(); err != nil || me.Status().LastUpdate.Updated || me.Status().Current.Version != "2.0" {
+			t.Errorf("Update() = %v, status %+v", err, me.Status())
+		}
+	})
+	t.Run("broken release is rolled back", func(t *testing.T) {
+		latest = fakeExtractorScript("3.0", true)
+		defer func() {
+			latest = fakeExtractorScript("2.0", false)
+		}()
+		err := me.Update()
+		if _, ok := err.(*SmokeTestError); !ok {
+			t.Fatalf("Update() error = %v, want SmokeTestError", err)
+		}
+		status := me.Status()
+		if status.Current.Version != "2.0" || !status.LastUpdate.RolledBack || status.LastUpdate.Version != "3.0" {
+			t.Errorf("Status() = %+v", status)
+		}
+		if _, err = os.Stat(filepath.Join(managedDir, YtDlpExtractor, "3.0")); !os.IsNotExist(err) {
+			t.Errorf("broken version was not removed: %v", err)
+		}
+		smokeTests := 0
+		me.smokeTest = func(extractor *YoutubeDlWrapper) error {
+			smokeTests++
+			return fixtureSmokeTest(extractor)
+		}
+		defer func() {
+			me.smokeTest = fixtureSmokeTest
+		}()
+		if err = me.Update(); err != nil || smokeTests != 0 || !me.Status().LastUpdate.RolledBack {
+			t.Errorf("Update() of failed version = %v, %d smoke tests, status %+v", err, smokeTests, me.Status())
+		}
+	})
+	t.Run("state is restored", func(t *testing.T) {
+		restored, err := CreateManagedExtractor(YtDlpExtractor, basePath, managedDir, "")
+		if err != nil || restored.Info().Version != "2.0" || restored.Status().Previous.Version != "1.0" {
+			t.Errorf("CreateManagedExtractor() = %+v, %v", restored.Status(), err)
+		}
+	})
+	t.Run("rollback", func(t *testing.T) {
+		if err := me.Rollback(); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+		if status := me.Status(); status.Current.Version != "1.0" || status.Previous.Version != "2.0" || me.Info().Version != "1.0" {
+			t.Errorf("Status() = %+v after rollback", status)
+		}
+	})
+	t.Run("previous version is reused", func(t *testing.T) {
+		previousPath := me.Status().Previous.Path
+		if err := me.Update(); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if status := me.Status(); status.Current.Version != "2.0" || status.Current.Path != previousPath || status.Previous.Version != "1.0" {
+			t.Errorf("Status() = %+v after update to previous version", status)
+		}
+		if _, err := os.Stat(previousPath); err != nil {
+			t.Errorf("previous version was removed: %v", err)
+		}
+	})
+	t.Run("pin", func(t *testing.T) {
+		pinned, err := CreateManagedExtractor(YtDlpExtractor, basePath, filepath.Join(dir, "pinned"), "1.5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pinned.Update(); err != nil || pinned.Info().Version != "1.5" || pinned.Status().Pinned != "1.5" {
+			t.Fatalf("Update() = %v, status %+v", err, pinned.Status())
+		}
+		if err = pinned.Update(); err != nil || pinned.Status().LastUpdate.Updated {
+			t.Errorf("Update() of pinned version = %v, status %+v", err, pinned.Status())
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package vigoler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	str "strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultOutputTemplate name the file by the title of the video.
+	DefaultOutputTemplate = "{title}.{ext}"
+	// maxFileNameBytes is the longest name of file or directory, it leave room for the collision and part suffixes
+	// below the limit of most file systems.
+	maxFileNameBytes = 200
+	partSuffix       = ".part"
+	missingField     = "NA"
+)
+
+// OutputTemplate name output files from the metadata of their video. Fields are written as {name} and / separate
+// directories.
+type OutputTemplate struct {
+	// Root is the directory that the files are created in, the current directory if empty.
+	Root     string
+	Template string
+}
+
+var templateFields = map[string]func(VideoUrl) string{
+	"title":          func(v VideoUrl) string { return v.Name },
+	"id":             func(v VideoUrl) string { return v.ID },
+	"uploader":       func(v VideoUrl) string { return v.Uploader },
+	"channel":        func(v VideoUrl) string { return v.Channel },
+	"upload_date":    func(v VideoUrl) string { return v.UploadDate },
+	"extractor":      func(v VideoUrl) string { return v.Extractor },
+	"playlist_title": func(v VideoUrl) string { return v.PlaylistTitle },
+	"playlist_index": func(v VideoUrl) string {
+		if v.PlaylistIndex == 0 {
+			return ""
+		}
+		return strconv.Itoa(v.PlaylistIndex)
+	},
+}
+
+// windowsReservedNames can not be the name of file on windows, with or without extension.
+var windowsReservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true}
+
+// outputMutex prevent two outputs from choosing the same free name.
+var outputMutex sync.Mutex
+
+// ParseOutputTemplate validate template and return OutputTemplate that create the files under root.
+func ParseOutputTemplate(root, template string) (*OutputTemplate, error) {
+	if template == "" {
+		template = DefaultOutputTemplate
+	}
+	if _, err := expandTemplate(template, VideoUrl{}, ""); err != nil {
+		return nil, err
+	}
+	return &OutputTemplate{Root: root, Template: template}, nil
+}
+
+// expandTemplate replace the fields in template by their sanitized values, fields without value are NA.
+func expandTemplate(template string, video VideoUrl, ext string) (string, error) {
+	var result str.Builder
+	for {
+		start := str.IndexByte(template, '{')
+		if start == -1 {
+			if str.IndexByte(template, '}') != -1 {
+				return "", fmt.Errorf("unexpected } in output template")
+			}
+			result.WriteString(template)
+			return result.String(), nil
+		}
+		end := str.IndexByte(template[start:], '}')
+		if end == -1 || str.IndexByte(template[:start], '}') != -1 {
+			return "", fmt.Errorf("unbalanced braces in output template")
+		}
+		name := template[start+1 : start+end]
+		value := ext
+		if name != "ext" {
+			field, ok := templateFields[name]
+			if !ok {
+				return "", fmt.Errorf("unknown field %s in output template", name)
+			}
+			value = field(video)
+		}
+		if value == "" {
+			value = missingField
+		}
+		result.WriteString(template[:start])
+		result.WriteString(str.NewReplacer("/", "", `\`, "").Replace(value))
+		template = template[start+end+1:]
+	}
+}
+
+// SanitizeFileName return name without the characters that are not allowed in file names on windows and unix, with
+// at most maxFileNameBytes bytes. Names that are reserved on windows are prefixed by _.
+func SanitizeFileName(name string) string {
+	name = str.Map(func(r rune) rune {
+		if r < ' ' || str.ContainsRune(`\/:|?"*<>`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = truncateBytes(str.TrimSpace(name), maxFileNameBytes)
+	name = str.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	base := name
+	if i := str.IndexByte(base, '.'); i != -1 {
+		base = base[:i]
+	}
+	if windowsReservedNames[str.ToUpper(str.TrimSpace(base))] {
+		name = "_" + name
+	}
+	return name
+}
+
+// truncateBytes return the longest prefix of s with at most maxBytes bytes that does not cut a character.
+func truncateBytes(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
+// FileName return the path of the output of video with extension ext. Every directory and the name of the file are
+// sanitized, long file names are truncated before their extension.
+func (ot *OutputTemplate) FileName(video VideoUrl, ext string) (string, error) {
+	expanded, err := expandTemplate(ot.Template, video, ext)
+	if err != nil {
+		return "", err
+	}
+	parts := str.Split(expanded, "/")
+	for i, part := range parts[:len(parts)-1] {
+		parts[i] = SanitizeFileName(part)
+	}
+	name := parts[len(parts)-1]
+	if fileExt := filepath.Ext(name); fileExt != "" && len(fileExt) < maxFileNameBytes/2 {
+		name = SanitizeFileName(truncateBytes(str.TrimSuffix(name, fileExt), maxFileNameBytes-len(fileExt))) + SanitizeFileName(fileExt)
+	} else {
+		name = SanitizeFileName(name)
+	}
+	parts[len(parts)-1] = name
+	return filepath.Join(append([]string{ot.Root}, parts...)...), nil
+}
+
+// freeFileName return path or path with a number before its extension if path or its part file exist.
+func freeFileName(path string) string {
+	ext := filepath.Ext(path)
+	base := str.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		_, err := os.Stat(path)
+		_, partErr := os.Stat(path + partSuffix)
+		if os.IsNotExist(err) && os.IsNotExist(partErr) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// copyFile copy src to dst, used when src can not be renamed to dst because they are on different devices.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// moveOutput move output to path, or to a free name near it if path is taken, and return the new path. The file is
+// moved to a part file first so path exist only when it is complete.
+func moveOutput(output, path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	outputMutex.Lock()
+	path = freeFileName(path)
+	part := path + partSuffix
+	err := os.Rename(output, part)
+	moved := err == nil
+	if !moved {
+		// Reserve the part file before copying so other outputs does not choose the same name.
+		var file *os.File
+		if file, err = os.OpenFile(part, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+			err = file.Close()
+		}
+	}
+	outputMutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if !moved {
+		if err = copyFile(output, part); err != nil {
+			_ = os.Remove(part)
+			return "", err
+		}
+		_ = os.Remove(output)
+	}
+	if err = os.Rename(part, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Move move the output file of video to its path in the template and return the new path.
+func (ot *OutputTemplate) Move(video VideoUrl, output string) (string, error) {
+	path, err := ot.FileName(video, str.TrimPrefix(filepath.Ext(output), "."))
+	if err != nil {
+		return "", err
+	}
+	return moveOutput(output, path)
+}
+
+// OutputOnFinish return async with the result of async whose output file is moved to its path in the template when
+// async finish successfully.
+func (ot *OutputTemplate) OutputOnFinish(video VideoUrl, async *Async) *Async {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	outputAsync := CreateAsyncFromAsyncAsWaitAble(&wg, async)
+	go func() {
+		defer wg.Done()
+		result, err, warn := async.Get()
+		output, isFile := result.(string)
+		if err != nil || !isFile || outputAsync.isStopped {
+			outputAsync.SetResult(result, err, warn)
+			return
+		}
+		path, err := ot.Move(video, output)
+		if err != nil {
+			outputAsync.SetResult(output, err, warn)
+			return
+		}
+		outputAsync.SetResult(path, nil, warn)
+	}()
+	return &outputAsync
+}
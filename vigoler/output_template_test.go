@@ -0,0 +1,129 @@
+package vigoler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputTemplate_FileName(t *testing.T) {
+	video := VideoUrl{ID: "abc", Name: `Title: a/b "quoted"?`, Uploader: "Uploader", UploadDate: "20200102", PlaylistIndex: 3}
+	tests := []struct {
+		name     string
+		template string
+		video    VideoUrl
+		ext      string
+		want     string
+	}{
+		{"default", "", video, "mp4", filepath.Join("root", "Title ab quoted.mp4")},
+		{"directories", "{uploader}/{upload_date} - {title} [{id}].{ext}", video, "mkv", filepath.Join("root", "Uploader", "20200102 - Title ab quoted [abc].mkv")},
+		{"missing field", "{channel}/{playlist_index} {title}.{ext}", video, "mp4", filepath.Join("root", "NA", "3 Title ab quoted.mp4")},
+		{"escape root", "../{title}.{ext}", VideoUrl{Name: ".."}, "mp4", filepath.Join("root", "_", "_.mp4")},
+		{"reserved name", "{title}.{ext}", VideoUrl{Name: "con"}, "mp4", filepath.Join("root", "_con.mp4")},
+		{"long name", "{title}.{ext}", VideoUrl{Name: strings.Repeat("ש", 150)}, "mp4", filepath.Join("root", strings.Repeat("ש", 98)+".mp4")},
+		{"trailing dots", "{title}.{ext}", VideoUrl{Name: "name. "}, "mp4", filepath.Join("root", "name.mp4")},
+		{"missing ext", "{title}.{ext}", VideoUrl{Name: "name"}, "", filepath.Join("root", "name.NA")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := ParseOutputTemplate("root", tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := template.FileName(tt.video, tt.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+func TestParseOutputTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{"{title}.{ext}", false},
+		{"{uploader}/{title} [{id}].{ext}", false},
+		{"{unknown}.{ext}", true},
+		{"{title.{ext}", true},
+		{"title}.{ext}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if _, err := ParseOutputTemplate("", tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("ParseOutputTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+func Test_SanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"simple", "simple"},
+		{`a\b/c:d|e?f"g*h<i>j`, "abcdefghij"},
+		{"tab\tnew\nline", "tabnewline"},
+		{" spaces and dots.. ", "spaces and dots"},
+		{"...", "_"},
+		{"", "_"},
+		{"NUL.txt", "_NUL.txt"},
+		{"nullable", "nullable"},
+		{strings.Repeat("a", 300), strings.Repeat("a", maxFileNameBytes)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFileName(tt.name); got != tt.want {
+				t.Errorf("SanitizeFileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+func TestOutputTemplate_OutputOnFinish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	template, err := ParseOutputTemplate(filepath.Join(dir, "out"), "{uploader}/{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := VideoUrl{ID: "id", Name: "title", Uploader: "uploader"}
+	var paths []string
+	for i := 0; i < 3; i++ {
+		output := filepath.Join(dir, "download.mp4")
+		if err = ioutil.WriteFile(output, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		async := CreateAsyncWaitGroup(&wg, nil)
+		async.SetResult(output, nil, "")
+		path, err, _ := template.OutputOnFinish(video, &async).Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(path.(string)); len(data) != 1 || data[0] != byte(i) {
+			t.Errorf("OutputOnFinish() %s content = %v", path, data)
+		}
+		if _, err = os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("OutputOnFinish() did not remove %s", output)
+		}
+		paths = append(paths, path.(string))
+	}
+	want := []string{"title.mp4", "title (1).mp4", "title (2).mp4"}
+	for i, path := range paths {
+		if path != filepath.Join(dir, "out", "uploader", want[i]) {
+			t.Errorf("OutputOnFinish() path = %s, want %s", path, want[i])
+		}
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, "out", "uploader", "*"+partSuffix)); len(parts) != 0 {
+		t.Errorf("OutputOnFinish() left part files %v", parts)
+	}
+}
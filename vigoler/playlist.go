@@ -0,0 +1,243 @@
+package vigoler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	str "strings"
+	"sync"
+)
+
+// PlaylistFilter choose which entries of a playlist are listed. Zero value fields are not used.
+type PlaylistFilter struct {
+	// Start and End are the 1 based indices of the first and the last entries, both are included.
+	Start int
+	End   int
+	// DateAfter and DateBefore are upload dates in the format YYYYMMDD, both are included.
+	DateAfter  string
+	DateBefore string
+	Title      *regexp.Regexp
+	// MinDuration and MaxDuration are in seconds.
+	MinDuration float64
+	MaxDuration float64
+}
+
+// PlaylistEntry is a video in playlist that was listed but not resolved. Fields that the extractor does not list are
+// empty and are not filtered until the entry is resolved.
+type PlaylistEntry struct {
+	ID            string  `json:"id"`
+	URL           string  `json:"url"`
+	Title         string  `json:"title"`
+	Index         int     `json:"playlist_index"`
+	PlaylistTitle string  `json:"playlist_title,omitempty"`
+	Duration      float64 `json:"duration,omitempty"`
+	UploadDate    string  `json:"upload_date,omitempty"`
+	Extractor     string  `json:"extractor,omitempty"`
+}
+
+// PlaylistExtractor is an extractor that can list playlists without resolving every video in them.
+type PlaylistExtractor interface {
+	Extractor
+	// GetPlaylist list the entries of the playlist that match the filter. Entries are sent to the channel as soon as
+	// they are listed and the channel is closed when the listing finish, the channel must be drained. The result of the
+	// async is the number of entries that were sent.
+	GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error)
+}
+
+// args return the extractor arguments that limit the listing to the index range.
+func (pf PlaylistFilter) args() []string {
+	var args []string
+	if pf.Start > 1 {
+		args = append(args, "--playlist-start", strconv.Itoa(pf.Start))
+	}
+	if pf.End > 0 {
+		args = append(args, "--playlist-end", strconv.Itoa(pf.End))
+	}
+	return args
+}
+func (pf PlaylistFilter) match(index int, title, uploadDate string, duration float64) bool {
+	if index > 0 && ((pf.Start > 0 && index < pf.Start) || (pf.End > 0 && index > pf.End)) {
+		return false
+	}
+	if uploadDate != "" && ((pf.DateAfter != "" && uploadDate < pf.DateAfter) || (pf.DateBefore != "" && uploadDate > pf.DateBefore)) {
+		return false
+	}
+	if title != "" && pf.Title != nil && !pf.Title.MatchString(title) {
+		return false
+	}
+	return duration <= 0 || ((pf.MinDuration <= 0 || duration >= pf.MinDuration) && (pf.MaxDuration <= 0 || duration <= pf.MaxDuration))
+}
+
+// Match return if the entry match the filter. Unknown values match.
+func (pf PlaylistFilter) Match(entry PlaylistEntry) bool {
+	return pf.match(entry.Index, entry.Title, entry.UploadDate, entry.Duration)
+}
+
+// MatchVideo return if the resolved video match the filter. Unknown values match.
+func (pf PlaylistFilter) MatchVideo(video VideoUrl) bool {
+	return pf.match(video.PlaylistIndex, video.Name, video.UploadDate, video.Duration)
+}
+
+// toPlaylistEntry return the entry of flat listing line. index is used when the extractor does not report the index.
+func (v *youtubeDlVideo) toPlaylistEntry(url string, index int) PlaylistEntry {
+	entryURL := v.WebPageURL
+	if v.EntryType == "url" && v.URL != "" {
+		entryURL = v.URL
+	}
+	if entryURL == "" {
+		entryURL = url
+	}
+	if v.PlaylistIndex.Valid {
+		index = int(v.PlaylistIndex.Value)
+	}
+	return PlaylistEntry{ID: v.ID, URL: entryURL, Title: v.name(), Index: index, PlaylistTitle: v.playlistTitle(),
+		Duration: v.Duration.Value, UploadDate: v.UploadDate, Extractor: v.extractorKey()}
+}
+
+// readPlaylist send the entries in the extractor output that match the filter and return the number of entries that
+// were sent.
+func readPlaylist(output <-chan string, url string, filter PlaylistFilter, entries chan<- PlaylistEntry) (int, error, string) {
+	var err error
+	var warn str.Builder
+	index := filter.Start
+	if index < 1 {
+		index = 1
+	}
+	sent := 0
+	for s := range output {
+		if str.HasPrefix(s, "ERROR") {
+			err = classifyExtractorError(url, s)
+			warn.WriteString(s + "\n")
+			continue
+		}
+		if str.Contains(s, "WARNING") {
+			warn.WriteString(s + "\n")
+			continue
+		}
+		video, parseErr := parseYoutubeDlVideo([]byte(s))
+		if parseErr != nil {
+			warn.WriteString(parseErr.Error() + "\n")
+			continue
+		}
+		entry := video.toPlaylistEntry(url, index)
+		index = entry.Index + 1
+		if filter.Match(entry) {
+			entries <- entry
+			sent++
+		}
+	}
+	if sent != 0 {
+		err = nil
+	}
+	return sent, err, warn.String()
+}
+func (youdown *YoutubeDlWrapper) GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error) {
+	args := append([]string{"-i", "-j", "--flat-playlist"}, filter.args()...)
+	wa, output, err := youdown.app.runCommandChan(context.Background(), append(args, url)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := make(chan PlaylistEntry)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	async := CreateAsyncWaitGroup(&wg, wa)
+	go func() {
+		defer wg.Done()
+		defer close(entries)
+		async.SetResult(readPlaylist(output, url, filter, entries))
+	}()
+	return &async, entries, nil
+}
+func (me *ManagedExtractor) GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error) {
+	return me.current().GetPlaylist(url, filter)
+}
+
+// GetPlaylist list the playlist by the extractors that support playlists by order until one of them list entries or
+// fail with error that the next extractor can not fix.
+func (fe *FallbackExtractor) GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error) {
+	var extractors []PlaylistExtractor
+	for _, extractor := range fe.Extractors {
+		if playlistExtractor, ok := extractor.(PlaylistExtractor); ok {
+			extractors = append(extractors, playlistExtractor)
+		}
+	}
+	if len(extractors) == 0 {
+		return nil, nil, &ArgumentError{stackTrack: debug.Stack(), argName: "Extractors", argValue: fe.Extractors}
+	}
+	fwa := &fallbackWaitAble{done: make(chan struct{})}
+	async := createAsyncWaitAble(fwa)
+	entries := make(chan PlaylistEntry)
+	go func() {
+		defer close(fwa.done)
+		defer close(entries)
+		sent := 0
+		var err error
+		warn := ""
+		for _, extractor := range extractors {
+			var extractorAsync *Async
+			var extractorEntries <-chan PlaylistEntry
+			extractorAsync, extractorEntries, err = extractor.GetPlaylist(url, filter)
+			if err == nil {
+				if !fwa.setCurrent(extractorAsync) {
+					_ = extractorAsync.Stop()
+					for range extractorEntries {
+					}
+					err = &CancelError{}
+					break
+				}
+				for entry := range extractorEntries {
+					entries <- entry
+					sent++
+				}
+				var extractorWarn string
+				_, err, extractorWarn = extractorAsync.Get()
+				warn += extractorWarn
+				if !fwa.setCurrent(nil) {
+					err = &CancelError{}
+					break
+				}
+			}
+			if sent != 0 || err == nil || !shouldFallback(nil, err) {
+				break
+			}
+			warn += fmt.Sprintf("Extractor %s failed: %v\n", extractor.Info().Name, err)
+		}
+		async.SetResult(sent, err, warn)
+	}()
+	return &async, entries, nil
+}
+
+// ResolvePlaylistEntry extract the formats of the entry. The result of the async is []VideoUrl with the playlist index
+// and title of the entry, videos that do not match the filter are removed.
+func ResolvePlaylistEntry(extractor Extractor, entry PlaylistEntry, filter PlaylistFilter) (*Async, error) {
+	as, err := extractor.GetUrls(entry.URL)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	async := CreateAsyncFromAsyncAsWaitAble(&wg, as)
+	go func() {
+		defer wg.Done()
+		result, err, warn := as.Get()
+		var videos []VideoUrl
+		if result != nil {
+			for _, video := range result.([]VideoUrl) {
+				video.PlaylistIndex = entry.Index
+				if entry.PlaylistTitle != "" {
+					video.PlaylistTitle = entry.PlaylistTitle
+				}
+				if video.Extractor == "" {
+					video.Extractor = entry.Extractor
+				}
+				if filter.MatchVideo(video) {
+					videos = append(videos, video)
+				}
+			}
+		}
+		async.SetResult(videos, err, warn)
+	}()
+	return &async, nil
+}
@@ -0,0 +1,178 @@
+package vigoler
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+var playlistOutput = []string{
+	`{"_type": "url", "ie_key": "Youtube", "id": "a", "url": "https://www.youtube.com/watch?v=a", "title": "Episode 1", "duration": 600, "playlist_title": "Show"}`,
+	`WARNING: [youtube:tab] Incomplete data received`,
+	`{"_type": "url", "ie_key": "Youtube", "id": "b", "url": "https://www.youtube.com/watch?v=b", "title": "Trailer", "duration": 60, "playlist_title": "Show"}`,
+	`{"_type": "url", "ie_key": "Youtube", "id": "c", "url": "https://www.youtube.com/watch?v=c", "title": "Episode 2", "upload_date": "20210105", "playlist_title": "Show"}`,
+	`{"_type": "url", "ie_key": "Youtube", "id": "d", "url": "https://www.youtube.com/watch?v=d", "title": "Episode 3", "upload_date": "20201231", "playlist_index": 7}`,
+}
+
+func listTestPlaylist(filter PlaylistFilter) ([]PlaylistEntry, int, error, string) {
+	output := make(chan string, len(playlistOutput))
+	for _, line := range playlistOutput {
+		output <- line
+	}
+	close(output)
+	entries := make(chan PlaylistEntry, len(playlistOutput))
+	sent, err, warn := readPlaylist(output, "https://www.youtube.com/playlist?list=test", filter, entries)
+	close(entries)
+	var listed []PlaylistEntry
+	for entry := range entries {
+		listed = append(listed, entry)
+	}
+	return listed, sent, err, warn
+}
+func playlistEntriesIds(entries []PlaylistEntry) []string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+func Test_readPlaylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter PlaylistFilter
+		want   []string
+	}{
+		{"all", PlaylistFilter{}, []string{"a", "b", "c", "d"}},
+		{"index range", PlaylistFilter{End: 2}, []string{"a", "b"}},
+		{"title", PlaylistFilter{Title: regexp.MustCompile("^Episode")}, []string{"a", "c", "d"}},
+		{"min duration", PlaylistFilter{MinDuration: 120}, []string{"a", "c", "d"}},
+		{"max duration", PlaylistFilter{MaxDuration: 120}, []string{"b", "c", "d"}},
+		{"date", PlaylistFilter{DateAfter: "20210101"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, sent, err, warn := listTestPlaylist(tt.filter)
+			if err != nil || sent != len(tt.want) || warn == "" {
+				t.Errorf("readPlaylist() = %v, %v, %q", sent, err, warn)
+			}
+			if got := playlistEntriesIds(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readPlaylist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	entries, _, _, _ := listTestPlaylist(PlaylistFilter{})
+	want := PlaylistEntry{ID: "a", URL: "https://www.youtube.com/watch?v=a", Title: "Episode 1", Index: 1, PlaylistTitle: "Show", Duration: 600, Extractor: "Youtube"}
+	if entries[0] != want {
+		t.Errorf("readPlaylist() entry = %+v, want %+v", entries[0], want)
+	}
+	if entries[3].Index != 7 {
+		t.Errorf("readPlaylist() index = %v, want the index of the extractor", entries[3].Index)
+	}
+}
+func TestResolvePlaylistEntry(t *testing.T) {
+	extractor := &testExtractor{videos: []VideoUrl{{ID: "a", Name: "Episode 1", Duration: 600}}}
+	entry := PlaylistEntry{ID: "a", URL: "https://www.youtube.com/watch?v=a", Index: 3, PlaylistTitle: "Show"}
+	async, err := ResolvePlaylistEntry(extractor, entry, PlaylistFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err, _ := async.Get()
+	videos := result.([]VideoUrl)
+	if err != nil || len(videos) != 1 || videos[0].PlaylistIndex != 3 || videos[0].PlaylistTitle != "Show" {
+		t.Errorf("ResolvePlaylistEntry() = %+v, %v", videos, err)
+	}
+	async, _ = ResolvePlaylistEntry(extractor, entry, PlaylistFilter{MaxDuration: 60})
+	if result, err, _ = async.Get(); len(result.([]VideoUrl)) != 0 {
+		t.Errorf("ResolvePlaylistEntry() = %+v, %v, want filtered video", result, err)
+	}
+}
+func TestYoutubeDlWrapper_GetPlaylist(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test use shell script as the extractor")
+	}
+	path := filepath.Join(t.TempDir(), "yt-dlp")
+	script := "#!/bin/sh\necho \"$@\" >&2\nfor id in a b; do echo \"{\\\"_type\\\": \\\"url\\\", \\\"id\\\": \\\"$id\\\", \\\"url\\\": \\\"https://example.com/$id\\\"}\"; done\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	extractor := &YoutubeDlWrapper{app: externalApp{appLocation: path}}
+	async, entries, err := extractor.GetPlaylist("https://example.com/playlist", PlaylistFilter{Start: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []PlaylistEntry
+	for entry := range entries {
+		listed = append(listed, entry)
+	}
+	result, err, _ := async.Get()
+	want := []PlaylistEntry{{ID: "a", URL: "https://example.com/a", Index: 5}, {ID: "b", URL: "https://example.com/b", Index: 6}}
+	if err != nil || result.(int) != 2 || !reflect.DeepEqual(listed, want) {
+		t.Errorf("GetPlaylist() = %+v, %v, %v", listed, result, err)
+	}
+}
+
+type testPlaylistExtractor struct {
+	testExtractor
+	entries []PlaylistEntry
+}
+
+func (te *testPlaylistExtractor) GetPlaylist(url string, filter PlaylistFilter) (*Async, <-chan PlaylistEntry, error) {
+	te.calls++
+	entries := make(chan PlaylistEntry, len(te.entries))
+	for _, entry := range te.entries {
+		entries <- entry
+	}
+	close(entries)
+	var wg sync.WaitGroup
+	async := CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(len(te.entries), te.err, "")
+	return &async, entries, nil
+}
+func TestFallbackExtractor_GetPlaylist(t *testing.T) {
+	entries := []PlaylistEntry{{ID: "a"}, {ID: "b"}}
+	tests := []struct {
+		name        string
+		first       *testPlaylistExtractor
+		second      *testPlaylistExtractor
+		wantCalls   int
+		wantEntries int
+		wantErr     bool
+	}{
+		{"first succeed", &testPlaylistExtractor{entries: entries}, &testPlaylistExtractor{entries: entries}, 0, 2, false},
+		{"first failed", &testPlaylistExtractor{testExtractor: testExtractor{err: errors.New("ERROR: unsupported")}},
+			&testPlaylistExtractor{entries: entries}, 1, 2, false},
+		{"failed after entries", &testPlaylistExtractor{testExtractor: testExtractor{err: errors.New("ERROR: private video")}, entries: entries[:1]},
+			&testPlaylistExtractor{entries: entries}, 0, 1, true},
+		{"empty playlist", &testPlaylistExtractor{}, &testPlaylistExtractor{entries: entries}, 0, 0, false},
+		{"all failed", &testPlaylistExtractor{testExtractor: testExtractor{err: errors.New("first")}},
+			&testPlaylistExtractor{testExtractor: testExtractor{err: errors.New("second")}}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := FallbackExtractor{Extractors: []Extractor{&testExtractor{}, tt.first, tt.second}}
+			async, listed, err := fe.GetPlaylist("url", PlaylistFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for range listed {
+				count++
+			}
+			result, err, _ := async.Get()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetPlaylist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if count != tt.wantEntries || result.(int) != tt.wantEntries {
+				t.Errorf("GetPlaylist() entries = %v, result %v, want %v", count, result, tt.wantEntries)
+			}
+			if tt.second.calls != tt.wantCalls {
+				t.Errorf("GetPlaylist() fallback calls = %v, want %v", tt.second.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package vigoler
+
+import (
+	"runtime/debug"
+	"sort"
+)
+
+// CompatibilityProfile constrain the formats that are chosen so the output can be played by a device.
+// Empty list or zero limit is not constrained.
+type CompatibilityProfile struct {
+	Name string
+	// VideoCodecs and AudioCodecs are prefixes of the codecs the device can decode.
+	VideoCodecs []string
+	AudioCodecs []string
+	MaxHeight   float64
+	MaxFPS      float64
+	// MaxBitrate is the maximum total bitrate in KBit/s.
+	MaxBitrate float64
+	// Ext is the container of the output, empty keep the container of the format.
+	Ext string
+	// VideoEncoder and AudioEncoder are the ffmpeg encoders that are used when no format match the profile.
+	VideoEncoder string
+	AudioEncoder string
+}
+
+// profileTranscodeCRF is the quality of videos that are transcoded to match a profile, the max bitrate of the profile
+// is still kept.
+const profileTranscodeCRF = 23
+
+var CompatibilityProfiles = map[string]CompatibilityProfile{
+	"ios": {Name: "ios", VideoCodecs: []string{"avc1", "h264", "hvc1", "hev1", "h265"}, AudioCodecs: []string{"mp4a", "aac", "ac-3", "ec-3"},
+		MaxHeight: 2160, MaxFPS: 60, Ext: "mp4", VideoEncoder: "libx264", AudioEncoder: "aac"},
+	"smart-tv-h264": {Name: "smart-tv-h264", VideoCodecs: []string{"avc1", "h264"}, AudioCodecs: []string{"mp4a", "aac", "mp3"},
+		MaxHeight: 1080, MaxFPS: 30, MaxBitrate: 20000, Ext: "mp4", VideoEncoder: "libx264", AudioEncoder: "aac"},
+	"archive-best": {Name: "archive-best", Ext: "mkv"},
+}
+
+// GetCompatibilityProfile return the profile with the name.
+func GetCompatibilityProfile(name string) (CompatibilityProfile, error) {
+	profile, ok := CompatibilityProfiles[name]
+	if !ok {
+		return CompatibilityProfile{}, &ArgumentError{stackTrack: debug.Stack(), argName: "name", argValue: name}
+	}
+	return profile, nil
+}
+
+// CompatibilityProfilesNames return the names of the known profiles sorted.
+func CompatibilityProfilesNames() []string {
+	names := make([]string, 0, len(CompatibilityProfiles))
+	for name := range CompatibilityProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchCodec return if codec is one of codecs. Unknown codec match if the format is already in the profile container.
+func (p CompatibilityProfile) matchCodec(format Format, codec string, codecs []string) bool {
+	if codec == "" {
+		return len(codecs) == 0 || format.Ext == p.Ext
+	}
+	return len(codecs) == 0 || preferenceIndex(codec, codecs) != len(codecs)
+}
+
+// limitsMatch return if the resolution, fps and bitrate of the format are in the profile limits. Unknown values match.
+func (p CompatibilityProfile) limitsMatch(format Format) bool {
+	return (p.MaxHeight <= 0 || format.Height <= p.MaxHeight) && (p.MaxFPS <= 0 || format.FPS <= p.MaxFPS) &&
+		(p.MaxBitrate <= 0 || bitrate(format) <= p.MaxBitrate)
+}
+func (p CompatibilityProfile) videoMatch(format Format) bool {
+	return p.matchCodec(format, format.VCodec, p.VideoCodecs) && p.limitsMatch(format)
+}
+func (p CompatibilityProfile) audioMatch(format Format) bool {
+	return p.matchCodec(format, format.ACodec, p.AudioCodecs)
+}
+
+// transcodeSettings return how to transcode the selected formats to match the profile, nil if they already match.
+func (p CompatibilityProfile) transcodeSettings(selection FormatSelection) *TranscodeSettings {
+	settings := TranscodeSettings{}
+	video := selection.Formats[0]
+	if !p.videoMatch(video) {
+		settings.VideoEncoder = p.VideoEncoder
+		settings.MaxHeight = int(p.MaxHeight)
+		settings.MaxFPS = p.MaxFPS
+		settings.MaxVideoBitrate = int(p.MaxBitrate)
+		settings.CRF = profileTranscodeCRF
+	}
+	for _, audio := range selection.Formats {
+		if audio.HasAudio && !p.audioMatch(audio) {
+			settings.AudioEncoder = p.AudioEncoder
+		}
+	}
+	if settings.VideoEncoder == "" && settings.AudioEncoder == "" {
+		return nil
+	}
+	return &settings
+}
+
+func filterFormats(formats []Format, match func(Format) bool) []Format {
+	var matched []Format
+	for _, format := range formats {
+		if match(format) {
+			matched = append(matched, format)
+		}
+	}
+	return matched
+}
+
+// Candidates return for every track the formats that match the profile ordered from the most preferred. When no format
+// match the profile the tracks are the best formats and the settings to transcode them are returned, otherwise the
+// settings are nil.
+func (p CompatibilityProfile) Candidates(formats []Format, ranking FormatRanking) ([][]Format, *TranscodeSettings, error) {
+	combined := filterFormats(ranking.Order(formats, true, true), func(f Format) bool {
+		return p.videoMatch(f) && p.audioMatch(f)
+	})
+	videos := filterFormats(ranking.Order(formats, true, false), p.videoMatch)
+	audios := filterFormats(ranking.Order(formats, false, true), p.audioMatch)
+	if len(videos) != 0 && len(audios) != 0 && (len(combined) == 0 || ranking.Compare(videos[0], combined[0]) > 0) {
+		return [][]Format{videos, audios}, nil, nil
+	}
+	if len(combined) != 0 {
+		return [][]Format{combined}, nil, nil
+	}
+	allVideos := ranking.Order(formats, true, false)
+	allAudios := ranking.Order(formats, false, true)
+	var tracks [][]Format
+	if len(allVideos) != 0 && len(allAudios) != 0 {
+		if len(videos) == 0 {
+			videos = allVideos
+		}
+		if len(audios) == 0 {
+			audios = allAudios
+		}
+		tracks = [][]Format{videos, audios}
+	} else if bestFormats := ranking.Order(formats, true, true); len(bestFormats) != 0 {
+		tracks = [][]Format{bestFormats}
+	} else {
+		return nil, nil, &NoMatchingFormatError{Selector: p.Name}
+	}
+	return tracks, p.transcodeSettings(firstFormats(tracks)), nil
+}
+
+// Select choose the best formats that match the profile. When no format match the profile the best formats are
+// returned with the settings to transcode them, otherwise the settings are nil.
+func (p CompatibilityProfile) Select(formats []Format, ranking FormatRanking) (FormatSelection, *TranscodeSettings, error) {
+	tracks, settings, err := p.Candidates(formats, ranking)
+	if err != nil {
+		return FormatSelection{}, nil, err
+	}
+	return firstFormats(tracks), settings, nil
+}
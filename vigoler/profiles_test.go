@@ -0,0 +1,54 @@
+package vigoler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompatibilityProfile_Select(t *testing.T) {
+	avc1080 := Format{FormatID: "137", HasVideo: true, Ext: "mp4", VCodec: "avc1.640028", Height: 1080, FPS: 30, TBR: 4000}
+	avc720p60 := Format{FormatID: "298", HasVideo: true, Ext: "mp4", VCodec: "avc1.4d4020", Height: 720, FPS: 60, TBR: 3000}
+	vp92160 := Format{FormatID: "313", HasVideo: true, Ext: "webm", VCodec: "vp9", Height: 2160, FPS: 30, TBR: 15000}
+	av12160 := Format{FormatID: "401", HasVideo: true, Ext: "mp4", VCodec: "av01.0.12M.08", Height: 2160, FPS: 30, TBR: 12000}
+	aac := Format{FormatID: "140", HasAudio: true, Ext: "m4a", ACodec: "mp4a.40.2", ABR: 128}
+	opus := Format{FormatID: "251", HasAudio: true, Ext: "webm", ACodec: "opus", ABR: 160}
+	combined := Format{FormatID: "22", HasVideo: true, HasAudio: true, Ext: "mp4", VCodec: "avc1.64001F", ACodec: "mp4a.40.2", Height: 720, FPS: 30}
+	tests := []struct {
+		name         string
+		profile      string
+		formats      []Format
+		want         []string
+		wantSettings *TranscodeSettings
+	}{
+		{"smart tv native", "smart-tv-h264", []Format{avc1080, avc720p60, vp92160, av12160, aac, opus, combined}, []string{"137", "140"}, nil},
+		{"ios native", "ios", []Format{avc1080, vp92160, av12160, aac, opus}, []string{"137", "140"}, nil},
+		{"archive best", "archive-best", []Format{avc1080, vp92160, av12160, aac, opus, combined}, []string{"401", "251"}, nil},
+		{"combined is better", "smart-tv-h264", []Format{avc720p60, aac, combined}, []string{"22"}, nil},
+		{"transcode video", "smart-tv-h264", []Format{vp92160, av12160, aac}, []string{"401", "140"},
+			&TranscodeSettings{VideoEncoder: "libx264", MaxHeight: 1080, MaxFPS: 30, MaxVideoBitrate: 20000, CRF: 23}},
+		{"limit fps", "smart-tv-h264", []Format{avc720p60, aac}, []string{"298", "140"},
+			&TranscodeSettings{VideoEncoder: "libx264", MaxHeight: 1080, MaxFPS: 30, MaxVideoBitrate: 20000, CRF: 23}},
+		{"transcode audio", "smart-tv-h264", []Format{avc1080, opus}, []string{"137", "251"}, &TranscodeSettings{AudioEncoder: "aac"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := GetCompatibilityProfile(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selection, settings, err := profile.Select(tt.formats, DefaultFormatRanking)
+			if err != nil {
+				t.Fatalf("Select() error = %v", err)
+			}
+			if got := formatsIds(selection.Formats); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(settings, tt.wantSettings) {
+				t.Errorf("Select() settings = %+v, want %+v", settings, tt.wantSettings)
+			}
+		})
+	}
+	if _, err := GetCompatibilityProfile("unknown"); err == nil {
+		t.Errorf("GetCompatibilityProfile() error = nil")
+	}
+}
@@ -0,0 +1,160 @@
+package vigoler
+
+import (
+	"runtime/debug"
+	"sort"
+	str "strings"
+)
+
+// RankCriterion is a property of a format that is used to decide which format is better.
+type RankCriterion string
+
+const (
+	RankResolution RankCriterion = "resolution"
+	RankFPS        RankCriterion = "fps"
+	RankHDR        RankCriterion = "hdr"
+	RankCodec      RankCriterion = "codec"
+	RankBitrate    RankCriterion = "bitrate"
+	RankContainer  RankCriterion = "container"
+	RankProtocol   RankCriterion = "protocol"
+)
+
+var allRankCriteria = []RankCriterion{RankResolution, RankFPS, RankHDR, RankCodec, RankBitrate, RankContainer, RankProtocol}
+
+// FormatRanking order formats by its criteria, the first criterion that is different between two formats decide which
+// one is better. Codecs, Containers and Protocols are ordered from the most preferred, values that are not in the list
+// are worse than all the values in the list.
+type FormatRanking struct {
+	Criteria   []RankCriterion
+	Codecs     []string
+	Containers []string
+	Protocols  []string
+}
+
+// DefaultFormatRanking is the ranking that is used by GetFormatsOrder and by VideoUtils without Ranking. It should not be
+// changed, set VideoUtils.Ranking to use another ranking.
+var DefaultFormatRanking = FormatRanking{
+	Criteria:   allRankCriteria,
+	Codecs:     []string{"av01", "vp09", "vp9", "hev1", "hvc1", "h265", "avc1", "h264", "vp8", "opus", "mp4a", "aac", "vorbis", "mp3"},
+	Containers: []string{"mp4", "m4a", "webm", "mkv", "mov", "flv", "3gp"},
+	Protocols:  []string{"https", "http", "http_dash_segments", "m3u8_native", "m3u8"},
+}
+
+// ParseRankCriteria parse comma separated criteria. Criteria that are missing are added at the end in the default order.
+func ParseRankCriteria(criteria string) ([]RankCriterion, error) {
+	var parsed []RankCriterion
+	for _, name := range str.Split(criteria, ",") {
+		name = str.ToLower(str.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !containsCriterion(allRankCriteria, RankCriterion(name)) {
+			return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "criteria", argValue: name}
+		}
+		if !containsCriterion(parsed, RankCriterion(name)) {
+			parsed = append(parsed, RankCriterion(name))
+		}
+	}
+	for _, criterion := range allRankCriteria {
+		if !containsCriterion(parsed, criterion) {
+			parsed = append(parsed, criterion)
+		}
+	}
+	return parsed, nil
+}
+func containsCriterion(criteria []RankCriterion, criterion RankCriterion) bool {
+	for _, c := range criteria {
+		if c == criterion {
+			return true
+		}
+	}
+	return false
+}
+
+// preferenceIndex return the index of the first preference that value start with or len(preferences) if not found.
+func preferenceIndex(value string, preferences []string) int {
+	value = str.ToLower(value)
+	for i, preference := range preferences {
+		if value != "" && str.HasPrefix(value, preference) {
+			return i
+		}
+	}
+	return len(preferences)
+}
+func compareFloat(a, b float64) int {
+	if a > b {
+		return 1
+	} else if a < b {
+		return -1
+	}
+	return 0
+}
+
+// comparePreference return positive number when a is preferred over b.
+func comparePreference(a, b string, preferences []string) int {
+	return preferenceIndex(b, preferences) - preferenceIndex(a, preferences)
+}
+func bitrate(format Format) float64 {
+	if format.TBR > 0 {
+		return format.TBR
+	}
+	return format.VBR + format.ABR
+}
+func isHDR(format Format) bool {
+	return format.DynamicRange != "" && !str.EqualFold(format.DynamicRange, "SDR")
+}
+func (r FormatRanking) compareCriterion(criterion RankCriterion, a, b Format) int {
+	switch criterion {
+	case RankResolution:
+		if c := compareFloat(a.Height, b.Height); c != 0 {
+			return c
+		}
+		return compareFloat(a.Width, b.Width)
+	case RankFPS:
+		return compareFloat(a.FPS, b.FPS)
+	case RankHDR:
+		if isHDR(a) == isHDR(b) {
+			return 0
+		} else if isHDR(a) {
+			return 1
+		}
+		return -1
+	case RankCodec:
+		if c := comparePreference(a.VCodec, b.VCodec, r.Codecs); c != 0 {
+			return c
+		}
+		return comparePreference(a.ACodec, b.ACodec, r.Codecs)
+	case RankBitrate:
+		return compareFloat(bitrate(a), bitrate(b))
+	case RankContainer:
+		return comparePreference(a.Ext, b.Ext, r.Containers)
+	case RankProtocol:
+		return comparePreference(a.Protocol, b.Protocol, r.Protocols)
+	}
+	return 0
+}
+
+// Compare return positive number if a is better than b, negative number if b is better and 0 if they are equal.
+func (r FormatRanking) Compare(a, b Format) int {
+	for _, criterion := range r.Criteria {
+		if c := r.compareCriterion(criterion, a, b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Order return the formats that match needVideo and needAudio from the best format to the worst format. Formats that
+// are equal keep the extractor convention that later format is better.
+func (r FormatRanking) Order(formats []Format, needVideo, needAudio bool) []Format {
+	oFormats := make([]Format, 0)
+	for i := len(formats) - 1; i >= 0; i-- {
+		if formats[i].HasVideo == needVideo && formats[i].HasAudio == needAudio {
+			oFormats = append(oFormats, formats[i])
+		}
+	}
+	sort.SliceStable(oFormats, func(i, j int) bool {
+		return r.Compare(oFormats[i], oFormats[j]) > 0
+	})
+	return oFormats
+}
@@ -0,0 +1,129 @@
+package vigoler
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func formatsIds(formats []Format) []string {
+	ids := make([]string, 0, len(formats))
+	for _, format := range formats {
+		ids = append(ids, format.FormatID)
+	}
+	return ids
+}
+func TestFormatRanking_Order(t *testing.T) {
+	hd := Format{FormatID: "hd", HasVideo: true, Height: 1080, Width: 1920, FPS: 30, VCodec: "avc1.640028", Ext: "mp4", TBR: 4000}
+	tests := []struct {
+		name    string
+		ranking FormatRanking
+		formats []Format
+		want    []string
+	}{
+		{"resolution not in extractor order", DefaultFormatRanking, []Format{
+			hd,
+			{FormatID: "sd", HasVideo: true, Height: 480, Width: 854},
+			{FormatID: "hq", HasVideo: true, Height: 720, Width: 1280},
+		}, []string{"hd", "hq", "sd"}},
+		{"unknown resolution is the worst", DefaultFormatRanking, []Format{
+			{FormatID: "unknown", HasVideo: true, Height: -1, Width: -1},
+			{FormatID: "sd", HasVideo: true, Height: 480, Width: 854},
+		}, []string{"sd", "unknown"}},
+		{"fps", DefaultFormatRanking, []Format{
+			{FormatID: "60", HasVideo: true, Height: 1080, FPS: 60},
+			{FormatID: "30", HasVideo: true, Height: 1080, FPS: 30},
+		}, []string{"60", "30"}},
+		{"hdr", DefaultFormatRanking, []Format{
+			{FormatID: "hdr", HasVideo: true, Height: 1080, DynamicRange: "HDR10"},
+			{FormatID: "sdr", HasVideo: true, Height: 1080, DynamicRange: "SDR"},
+		}, []string{"hdr", "sdr"}},
+		{"codec efficiency before bitrate", DefaultFormatRanking, []Format{
+			{FormatID: "avc", HasVideo: true, Height: 1080, VCodec: "avc1.640028", TBR: 4000},
+			{FormatID: "av1", HasVideo: true, Height: 1080, VCodec: "av01.0.08M.08", TBR: 2000},
+			{FormatID: "vp9", HasVideo: true, Height: 1080, VCodec: "vp9", TBR: 2500},
+		}, []string{"av1", "vp9", "avc"}},
+		{"audio bitrate", DefaultFormatRanking, []Format{
+			{FormatID: "140", HasAudio: true, ACodec: "mp4a.40.2", ABR: 128, Ext: "m4a"},
+			{FormatID: "139", HasAudio: true, ACodec: "mp4a.40.5", ABR: 48, Ext: "m4a"},
+		}, []string{"140", "139"}},
+		{"container and protocol", DefaultFormatRanking, []Format{
+			{FormatID: "hls", HasVideo: true, HasAudio: true, Height: 720, Ext: "mp4", Protocol: "m3u8_native"},
+			{FormatID: "webm", HasVideo: true, HasAudio: true, Height: 720, Ext: "webm", Protocol: "https"},
+			{FormatID: "mp4", HasVideo: true, HasAudio: true, Height: 720, Ext: "mp4", Protocol: "https"},
+		}, []string{"mp4", "hls", "webm"}},
+		{"equal formats keep extractor order", DefaultFormatRanking, []Format{
+			{FormatID: "1", HasVideo: true},
+			{FormatID: "2", HasVideo: true},
+		}, []string{"2", "1"}},
+		{"custom criteria order", FormatRanking{Criteria: []RankCriterion{RankFPS, RankResolution}}, []Format{
+			{FormatID: "1080p30", HasVideo: true, Height: 1080, FPS: 30},
+			{FormatID: "720p60", HasVideo: true, Height: 720, FPS: 60},
+		}, []string{"720p60", "1080p30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatsIds(tt.ranking.Order(tt.formats, tt.formats[0].HasVideo, tt.formats[0].HasAudio)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func TestParseRankCriteria(t *testing.T) {
+	got, err := ParseRankCriteria("fps, codec,fps")
+	want := []RankCriterion{RankFPS, RankCodec, RankResolution, RankHDR, RankBitrate, RankContainer, RankProtocol}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRankCriteria() = %v, %v, want %v", got, err, want)
+	}
+	if _, err = ParseRankCriteria("size"); err == nil {
+		t.Errorf("ParseRankCriteria() error = nil for unknown criterion")
+	}
+}
+
+// TestFormatRanking_extractorOrder check that the order does not depend on the order of the formats in the extractor
+// output. The old single bubble pass ranking returned different best format for every shuffle.
+func TestFormatRanking_extractorOrder(t *testing.T) {
+	const fileName = "test_files/non_order_formats.json"
+	if data, err := ioutil.ReadFile(fileName); err == nil && strings.HasPrefix(string(data), "version https://git-lfs") {
+		t.Skip(fileName + " is a git lfs pointer, fetch it with git lfs pull")
+	}
+	videos, err, _ := readTestUrlsFile(t, fileName)
+	if err != nil || len(videos) != 1 {
+		t.Fatalf("getUrls() = %v, %v", videos, err)
+	}
+	formats := videos[0].Formats
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		random.Shuffle(len(formats), func(i, j int) {
+			formats[i], formats[j] = formats[j], formats[i]
+		})
+		assert(t, "GetFormatsOrder video and audio", formatsIds(GetFormatsOrder(formats, true, true)), []string{"22", "18", "43"})
+		assert(t, "GetFormatsOrder video", formatsIds(GetFormatsOrder(formats, true, false)), []string{"136", "135", "134", "133", "160"})
+		assert(t, "GetFormatsOrder audio", formatsIds(GetFormatsOrder(formats, false, true)), []string{"140", "139"})
+	}
+}
+func TestVideoUtils_ranking(t *testing.T) {
+	formats := []Format{
+		{FormatID: "high", HasVideo: true, HasAudio: true, Height: 720, FPS: 30},
+		{FormatID: "fast", HasVideo: true, HasAudio: true, Height: 480, FPS: 60},
+	}
+	criteria, err := ParseRankCriteria("fps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranking := DefaultFormatRanking
+	ranking.Criteria = criteria
+	vu := VideoUtils{}
+	if got := vu.GetBestFormat(formats, true, true).FormatID; got != "high" {
+		t.Errorf("GetBestFormat() = %v, want high by the default ranking", got)
+	}
+	vu.Ranking = &ranking
+	if got := vu.GetBestFormat(formats, true, true).FormatID; got != "fast" {
+		t.Errorf("GetBestFormat() = %v, want fast by the fps ranking", got)
+	}
+	if got := GetBestFormat(formats, true, true).FormatID; got != "high" {
+		t.Errorf("GetBestFormat() = %v, the ranking of VideoUtils changed the default ranking", got)
+	}
+}
@@ -0,0 +1,90 @@
+package vigoler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxFormatRefresh is the number of times the url of a format is refreshed during a single download.
+const maxFormatRefresh = 3
+
+// formatExpired return if the url of the format expire in less than expireMargin.
+func formatExpired(format Format, now time.Time) bool {
+	expire := urlExpire(format.URL)
+	return !expire.IsZero() && expire.Add(-expireMargin).Before(now)
+}
+
+// isFormatURLExpired return if the download of the format failed because its url expired. The url is checked by its
+// expire parameter and by requesting it again.
+func (vu *VideoUtils) isFormatURLExpired(format Format, err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, isCancel := err.(*CancelError); isCancel {
+		return false
+	}
+	if formatExpired(format, time.Now()) {
+		return true
+	}
+	if vu.Curl == nil {
+		return false
+	}
+	status, statusErr := vu.Curl.URLStatus(format.URL, format.HTTPHeaders)
+	return statusErr == nil && status == http.StatusForbidden
+}
+
+// refreshFormat extract the video again without the cache and return the format with the same format id.
+func (vu *VideoUtils) refreshFormat(url VideoUrl, format Format) (Format, error) {
+	vu.invalidate(url.extractorURL())
+	return vu.recreateURL(url, format)
+}
+
+// resumeDownload continue the download of format to output. Downloads that can not be resumed start again.
+func (vu *VideoUtils) resumeDownload(format Format, output string) (*Async, error) {
+	if format.Protocol == "https" {
+		return vu.Curl.ResumeHeaders(format.URL, format.HTTPHeaders, output)
+	}
+	if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return vu.Ffmpeg.DownloadHeaders(format.URL, format.HTTPHeaders, output)
+}
+
+// refreshingDownload return async whose result is output. When download fail because the url of the format expired,
+// the format is refreshed and the download continue from where it stopped.
+func (vu *VideoUtils) refreshingDownload(url VideoUrl, format Format, output string, download *Async) *Async {
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tWarn := ""
+		for refreshes := 0; ; refreshes++ {
+			_, err, warn := download.Get()
+			wa.remove(download)
+			tWarn += warn
+			if err == nil || async.isStopped || refreshes == maxFormatRefresh || !vu.isFormatURLExpired(format, err) {
+				async.SetResult(output, err, tWarn)
+				return
+			}
+			tWarn += fmt.Sprintf("Url of format %s expired, refreshing it.\n", format.FormatID)
+			if format, err = vu.refreshFormat(url, format); err == nil {
+				download, err = vu.resumeDownload(format, output)
+			}
+			if err != nil {
+				async.SetResult(output, err, tWarn)
+				return
+			}
+			wa.add(download)
+			if async.isStopped {
+				_ = download.Stop()
+			}
+		}
+	}()
+	return &async
+}
@@ -0,0 +1,105 @@
+package vigoler
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVideoUtils_downloadFormatRefresh(t *testing.T) {
+	content := testVideoContent(100000)
+	var expired int32
+	var resumedFrom string
+	server := startCurlTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") == "old" {
+			if atomic.LoadInt32(&expired) == 1 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Length", "100000")
+			if r.Method == http.MethodGet {
+				// The url expire in the middle of the transfer.
+				atomic.StoreInt32(&expired, 1)
+				w.Write(content[:len(content)/2])
+			}
+			return
+		}
+		resumedFrom = r.Header.Get("Range")
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	})
+	refreshed := Format{FormatID: "18", URL: server.URL + "/video?token=new", Protocol: "https", HTTPHeaders: map[string]string{}}
+	extractor := &testExtractor{videos: []VideoUrl{{ID: "id", Formats: []Format{refreshed}}}}
+	curl := CreateCurlWrapper(1)
+	vu := VideoUtils{Extractor: extractor, Curl: &curl}
+	download := func(t *testing.T, format Format) {
+		url := VideoUrl{ID: "id", WebPageURL: "https://example.com/watch?v=id", Formats: []Format{format}}
+		async, err := vu.downloadFormat(url, format, "mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err, warn := async.Get()
+		defer os.Remove(output.(string))
+		if err != nil {
+			t.Fatalf("downloadFormat() error = %v, warn = %s", err, warn)
+		}
+		if data, _ := ioutil.ReadFile(output.(string)); !bytes.Equal(data, content) {
+			t.Errorf("downloadFormat() downloaded %d bytes, want %d", len(data), len(content))
+		}
+	}
+	t.Run("expire during download", func(t *testing.T) {
+		download(t, Format{FormatID: "18", URL: server.URL + "/video?token=old", Protocol: "https", HTTPHeaders: map[string]string{}})
+		if extractor.calls != 1 || !strings.HasPrefix(resumedFrom, "bytes=50000-") {
+			t.Errorf("downloadFormat() extractor calls = %d, range = %s", extractor.calls, resumedFrom)
+		}
+	})
+	t.Run("expired before download", func(t *testing.T) {
+		download(t, Format{FormatID: "18", URL: server.URL + "/video?token=old&expire=1", Protocol: "https", HTTPHeaders: map[string]string{}})
+		if extractor.calls != 2 {
+			t.Errorf("downloadFormat() extractor calls = %d", extractor.calls)
+		}
+	})
+}
+
+type failingExtractor struct {
+	testExtractor
+}
+
+func (fe *failingExtractor) GetUrls(url string) (*Async, error) {
+	var wg sync.WaitGroup
+	async := CreateAsyncWaitGroup(&wg, nil)
+	async.SetResult(nil, errors.New("ERROR: unable to download webpage"), "")
+	return &async, nil
+}
+func TestVideoUtils_refreshFormatError(t *testing.T) {
+	vu := VideoUtils{Extractor: &failingExtractor{}}
+	url := VideoUrl{ID: "id", WebPageURL: "https://example.com/watch?v=id"}
+	if _, err := vu.refreshFormat(url, Format{FormatID: "18"}); err == nil {
+		t.Errorf("refreshFormat() error = nil, want the extractor error")
+	}
+}
+func Test_formatExpired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/video.mp4", false},
+		{"https://example.com/video.mp4?expire=1600003600", false},
+		{"https://example.com/video.mp4?expire=1600000030", true},
+		{"https://example.com/video.mp4?expire=1500000000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := formatExpired(Format{URL: tt.url}, now); got != tt.want {
+				t.Errorf("formatExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
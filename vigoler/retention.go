@@ -0,0 +1,129 @@
+package vigoler
+
+import (
+	"sync"
+	"time"
+)
+
+// LiveRetention decide which finished parts of a live recording to keep. Zero value of a limit disable it.
+type LiveRetention struct {
+	MaxParts    int
+	MaxSizeInKb int
+	MaxAge      time.Duration
+}
+type pinRange struct {
+	from time.Time
+	to   time.Time
+}
+type retainedPart struct {
+	part   LivePart
+	pinned bool
+}
+
+// LiveParts keep the finished parts of one live recording and drop the oldest parts when the retention is exceeded.
+// Pinned parts are never dropped and are not counted in the retention limits.
+type LiveParts struct {
+	Retention LiveRetention
+	mutex     sync.Mutex
+	parts     []retainedPart
+	pins      []pinRange
+}
+
+func (p pinRange) overlap(part LivePart) bool {
+	return !part.End.Before(p.from) && !part.Start.After(p.to)
+}
+func (lp *LiveParts) isPinned(part LivePart) bool {
+	for _, pin := range lp.pins {
+		if pin.overlap(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a finished part and return the parts that should be removed because of the retention.
+// Part without Start is considered to start when the previous part ended and part without End to end now.
+func (lp *LiveParts) Add(part LivePart) []LivePart {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	now := time.Now()
+	if part.End.IsZero() {
+		part.End = now
+	}
+	if part.Start.IsZero() {
+		part.Start = part.End
+		if len(lp.parts) != 0 {
+			part.Start = lp.parts[len(lp.parts)-1].part.End
+		}
+	}
+	lp.parts = append(lp.parts, retainedPart{part: part, pinned: lp.isPinned(part)})
+	return lp.applyRetention(now)
+}
+func (lp *LiveParts) applyRetention(now time.Time) []LivePart {
+	var removed []LivePart
+	count, sizeInKb := 0, 0
+	for _, p := range lp.parts {
+		if !p.pinned {
+			count++
+			sizeInKb += p.part.SizeInKb
+		}
+	}
+	kept := lp.parts[:0]
+	for i, p := range lp.parts {
+		isLast := i == len(lp.parts)-1
+		exceeded := (lp.Retention.MaxParts > 0 && count > lp.Retention.MaxParts) ||
+			(lp.Retention.MaxSizeInKb > 0 && sizeInKb > lp.Retention.MaxSizeInKb) ||
+			(lp.Retention.MaxAge > 0 && now.Sub(p.part.End) > lp.Retention.MaxAge)
+		// The newest part is always kept so there is something to download.
+		if !p.pinned && !isLast && exceeded {
+			removed = append(removed, p.part)
+			count--
+			sizeInKb -= p.part.SizeInKb
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	lp.parts = kept
+	return removed
+}
+
+// Pin keep the parts that contain the last window of the live (including the part that is still recorded) and return
+// the finished parts that were pinned.
+func (lp *LiveParts) Pin(window time.Duration) []LivePart {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	now := time.Now()
+	pin := pinRange{from: now.Add(-window), to: now}
+	lp.pins = append(lp.pins, pin)
+	var pinned []LivePart
+	for i := range lp.parts {
+		if pin.overlap(lp.parts[i].part) {
+			lp.parts[i].pinned = true
+			pinned = append(pinned, lp.parts[i].part)
+		}
+	}
+	return pinned
+}
+
+// Parts return the parts that are kept.
+func (lp *LiveParts) Parts() []LivePart {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	parts := make([]LivePart, 0, len(lp.parts))
+	for _, p := range lp.parts {
+		parts = append(parts, p.part)
+	}
+	return parts
+}
+
+// IsPinned return if the kept part with fileName is pinned.
+func (lp *LiveParts) IsPinned(fileName string) bool {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	for _, p := range lp.parts {
+		if p.part.FileName == fileName {
+			return p.pinned
+		}
+	}
+	return false
+}
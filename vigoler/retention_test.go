@@ -0,0 +1,71 @@
+package vigoler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func partsNames(parts []LivePart) []string {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		names = append(names, part.FileName)
+	}
+	return names
+}
+func TestLiveParts_Add(t *testing.T) {
+	now := time.Now()
+	parts := []LivePart{
+		{FileName: "1", Start: now.Add(-4 * time.Hour), End: now.Add(-3 * time.Hour), SizeInKb: 100},
+		{FileName: "2", Start: now.Add(-3 * time.Hour), End: now.Add(-2 * time.Hour), SizeInKb: 100},
+		{FileName: "3", Start: now.Add(-2 * time.Hour), End: now.Add(-1 * time.Hour), SizeInKb: 100},
+		{FileName: "4", Start: now.Add(-1 * time.Hour), End: now, SizeInKb: 100},
+	}
+	tests := []struct {
+		name        string
+		retention   LiveRetention
+		wantRemoved []string
+	}{
+		{"no retention", LiveRetention{}, []string{}},
+		{"max parts", LiveRetention{MaxParts: 2}, []string{"1", "2"}},
+		{"max size", LiveRetention{MaxSizeInKb: 250}, []string{"1", "2"}},
+		{"max age", LiveRetention{MaxAge: 150 * time.Minute}, []string{"1"}},
+		{"keep last part", LiveRetention{MaxSizeInKb: 50}, []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := LiveParts{Retention: tt.retention}
+			removed := make([]string, 0)
+			for _, part := range parts {
+				removed = append(removed, partsNames(lp.Add(part))...)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("Add() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if len(lp.Parts())+len(removed) != len(parts) {
+				t.Errorf("Parts() = %v", partsNames(lp.Parts()))
+			}
+		})
+	}
+}
+func TestLiveParts_Pin(t *testing.T) {
+	lp := LiveParts{Retention: LiveRetention{MaxParts: 1}}
+	now := time.Now()
+	lp.Add(LivePart{FileName: "1", Start: now.Add(-30 * time.Minute), End: now.Add(-20 * time.Minute)})
+	pinned := lp.Pin(25 * time.Minute)
+	if !reflect.DeepEqual(partsNames(pinned), []string{"1"}) {
+		t.Errorf("Pin() = %v", partsNames(pinned))
+	}
+	// Part that was recorded while pinning is also pinned.
+	if removed := lp.Add(LivePart{FileName: "2", End: now.Add(time.Minute)}); len(removed) != 0 {
+		t.Errorf("Add() removed pinned parts %v", partsNames(removed))
+	}
+	removed := lp.Add(LivePart{FileName: "3", Start: now.Add(time.Minute), End: now.Add(10 * time.Minute)})
+	removed = append(removed, lp.Add(LivePart{FileName: "4", Start: now.Add(10 * time.Minute), End: now.Add(20 * time.Minute)})...)
+	if !reflect.DeepEqual(partsNames(removed), []string{"3"}) {
+		t.Errorf("Add() removed = %v, want [3]", partsNames(removed))
+	}
+	if !reflect.DeepEqual(partsNames(lp.Parts()), []string{"1", "2", "4"}) {
+		t.Errorf("Parts() = %v", partsNames(lp.Parts()))
+	}
+}
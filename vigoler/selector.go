@@ -0,0 +1,367 @@
+package vigoler
+
+import (
+	"fmt"
+	"strconv"
+	str "strings"
+)
+
+// FormatSelector choose formats by expression like bestvideo[height<=1080][vcodec^=avc1]+bestaudio[ext=m4a]/best.
+// Alternatives are separated by "/" and the first alternative that match is chosen. Formats in alternative that are
+// joined by "+" are merged, the first one is the video and the rest are audio tracks.
+// Every format is one of best, worst, bestvideo, worstvideo, bestaudio, worstaudio (or b, w, bv, wv, ba, wa) or a
+// format id, followed by filters in brackets. Numeric filters (height, width, fps, filesize, tbr, abr, vbr) support
+// <, <=, >, >=, =, != and "?" after the operator to also match formats where the value is unknown. filesize is the
+// estimated size of the format (EstimateFormatSize) and support the suffixes K, M, G, T (and Ki, Mi, Gi, Ti).
+// String filters (ext, vcodec, acodec, protocol, format_id, language, format_note, dynamic_range) support =, !=,
+// ^= (prefix), $= (suffix) and *= (contains).
+type FormatSelector struct {
+	expression   string
+	alternatives [][]formatSpec
+}
+
+// FormatSelection is the formats that were chosen by FormatSelector.
+type FormatSelection struct {
+	Formats []Format
+}
+type formatSpec struct {
+	name    string
+	filters []formatFilter
+}
+type formatFilter struct {
+	key          string
+	op           string
+	value        string
+	number       float64
+	isNumeric    bool
+	matchUnknown bool
+}
+type SelectorParseError struct {
+	Expression string
+	Position   int
+	Message    string
+}
+type NoMatchingFormatError struct {
+	Selector string
+}
+
+const (
+	selectorBest       = "best"
+	selectorWorst      = "worst"
+	selectorBestVideo  = "bestvideo"
+	selectorWorstVideo = "worstvideo"
+	selectorBestAudio  = "bestaudio"
+	selectorWorstAudio = "worstaudio"
+)
+
+var selectorAliases = map[string]string{"b": selectorBest, "w": selectorWorst, "bv": selectorBestVideo,
+	"wv": selectorWorstVideo, "ba": selectorBestAudio, "wa": selectorWorstAudio}
+
+// numericFields return the value of the field of the format in a video of duration seconds.
+var numericFields = map[string]func(f Format, duration float64) float64{
+	"height":   func(f Format, duration float64) float64 { return f.Height },
+	"width":    func(f Format, duration float64) float64 { return f.Width },
+	"fps":      func(f Format, duration float64) float64 { return f.FPS },
+	"filesize": func(f Format, duration float64) float64 { return EstimateFormatSize(f, duration).SizeInKb },
+	"tbr":      func(f Format, duration float64) float64 { return f.TBR },
+	"abr":      func(f Format, duration float64) float64 { return f.ABR },
+	"vbr":      func(f Format, duration float64) float64 { return f.VBR },
+}
+var stringFields = map[string]func(Format) string{
+	"ext":           func(f Format) string { return f.Ext },
+	"vcodec":        func(f Format) string { return f.VCodec },
+	"acodec":        func(f Format) string { return f.ACodec },
+	"protocol":      func(f Format) string { return f.Protocol },
+	"format_id":     func(f Format) string { return f.FormatID },
+	"language":      func(f Format) string { return f.Language },
+	"format_note":   func(f Format) string { return f.FormatNote },
+	"dynamic_range": func(f Format) string { return f.DynamicRange },
+}
+
+// Operators are ordered so that longer operators are matched first when several operators start at the same position.
+var filterOperators = []string{"<=", ">=", "!=", "^=", "$=", "*=", "<", ">", "="}
+var sizeSuffixes = map[string]float64{"": 1, "k": 1000, "m": 1000 * 1000, "g": 1000 * 1000 * 1000,
+	"t": 1000 * 1000 * 1000 * 1000, "ki": 1024, "mi": 1024 * 1024, "gi": 1024 * 1024 * 1024, "ti": 1024 * 1024 * 1024 * 1024}
+
+func (e *SelectorParseError) Error() string {
+	return fmt.Sprintf("Invalid format selector %s at position %d: %s", e.Expression, e.Position, e.Message)
+}
+func (e *SelectorParseError) Type() string {
+	return "Format selector error"
+}
+func (e *NoMatchingFormatError) Error() string {
+	return fmt.Sprintf("No format match the selector %s", e.Selector)
+}
+func (e *NoMatchingFormatError) Type() string {
+	return "No matching format error"
+}
+
+// NeedMerge return if the selected formats should be merged to one file.
+func (fs FormatSelection) NeedMerge() bool {
+	return len(fs.Formats) > 1
+}
+func (fs *FormatSelector) String() string {
+	return fs.expression
+}
+
+// ParseFormatSelector parse selector expression.
+func ParseFormatSelector(expression string) (*FormatSelector, error) {
+	selector := &FormatSelector{expression: expression}
+	position := 0
+	for _, alternative := range splitSelector(expression, '/') {
+		var specs []formatSpec
+		for _, specExpression := range splitSelector(alternative, '+') {
+			spec, err := parseFormatSpec(expression, specExpression, position)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, spec)
+			position += len(specExpression) + 1
+		}
+		selector.alternatives = append(selector.alternatives, specs)
+	}
+	return selector, nil
+}
+
+// splitSelector split expression by sep that is not inside filter brackets, so filter values can contain "/" and "+".
+func splitSelector(expression string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(expression); i++ {
+		switch expression[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		case sep:
+			if depth == 0 {
+				parts = append(parts, expression[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, expression[start:])
+}
+func parseFormatSpec(expression, spec string, position int) (formatSpec, error) {
+	trimmed := str.TrimSpace(spec)
+	position += str.Index(spec, trimmed)
+	nameEnd := str.Index(trimmed, "[")
+	if nameEnd == -1 {
+		nameEnd = len(trimmed)
+	}
+	name := trimmed[:nameEnd]
+	if name == "" {
+		return formatSpec{}, &SelectorParseError{Expression: expression, Position: position, Message: "missing format"}
+	}
+	if alias, ok := selectorAliases[name]; ok {
+		name = alias
+	}
+	result := formatSpec{name: name}
+	rest := trimmed[nameEnd:]
+	position += nameEnd
+	for rest != "" {
+		end := str.Index(rest, "]")
+		if rest[0] != '[' || end == -1 {
+			return formatSpec{}, &SelectorParseError{Expression: expression, Position: position, Message: "filter must be inside brackets"}
+		}
+		filter, err := parseFormatFilter(rest[1:end])
+		if err != nil {
+			return formatSpec{}, &SelectorParseError{Expression: expression, Position: position, Message: err.Error()}
+		}
+		result.filters = append(result.filters, filter)
+		position += end + 1
+		rest = rest[end+1:]
+	}
+	return result, nil
+}
+
+// findFilterOperator return the position and the operator that appear first in filter, or -1 if filter does not have
+// operator.
+func findFilterOperator(filter string) (int, string) {
+	for i := range filter {
+		for _, op := range filterOperators {
+			if str.HasPrefix(filter[i:], op) {
+				return i, op
+			}
+		}
+	}
+	return -1, ""
+}
+func parseFormatFilter(filter string) (formatFilter, error) {
+	i, op := findFilterOperator(filter)
+	if i == -1 {
+		return formatFilter{}, fmt.Errorf("missing operator in filter %s", filter)
+	}
+	result := formatFilter{key: str.TrimSpace(filter[:i]), op: op, value: str.TrimSpace(filter[i+len(op):])}
+	if str.HasPrefix(result.value, "?") {
+		result.matchUnknown = true
+		result.value = str.TrimSpace(result.value[1:])
+	}
+	if _, ok := numericFields[result.key]; ok {
+		if op == "^=" || op == "$=" || op == "*=" {
+			return formatFilter{}, fmt.Errorf("operator %s is not supported for %s", op, result.key)
+		}
+		number, err := parseSelectorNumber(result.key, result.value)
+		if err != nil {
+			return formatFilter{}, err
+		}
+		result.isNumeric = true
+		result.number = number
+	} else if _, ok := stringFields[result.key]; ok {
+		if op == "<" || op == "<=" || op == ">" || op == ">=" {
+			return formatFilter{}, fmt.Errorf("operator %s is not supported for %s", op, result.key)
+		}
+	} else {
+		return formatFilter{}, fmt.Errorf("unknown field %s", result.key)
+	}
+	return result, nil
+}
+
+// parseSelectorNumber parse number of the key. filesize is returned in KB like Format.FileSize.
+func parseSelectorNumber(key, value string) (float64, error) {
+	if key != "filesize" {
+		return strconv.ParseFloat(value, 64)
+	}
+	lower := str.TrimSuffix(str.ToLower(value), "b")
+	numberEnd := str.IndexFunc(lower, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(lower)
+	}
+	suffix := lower[numberEnd:]
+	multiplier, ok := sizeSuffixes[suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	number, err := strconv.ParseFloat(lower[:numberEnd], 64)
+	if err != nil {
+		return 0, err
+	}
+	return number * multiplier / 1024, nil
+}
+func compareNumber(value float64, op string, number float64) bool {
+	switch op {
+	case "<":
+		return value < number
+	case "<=":
+		return value <= number
+	case ">":
+		return value > number
+	case ">=":
+		return value >= number
+	case "=":
+		return value == number
+	case "!=":
+		return value != number
+	}
+	return false
+}
+func compareString(value, op, wanted string) bool {
+	switch op {
+	case "=":
+		return value == wanted
+	case "!=":
+		return value != wanted
+	case "^=":
+		return str.HasPrefix(value, wanted)
+	case "$=":
+		return str.HasSuffix(value, wanted)
+	case "*=":
+		return str.Contains(value, wanted)
+	}
+	return false
+}
+func (ff formatFilter) match(format Format, duration float64) bool {
+	if ff.isNumeric {
+		value := numericFields[ff.key](format, duration)
+		if value <= 0 {
+			return ff.matchUnknown
+		}
+		return compareNumber(value, ff.op, ff.number)
+	}
+	value := stringFields[ff.key](format)
+	if value == "" && ff.matchUnknown {
+		return true
+	}
+	return compareString(value, ff.op, ff.value)
+}
+func (spec formatSpec) match(format Format, duration float64) bool {
+	for _, filter := range spec.filters {
+		if !filter.match(format, duration) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates return the formats that the spec can choose ordered from the most preferred.
+func (spec formatSpec) candidates(formats []Format, duration float64, ranking FormatRanking) []Format {
+	var ordered []Format
+	switch spec.name {
+	case selectorBest, selectorWorst:
+		ordered = ranking.Order(formats, true, true)
+	case selectorBestVideo, selectorWorstVideo:
+		ordered = ranking.Order(formats, true, false)
+	case selectorBestAudio, selectorWorstAudio:
+		ordered = ranking.Order(formats, false, true)
+	default:
+		for _, format := range formats {
+			if format.FormatID == spec.name {
+				ordered = append(ordered, format)
+			}
+		}
+	}
+	if spec.name == selectorWorst || spec.name == selectorWorstVideo || spec.name == selectorWorstAudio {
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	}
+	var matched []Format
+	for _, format := range ordered {
+		if spec.match(format, duration) {
+			matched = append(matched, format)
+		}
+	}
+	return matched
+}
+
+// Candidates return for every format in the first alternative that match, the formats that match it ordered from the
+// most preferred. This is useful to try the next format when the preferred one can not be used. duration is the
+// duration of the video in seconds that is used to estimate the size of the formats.
+func (fs *FormatSelector) Candidates(formats []Format, duration float64, ranking FormatRanking) ([][]Format, error) {
+	for _, alternative := range fs.alternatives {
+		tracks := make([][]Format, 0, len(alternative))
+		for _, spec := range alternative {
+			candidates := spec.candidates(formats, duration, ranking)
+			if len(candidates) == 0 {
+				break
+			}
+			tracks = append(tracks, candidates)
+		}
+		if len(tracks) == len(alternative) {
+			return tracks, nil
+		}
+	}
+	return nil, &NoMatchingFormatError{Selector: fs.expression}
+}
+
+// Select choose the formats by ranking.
+func (fs *FormatSelector) Select(formats []Format, duration float64, ranking FormatRanking) (FormatSelection, error) {
+	tracks, err := fs.Candidates(formats, duration, ranking)
+	if err != nil {
+		return FormatSelection{}, err
+	}
+	return firstFormats(tracks), nil
+}
+
+// firstFormats return the selection of the most preferred format of every track.
+func firstFormats(tracks [][]Format) FormatSelection {
+	selection := FormatSelection{Formats: make([]Format, 0, len(tracks))}
+	for _, track := range tracks {
+		selection.Formats = append(selection.Formats, track[0])
+	}
+	return selection
+}
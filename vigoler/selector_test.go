@@ -0,0 +1,176 @@
+package vigoler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatSelector_Select(t *testing.T) {
+	formats := []Format{
+		{FormatID: "140", HasAudio: true, Ext: "m4a", ACodec: "mp4a.40.2", ABR: 128, FileSize: 3000},
+		{FormatID: "251", HasAudio: true, Ext: "webm", ACodec: "opus", ABR: 160, FileSize: 3500},
+		{FormatID: "137", HasVideo: true, Ext: "mp4", VCodec: "avc1.640028", Height: 1080, Width: 1920, FPS: 30, FileSize: 200 * 1024},
+		{FormatID: "248", HasVideo: true, Ext: "webm", VCodec: "vp9", Height: 1080, Width: 1920, FPS: 30, FileSize: 150 * 1024},
+		{FormatID: "313", HasVideo: true, Ext: "webm", VCodec: "vp9", Height: 2160, Width: 3840, FPS: 30, FileSize: 900 * 1024},
+		{FormatID: "18", HasVideo: true, HasAudio: true, Ext: "mp4", VCodec: "avc1.42001E", ACodec: "mp4a.40.2", Height: 360, Width: 640, FileSize: -1},
+		{FormatID: "22", HasVideo: true, HasAudio: true, Ext: "mp4", VCodec: "avc1.64001F", ACodec: "mp4a.40.2", Height: 720, Width: 1280, FileSize: 400 * 1024},
+	}
+	tests := []struct {
+		name     string
+		selector string
+		want     []string
+		wantErr  bool
+	}{
+		{"best", "best", []string{"22"}, false},
+		{"worst", "worst", []string{"18"}, false},
+		{"best video and audio", "bestvideo+bestaudio", []string{"313", "251"}, false},
+		{"aliases", "bv+ba", []string{"313", "251"}, false},
+		{"filters", "bestvideo[height<=1080][vcodec^=avc1]+bestaudio[ext=m4a]", []string{"137", "140"}, false},
+		{"fallback", "bestvideo[height>4320]+bestaudio/best[filesize<500M]", []string{"22"}, false},
+		{"unknown size", "best[filesize<500M]", []string{"22"}, false},
+		{"match unknown", "best[filesize<?500K]", []string{"18"}, false},
+		{"format id", "18", []string{"18"}, false},
+		{"multiple audio", "137+140+251", []string{"137", "140", "251"}, false},
+		{"not equal", "bestvideo[ext!=webm]", []string{"137"}, false},
+		{"contains", "bestaudio[acodec*=40]", []string{"140"}, false},
+		{"no match", "bestvideo[fps>=60]", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseFormatSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseFormatSelector() error = %v", err)
+			}
+			selection, err := selector.Select(formats, 0, DefaultFormatRanking)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Select() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got := formatsIds(selection.Formats); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Select() = %v, want %v", got, tt.want)
+				}
+				if selection.NeedMerge() != (len(tt.want) > 1) {
+					t.Errorf("NeedMerge() = %v", selection.NeedMerge())
+				}
+			}
+		})
+	}
+}
+func TestFormatSelector_SelectEstimatedSize(t *testing.T) {
+	formats := []Format{
+		{FormatID: "high", HasVideo: true, HasAudio: true, Height: 720, TBR: 2000, FileSize: -1},
+		{FormatID: "low", HasVideo: true, HasAudio: true, Height: 360, TBR: 500, FileSize: -1},
+	}
+	selector, err := ParseFormatSelector("best[filesize<10M]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 seconds of 2000 KBit/s is 25 MB and of 500 KBit/s is 6.25 MB.
+	if selection, err := selector.Select(formats, 100, DefaultFormatRanking); err != nil || selection.Formats[0].FormatID != "low" {
+		t.Errorf("Select() = %v, %v, want low", selection, err)
+	}
+	if selection, err := selector.Select(formats, 0, DefaultFormatRanking); err == nil {
+		t.Errorf("Select() = %v, want error when the size can not be estimated", selection)
+	}
+}
+func TestParseFormatSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		want     [][]formatSpec
+	}{
+		{"slash in filter", "best[format_note=a/b]/worst", [][]formatSpec{
+			{{name: "best", filters: []formatFilter{{key: "format_note", op: "=", value: "a/b"}}}},
+			{{name: "worst"}},
+		}},
+		{"plus in filter", "bv[vcodec^=avc1+]+ba", [][]formatSpec{
+			{{name: "bestvideo", filters: []formatFilter{{key: "vcodec", op: "^=", value: "avc1+"}}}, {name: "bestaudio"}},
+		}},
+		{"operator in value", "best[format_note=a<=b]", [][]formatSpec{
+			{{name: "best", filters: []formatFilter{{key: "format_note", op: "=", value: "a<=b"}}}},
+		}},
+		{"longest operator", "best[format_note!=a=b][height>=720]", [][]formatSpec{
+			{{name: "best", filters: []formatFilter{{key: "format_note", op: "!=", value: "a=b"},
+				{key: "height", op: ">=", value: "720", number: 720, isNumeric: true}}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseFormatSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseFormatSelector() error = %v", err)
+			}
+			if !reflect.DeepEqual(selector.alternatives, tt.want) {
+				t.Errorf("ParseFormatSelector() = %+v, want %+v", selector.alternatives, tt.want)
+			}
+		})
+	}
+}
+func TestParseFormatSelectorErrors(t *testing.T) {
+	for _, selector := range []string{"", "best+", "best[height<=abc]", "best[unknown=1]", "best[height^=1]", "best[ext<mp4]", "best[height<=1080", "best[height]", "best[filesize<5X]"} {
+		t.Run(selector, func(t *testing.T) {
+			if _, err := ParseFormatSelector(selector); err == nil {
+				t.Errorf("ParseFormatSelector() error = nil")
+			} else if _, ok := err.(*SelectorParseError); !ok {
+				t.Errorf("ParseFormatSelector() error = %T, want SelectorParseError", err)
+			}
+		})
+	}
+}
+func Test_parseSelectorNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"2048", 2},
+		{"1K", 1000.0 / 1024},
+		{"1Ki", 1},
+		{"500M", 500.0 * 1000 * 1000 / 1024},
+		{"1GiB", 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got, err := parseSelectorNumber("filesize", tt.value); err != nil || got != tt.want {
+				t.Errorf("parseSelectorNumber() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+func TestVideoUtils_DownloadSelectorMaxSize(t *testing.T) {
+	url := VideoUrl{Name: "video", Duration: 100, Formats: []Format{
+		{FormatID: "video", HasVideo: true, VCodec: "avc1", Ext: "mp4", Height: 1080, FileSize: 50 * 1024},
+		{FormatID: "audio", HasAudio: true, ACodec: "mp4a", Ext: "m4a", FileSize: 2 * 1024},
+		{FormatID: "combined", HasVideo: true, HasAudio: true, VCodec: "avc1", ACodec: "mp4a", Ext: "mp4", Height: 720, FileSize: 30 * 1024},
+	}}
+	profile, err := GetCompatibilityProfile("smart-tv-h264")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vu := VideoUtils{}
+	tests := []struct {
+		name     string
+		download func() (*Async, error)
+	}{
+		{"selector", func() (*Async, error) { return vu.DownloadSelector(url, mustParseSelector(t, "best"), 1024, "") }},
+		{"selector merge", func() (*Async, error) { return vu.DownloadSelector(url, mustParseSelector(t, "bv+ba"), 1024, "") }},
+		{"profile", func() (*Async, error) { return vu.DownloadProfile(url, profile, 1024) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			async, err := tt.download()
+			if err == nil {
+				_, err, _ = async.Get()
+			}
+			if _, isTooBig := err.(*FileTooBigError); !isTooBig {
+				t.Errorf("download error = %v, want too big", err)
+			}
+		})
+	}
+}
+func mustParseSelector(t *testing.T, expression string) *FormatSelector {
+	selector, err := ParseFormatSelector(expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return selector
+}
@@ -0,0 +1,130 @@
+package vigoler
+
+import (
+	"sync"
+)
+
+// formatSizeInKb return the estimated size of the format or its size from requesting it when it can not be estimated.
+// Formats whose size is still unknown are treated as if they fit.
+func (vu *VideoUtils) formatSizeInKb(async *Async, url VideoUrl, format Format) (float64, string, error) {
+	estimate := EstimateFormatSize(format, url.Duration)
+	warn := ""
+	if estimate.Confidence == SizeUnknown {
+		var err error
+		if estimate, warn, err = vu.probeFormatSize(async, format); err != nil {
+			return 0, warn, err
+		}
+	}
+	return estimate.SizeInKb, warn, nil
+}
+
+// chooseMergeFormats return the best video format and the best format of every audio track whose combined size is
+// less than sizeInKb. Higher video quality is preferred over higher audio quality. Formats are ordered from the best
+// to the worst and size is called at most once for every format. video is nil if no combination fit.
+func chooseMergeFormats(videoFormats []Format, audioTracks [][]Format, sizeInKb float64, size func(Format) (float64, error)) (*Format, []Format, error) {
+	sizes := make(map[string]float64)
+	formatSize := func(format Format) (float64, error) {
+		if s, ok := sizes[format.FormatID]; ok {
+			return s, nil
+		}
+		s, err := size(format)
+		if err == nil {
+			sizes[format.FormatID] = s
+		}
+		return s, err
+	}
+	// minTracksSize[i] is the size of the smallest formats of the audio tracks from i.
+	var minTracksSize []float64
+	for i, video := range videoFormats {
+		videoSize, err := formatSize(video)
+		if err != nil {
+			return nil, nil, err
+		}
+		if videoSize >= sizeInKb {
+			continue
+		}
+		if minTracksSize == nil {
+			if minTracksSize, err = minFormatsSize(audioTracks, formatSize); err != nil {
+				return nil, nil, err
+			}
+		}
+		left := sizeInKb - videoSize
+		if minTracksSize[0] >= left {
+			continue
+		}
+		audios := make([]Format, 0, len(audioTracks))
+		for j, track := range audioTracks {
+			for _, audio := range track {
+				audioSize, _ := formatSize(audio)
+				if audioSize+minTracksSize[j+1] < left {
+					audios = append(audios, audio)
+					left -= audioSize
+					break
+				}
+			}
+		}
+		return &videoFormats[i], audios, nil
+	}
+	return nil, nil, nil
+}
+
+// minFormatsSize return for every track the combined size of the smallest formats of it and of the tracks after it.
+func minFormatsSize(tracks [][]Format, size func(Format) (float64, error)) ([]float64, error) {
+	minSizes := make([]float64, len(tracks)+1)
+	for i := len(tracks) - 1; i >= 0; i-- {
+		minSize := -1.0
+		for _, format := range tracks[i] {
+			s, err := size(format)
+			if err != nil {
+				return nil, err
+			}
+			if minSize == -1 || s < minSize {
+				minSize = s
+			}
+		}
+		minSizes[i] = minSize + minSizes[i+1]
+	}
+	return minSizes, nil
+}
+
+// downloadAndMergeMaxSize choose the video and audio formats that together fit in maxSizeInKb, then download and merge
+// them.
+func (vu *VideoUtils) downloadAndMergeMaxSize(url VideoUrl, maxSizeInKb int, ext string, videoFormats []Format, audioTracks [][]Format, languages []string) (*Async, error) {
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tWarn := ""
+		video, audios, err := chooseMergeFormats(videoFormats, audioTracks, float64(maxSizeInKb), func(format Format) (float64, error) {
+			size, warn, err := vu.formatSizeInKb(&async, url, format)
+			tWarn += warn
+			return size, err
+		})
+		if err == nil && video == nil {
+			err = &FileTooBigError{url: url}
+		}
+		if err != nil {
+			async.SetResult(nil, err, tWarn)
+			return
+		}
+		chosenTracks := make([][]Format, 0, len(audios))
+		for _, audio := range audios {
+			chosenTracks = append(chosenTracks, []Format{audio})
+		}
+		merge, err := vu.mergeFormats(url, ext, []Format{*video}, chosenTracks, languages)
+		if err != nil {
+			async.SetResult(nil, err, tWarn)
+			return
+		}
+		wa.add(merge)
+		if async.isStopped {
+			_ = merge.Stop()
+		}
+		output, err, warn := merge.Get()
+		wa.remove(merge)
+		async.SetResult(output, err, tWarn+warn)
+	}()
+	return &async, nil
+}
@@ -0,0 +1,64 @@
+package vigoler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_chooseMergeFormats(t *testing.T) {
+	sizes := map[string]float64{"1080": 800, "720": 500, "480": 200, "high": 250, "medium": 150, "low": 50, "en": 100, "fr": 60}
+	videos := []Format{{FormatID: "1080"}, {FormatID: "720"}, {FormatID: "480"}}
+	audios := []Format{{FormatID: "high"}, {FormatID: "medium"}, {FormatID: "low"}}
+	tests := []struct {
+		name       string
+		tracks     [][]Format
+		sizeInKb   float64
+		wantVideo  string
+		wantAudios []string
+	}{
+		{"best pair fit", [][]Format{audios}, 1100, "1080", []string{"high"}},
+		{"lower audio before lower video", [][]Format{audios}, 900, "1080", []string{"low"}},
+		{"each fit alone but not together", [][]Format{audios}, 820, "720", []string{"high"}},
+		{"lowest pair", [][]Format{audios}, 300, "480", []string{"low"}},
+		{"nothing fit", [][]Format{audios}, 250, "", nil},
+		{"multiple tracks", [][]Format{audios, {{FormatID: "en"}, {FormatID: "fr"}}}, 1000, "1080", []string{"low", "en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := make(map[string]int)
+			video, got, err := chooseMergeFormats(videos, tt.tracks, tt.sizeInKb, func(format Format) (float64, error) {
+				calls[format.FormatID]++
+				return sizes[format.FormatID], nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, count := range calls {
+				if count > 1 {
+					t.Errorf("chooseMergeFormats() size of %s called %d times", id, count)
+				}
+			}
+			if tt.wantVideo == "" {
+				if video != nil {
+					t.Errorf("chooseMergeFormats() video = %v, want nil", video.FormatID)
+				}
+				return
+			}
+			if video == nil || video.FormatID != tt.wantVideo {
+				t.Fatalf("chooseMergeFormats() video = %v, want %v", video, tt.wantVideo)
+			}
+			var ids []string
+			for _, audio := range got {
+				ids = append(ids, audio.FormatID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantAudios) {
+				t.Errorf("chooseMergeFormats() audios = %v, want %v", ids, tt.wantAudios)
+			}
+		})
+	}
+	sizeErr := errors.New("size error")
+	if _, _, err := chooseMergeFormats(videos, [][]Format{audios}, 1000, func(Format) (float64, error) { return 0, sizeErr }); err != sizeErr {
+		t.Errorf("chooseMergeFormats() error = %v, want %v", err, sizeErr)
+	}
+}
@@ -0,0 +1,143 @@
+package vigoler
+
+import (
+	"errors"
+
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
+)
+
+// SizeConfidence is how close the size estimate is expected to be to the real size.
+type SizeConfidence int
+
+const (
+	SizeUnknown SizeConfidence = iota
+	// SizeFromBitrate is the bitrate of the format multiplied by the duration of the video.
+	SizeFromBitrate
+	// SizeApproximate is the size that the extractor estimated or the size of the segments in the hls playlist.
+	SizeApproximate
+	SizeExact
+)
+
+// kbitToKb convert KBit to KB as used by Format.FileSize.
+const kbitToKb = 1000.0 / 8 / 1024
+
+// sizeMargins is the relative error of the estimates of every confidence.
+var sizeMargins = map[SizeConfidence]float64{SizeFromBitrate: 0.25, SizeApproximate: 0.1, SizeExact: 0}
+
+// SizeEstimate is the estimated size of format in KB.
+type SizeEstimate struct {
+	SizeInKb   float64        `json:"size"`
+	Confidence SizeConfidence `json:"confidence"`
+}
+
+// EstimateFormatSize return the size of the format without requesting it. The size is FileSize when the extractor
+// know it, then the size that the extractor approximated, then the bitrate of the format multiplied by the duration of
+// the video. The bitrate is the video or the audio bitrate for formats with only one of them and the total bitrate
+// otherwise.
+func EstimateFormatSize(format Format, duration float64) SizeEstimate {
+	if format.FileSize != -1 {
+		return SizeEstimate{SizeInKb: format.FileSize, Confidence: SizeExact}
+	}
+	if format.FileSizeApprox > 0 {
+		return SizeEstimate{SizeInKb: format.FileSizeApprox, Confidence: SizeApproximate}
+	}
+	bitrate := format.TBR
+	if format.HasVideo && !format.HasAudio && format.VBR > 0 {
+		bitrate = format.VBR
+	} else if format.HasAudio && !format.HasVideo && format.ABR > 0 {
+		bitrate = format.ABR
+	}
+	if bitrate <= 0 || duration <= 0 {
+		return SizeEstimate{}
+	}
+	return SizeEstimate{SizeInKb: bitrate * duration * kbitToKb, Confidence: SizeFromBitrate}
+}
+
+// Fits return if the real size is surely smaller than sizeInKb.
+func (se SizeEstimate) Fits(sizeInKb float64) bool {
+	return se.Confidence != SizeUnknown && se.SizeInKb*(1+sizeMargins[se.Confidence]) < sizeInKb
+}
+
+// TooBig return if the real size is surely not smaller than sizeInKb.
+func (se SizeEstimate) TooBig(sizeInKb float64) bool {
+	return se.Confidence != SizeUnknown && se.SizeInKb*(1-sizeMargins[se.Confidence]) >= sizeInKb
+}
+func isHLSFormat(format Format) bool {
+	return format.Protocol == "m3u8" || format.Protocol == "m3u8_native"
+}
+
+// hlsPlaylistSize return the size of the media playlist from the byte ranges of its segments, or from bandwidth in
+// bit/s multiplied by the duration of the playlist when the segments are not byte ranges.
+func hlsPlaylistSize(media *m3u8.MediaPlaylist, bandwidth int) SizeEstimate {
+	var bytes int64
+	for _, segment := range media.Segments {
+		if segment.ByteRange == nil {
+			bytes = -1
+			break
+		}
+		bytes += segment.ByteRange.Length
+	}
+	if bytes > 0 {
+		return SizeEstimate{SizeInKb: float64(bytes) / 1024, Confidence: SizeApproximate}
+	}
+	if bandwidth <= 0 || media.IsLive() {
+		return SizeEstimate{}
+	}
+	return SizeEstimate{SizeInKb: float64(bandwidth) / 1000 * media.Duration() * kbitToKb, Confidence: SizeApproximate}
+}
+
+// hlsFormatSize estimate the size of hls format from its playlist.
+func hlsFormatSize(format Format) (SizeEstimate, error) {
+	master, media, err := getPlaylist(format.URL, format.HTTPHeaders)
+	if err != nil {
+		return SizeEstimate{}, err
+	}
+	bandwidth := int(format.TBR * 1000)
+	if master != nil {
+		variant := master.ClosestVariant(int(format.Height))
+		if variant == nil {
+			return SizeEstimate{}, errors.New("master playlist without variants")
+		}
+		if variant.AverageBandwidth > 0 {
+			bandwidth = variant.AverageBandwidth
+		} else {
+			bandwidth = variant.Bandwidth
+		}
+		url, err := m3u8.ResolveURI(format.URL, variant.URI)
+		if err != nil {
+			return SizeEstimate{}, err
+		}
+		if _, media, err = getPlaylist(url, format.HTTPHeaders); err != nil {
+			return SizeEstimate{}, err
+		}
+		if media == nil {
+			return SizeEstimate{}, errors.New("variant of master playlist is not a media playlist")
+		}
+	}
+	return hlsPlaylistSize(media, bandwidth), nil
+}
+
+// probeFormatSize return the size of the format by requesting it. Hls formats are estimated from their playlist and
+// other formats are requested by ffmpeg. The confidence is SizeUnknown if the size is still unknown.
+func (vu *VideoUtils) probeFormatSize(async *Async, format Format) (SizeEstimate, string, error) {
+	if async.isStopped {
+		return SizeEstimate{}, "", &CancelError{}
+	}
+	if isHLSFormat(format) {
+		if estimate, err := hlsFormatSize(format); err == nil && estimate.Confidence != SizeUnknown {
+			return estimate, "", nil
+		}
+	}
+	as, err := vu.Ffmpeg.GetInputSizeHeaders(format.URL, format.HTTPHeaders)
+	if err != nil {
+		return SizeEstimate{}, "", err
+	}
+	size, err, warn := as.Get()
+	if err != nil {
+		return SizeEstimate{}, warn, err
+	}
+	if size.(int) < 0 {
+		return SizeEstimate{}, warn, nil
+	}
+	return SizeEstimate{SizeInKb: float64(size.(int)), Confidence: SizeExact}, warn, nil
+}
@@ -0,0 +1,125 @@
+package vigoler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/samitc/vigoler/2/vigoler/m3u8"
+)
+
+func TestEstimateFormatSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		duration float64
+		want     SizeEstimate
+	}{
+		{"file size", Format{FileSize: 1000, FileSizeApprox: 900, TBR: 8000}, 100, SizeEstimate{1000, SizeExact}},
+		{"approximate", Format{FileSize: -1, FileSizeApprox: 900, TBR: 8000}, 100, SizeEstimate{900, SizeApproximate}},
+		{"total bitrate", Format{FileSize: -1, HasVideo: true, HasAudio: true, TBR: 1024}, 8, SizeEstimate{1000, SizeFromBitrate}},
+		{"video bitrate", Format{FileSize: -1, HasVideo: true, TBR: 2048, VBR: 1024}, 8, SizeEstimate{1000, SizeFromBitrate}},
+		{"audio bitrate", Format{FileSize: -1, HasAudio: true, TBR: 2048, ABR: 1024}, 8, SizeEstimate{1000, SizeFromBitrate}},
+		{"no duration", Format{FileSize: -1, TBR: 1024}, 0, SizeEstimate{}},
+		{"no bitrate", Format{FileSize: -1}, 100, SizeEstimate{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateFormatSize(tt.format, tt.duration); got != tt.want {
+				t.Errorf("EstimateFormatSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func TestSizeEstimate_FitsTooBig(t *testing.T) {
+	tests := []struct {
+		estimate   SizeEstimate
+		sizeInKb   float64
+		wantFits   bool
+		wantTooBig bool
+	}{
+		{SizeEstimate{100, SizeExact}, 101, true, false},
+		{SizeEstimate{100, SizeExact}, 100, false, true},
+		{SizeEstimate{100, SizeApproximate}, 115, true, false},
+		{SizeEstimate{100, SizeApproximate}, 105, false, false},
+		{SizeEstimate{100, SizeApproximate}, 85, false, true},
+		{SizeEstimate{100, SizeFromBitrate}, 110, false, false},
+		{SizeEstimate{100, SizeFromBitrate}, 130, true, false},
+		{SizeEstimate{100, SizeFromBitrate}, 70, false, true},
+		{SizeEstimate{}, 1, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v in %v", tt.estimate, tt.sizeInKb), func(t *testing.T) {
+			if got := tt.estimate.Fits(tt.sizeInKb); got != tt.wantFits {
+				t.Errorf("Fits() = %v, want %v", got, tt.wantFits)
+			}
+			if got := tt.estimate.TooBig(tt.sizeInKb); got != tt.wantTooBig {
+				t.Errorf("TooBig() = %v, want %v", got, tt.wantTooBig)
+			}
+		})
+	}
+}
+func Test_hlsPlaylistSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		playlist  string
+		bandwidth int
+		want      SizeEstimate
+	}{
+		{"byte ranges", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\n#EXT-X-BYTERANGE:1024@0\nvideo.mp4\n#EXTINF:10,\n#EXT-X-BYTERANGE:2048\nvideo.mp4\n#EXT-X-ENDLIST\n",
+			0, SizeEstimate{3, SizeApproximate}},
+		{"bandwidth", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:4,\n1.ts\n#EXTINF:4,\n2.ts\n#EXT-X-ENDLIST\n",
+			1024000, SizeEstimate{1000, SizeApproximate}},
+		{"live", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:4,\n1.ts\n", 1024000, SizeEstimate{}},
+		{"no bandwidth", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:4,\n1.ts\n#EXT-X-ENDLIST\n", 0, SizeEstimate{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, media, err := m3u8.Parse(strings.NewReader(tt.playlist))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hlsPlaylistSize(media, tt.bandwidth); got != tt.want {
+				t.Errorf("hlsPlaylistSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_hlsFormatSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=4096000,AVERAGE-BANDWIDTH=2048000,RESOLUTION=1280x720\n720.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1024000,RESOLUTION=640x360\n360.m3u8\n"))
+		case "/720.m3u8", "/360.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:4,\n1.ts\n#EXTINF:4,\n2.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	tests := []struct {
+		name    string
+		format  Format
+		want    SizeEstimate
+		wantErr bool
+	}{
+		{"average bandwidth", Format{URL: server.URL + "/master.m3u8", Height: 720}, SizeEstimate{2000, SizeApproximate}, false},
+		{"bandwidth", Format{URL: server.URL + "/master.m3u8", Height: 360}, SizeEstimate{1000, SizeApproximate}, false},
+		{"media playlist", Format{URL: server.URL + "/360.m3u8", TBR: 1024}, SizeEstimate{1000, SizeApproximate}, false},
+		{"missing", Format{URL: server.URL + "/missing.m3u8"}, SizeEstimate{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hlsFormatSize(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hlsFormatSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("hlsFormatSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
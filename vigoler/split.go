@@ -0,0 +1,187 @@
+package vigoler
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	str "strings"
+	"sync"
+)
+
+// splitSizeMargin is the part of MaxSizeInKb that parts are planned to, parts can be bigger because they are split at
+// key frames.
+const splitSizeMargin = 0.95
+
+// maxResplitDepth is the number of times that part which is bigger than MaxSizeInKb is split again.
+const maxResplitDepth = 2
+
+// SplitSettings is how downloaded video is split to parts. Zero values are not used and parts are split by chapters
+// first and then by size and time.
+type SplitSettings struct {
+	MaxSizeInKb  int
+	MaxTimeInSec int
+	ByChapters   bool
+}
+
+func (ss SplitSettings) IsEnabled() bool {
+	return ss.MaxSizeInKb > 0 || ss.MaxTimeInSec > 0 || ss.ByChapters
+}
+
+// maxPartDuration return the longest duration of part in seconds, 0 if the parts are not limited.
+func (ss SplitSettings) maxPartDuration(duration, sizeInKb float64) float64 {
+	maxDuration := float64(ss.MaxTimeInSec)
+	if ss.MaxSizeInKb > 0 && sizeInKb > float64(ss.MaxSizeInKb) {
+		sizeDuration := duration * float64(ss.MaxSizeInKb) * splitSizeMargin / sizeInKb
+		if maxDuration <= 0 || sizeDuration < maxDuration {
+			maxDuration = sizeDuration
+		}
+	}
+	return maxDuration
+}
+
+// splitTimes return the start times of the parts after the first. Chapters are parts by themselves and parts that are
+// longer than the limits are split to equal parts.
+func splitTimes(settings SplitSettings, duration, sizeInKb float64, chapters []Chapter) []float64 {
+	bounds := []float64{0}
+	if settings.ByChapters {
+		for _, chapter := range chapters {
+			if chapter.StartTime > bounds[len(bounds)-1] && chapter.StartTime < duration {
+				bounds = append(bounds, chapter.StartTime)
+			}
+		}
+	}
+	bounds = append(bounds, duration)
+	maxDuration := settings.maxPartDuration(duration, sizeInKb)
+	var times []float64
+	for i := 0; i < len(bounds)-1; i++ {
+		if i != 0 {
+			times = append(times, bounds[i])
+		}
+		if maxDuration <= 0 {
+			continue
+		}
+		length := bounds[i+1] - bounds[i]
+		parts := math.Ceil(length / maxDuration)
+		for j := 1.0; j < parts; j++ {
+			times = append(times, bounds[i]+j*length/parts)
+		}
+	}
+	return times
+}
+
+// partsFileNames return the names of the parts that Split can create by outputPattern.
+func partsFileNames(outputPattern string, count int) []string {
+	names := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		names = append(names, fmt.Sprintf(outputPattern, i))
+	}
+	return names
+}
+
+// splitFile split input to parts that start at times and remove it. The split is in wa while it is running.
+func (vu *VideoUtils) splitFile(wa *multipleWaitAble, input string, times []float64) ([]string, string, error) {
+	ext := filepath.Ext(input)
+	outputPattern := str.ReplaceAll(str.TrimSuffix(input, ext), "%", "%%") + ".%d" + ext
+	split, err := vu.Ffmpeg.Split(input, outputPattern, times)
+	if err != nil {
+		return nil, "", err
+	}
+	wa.add(split)
+	result, err, warn := split.Get()
+	wa.remove(split)
+	if err != nil {
+		for _, part := range partsFileNames(outputPattern, len(times)+1) {
+			_ = os.Remove(part)
+		}
+		return nil, warn, err
+	}
+	_ = os.Remove(input)
+	return result.([]string), warn, nil
+}
+
+// splitBigParts split again the parts that are bigger than maxSizeInKb because they were cut at key frames that are
+// far from the planned times. secondsPerKb is the average duration of KB of the video. The parts are returned also on
+// error so they can be removed.
+func (vu *VideoUtils) splitBigParts(wa *multipleWaitAble, parts []string, maxSizeInKb int, secondsPerKb float64, depth int) ([]string, string, error) {
+	warn := ""
+	result := make([]string, 0, len(parts))
+	for i, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return append(result, parts[i:]...), warn, err
+		}
+		sizeInKb := float64(info.Size()) / 1024
+		if sizeInKb <= float64(maxSizeInKb) {
+			result = append(result, part)
+			continue
+		}
+		times := splitTimes(SplitSettings{MaxSizeInKb: maxSizeInKb}, sizeInKb*secondsPerKb, sizeInKb, nil)
+		if depth == maxResplitDepth || len(times) == 0 {
+			warn += fmt.Sprintf("Part %s is %.0f KB, more than %d KB, and can not be split at its key frames.\n", part, sizeInKb, maxSizeInKb)
+			result = append(result, part)
+			continue
+		}
+		subParts, sWarn, err := vu.splitFile(wa, part, times)
+		warn += sWarn
+		if err != nil {
+			return append(result, parts[i:]...), warn, err
+		}
+		subParts, sWarn, err = vu.splitBigParts(wa, subParts, maxSizeInKb, secondsPerKb, depth+1)
+		warn += sWarn
+		result = append(result, subParts...)
+		if err != nil {
+			return append(result, parts[i+1:]...), warn, err
+		}
+	}
+	return result, warn, nil
+}
+
+// SplitDownload split the output of download to parts when it finish. The result is the names of the parts ordered by
+// their time, video that does not need to be split is the only part.
+func (vu *VideoUtils) SplitDownload(url VideoUrl, download *Async, settings SplitSettings) *Async {
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		output, err, warn := download.Get()
+		wa.remove(download)
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		input := output.(string)
+		if url.Duration <= 0 {
+			async.SetResult([]string{input}, nil, warn+"Duration of the video is unknown, the video is not split.\n")
+			return
+		}
+		info, err := os.Stat(input)
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		times := splitTimes(settings, url.Duration, float64(info.Size())/1024, url.Chapters)
+		if len(times) == 0 {
+			async.SetResult([]string{input}, nil, warn)
+			return
+		}
+		parts, sWarn, err := vu.splitFile(&wa, input, times)
+		warn += sWarn
+		if err == nil && settings.MaxSizeInKb > 0 {
+			parts, sWarn, err = vu.splitBigParts(&wa, parts, settings.MaxSizeInKb, url.Duration*1024/float64(info.Size()), 0)
+			warn += sWarn
+		}
+		if err != nil {
+			for _, part := range parts {
+				_ = os.Remove(part)
+			}
+			async.SetResult(nil, err, warn)
+			return
+		}
+		async.SetResult(parts, nil, warn)
+	}()
+	return &async
+}
@@ -0,0 +1,137 @@
+package vigoler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func Test_splitTimes(t *testing.T) {
+	chapters := []Chapter{{StartTime: 0, EndTime: 100}, {StartTime: 100, EndTime: 400}, {StartTime: 400, EndTime: 600}}
+	tests := []struct {
+		name     string
+		settings SplitSettings
+		sizeInKb float64
+		chapters []Chapter
+		want     []float64
+	}{
+		{"not limited", SplitSettings{}, 1000, chapters, nil},
+		{"by time", SplitSettings{MaxTimeInSec: 250}, 1000, nil, []float64{200, 400}},
+		{"by size", SplitSettings{MaxSizeInKb: 500}, 1000, nil, []float64{200, 400}},
+		{"size fit", SplitSettings{MaxSizeInKb: 2000}, 1000, nil, nil},
+		{"shorter of size and time", SplitSettings{MaxSizeInKb: 800, MaxTimeInSec: 200}, 1000, nil, []float64{200, 400}},
+		{"by chapters", SplitSettings{ByChapters: true}, 1000, chapters, []float64{100, 400}},
+		{"without chapters", SplitSettings{ByChapters: true}, 1000, nil, nil},
+		{"long chapter", SplitSettings{ByChapters: true, MaxTimeInSec: 200}, 1000, chapters, []float64{100, 250, 400}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitTimes(tt.settings, 600, tt.sizeInKb, tt.chapters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitTimes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func Test_partsFileNames(t *testing.T) {
+	want := []string{"dir/100%.1.mp4", "dir/100%.2.mp4"}
+	if got := partsFileNames("dir/100%%.%d.mp4", 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("partsFileNames() = %v, want %v", got, want)
+	}
+}
+func TestVideoUtils_SplitDownloadSinglePart(t *testing.T) {
+	file, err := ioutil.TempFile("", "split*.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(make([]byte, 10*1024))
+	file.Close()
+	defer os.Remove(file.Name())
+	tests := []struct {
+		name     string
+		duration float64
+		wantWarn bool
+	}{
+		{"small file", 100, false},
+		{"unknown duration", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			download := CreateAsyncWaitGroup(&wg, nil)
+			download.SetResult(file.Name(), nil, "")
+			vu := VideoUtils{}
+			result, err, warn := vu.SplitDownload(VideoUrl{Duration: tt.duration}, &download, SplitSettings{MaxSizeInKb: 20}).Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, []string{file.Name()}) {
+				t.Errorf("SplitDownload() = %v, want the downloaded file", result)
+			}
+			if (warn != "") != tt.wantWarn {
+				t.Errorf("SplitDownload() warn = %v, want warn %v", warn, tt.wantWarn)
+			}
+		})
+	}
+}
+func TestVideoUtils_SplitDownloadBigParts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test use shell script as ffmpeg")
+	}
+	dir := t.TempDir()
+	// The fake ffmpeg split the input to equal parts and write one part less when there is more than one cut time, like
+	// two cut times in the same key frame interval.
+	path := filepath.Join(dir, "ffmpeg")
+	script := `#!/bin/sh
+while [ $# -gt 1 ]; do
+  case "$1" in
+    -i) input=$2; shift;;
+    -segment_times) times=$2; shift;;
+    -segment_list) list=$2; shift;;
+  esac
+  shift
+done
+n=$(($(echo "$times" | tr -cd , | wc -c) + 2))
+if [ $n -gt 2 ]; then n=$((n - 1)); fi
+size=$(($(wc -c < "$input") / n))
+: > "$list"
+i=1
+while [ $i -le $n ]; do
+  part=$(printf "$1" $i)
+  head -c $size /dev/zero > "$part"
+  basename "$part" >> "$list"
+  i=$((i + 1))
+done
+`
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "video.mp4")
+	if err := ioutil.WriteFile(input, make([]byte, 100*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	download := CreateAsyncWaitGroup(&wg, nil)
+	download.SetResult(input, nil, "")
+	vu := VideoUtils{Ffmpeg: &FFmpegWrapper{ffmpeg: externalApp{appLocation: path}}}
+	result, err, warn := vu.SplitDownload(VideoUrl{Duration: 100}, &download, SplitSettings{MaxSizeInKb: 40}).Get()
+	if err != nil {
+		t.Fatalf("SplitDownload() error = %v, warn = %s", err, warn)
+	}
+	want := []string{filepath.Join(dir, "video.1.1.mp4"), filepath.Join(dir, "video.1.2.mp4"), filepath.Join(dir, "video.2.1.mp4"),
+		filepath.Join(dir, "video.2.2.mp4")}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("SplitDownload() = %v, want %v", result, want)
+	}
+	for _, part := range want {
+		if info, err := os.Stat(part); err != nil || info.Size() > 40*1024 {
+			t.Errorf("SplitDownload() part %s = %v, %v", part, info, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != len(want)+1 {
+		t.Errorf("SplitDownload() files = %v, want only the parts", files)
+	}
+}
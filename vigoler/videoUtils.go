@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -11,11 +13,24 @@ import (
 )
 
 type VideoUtils struct {
-	Youtube                  *YoutubeDlWrapper
+	Extractor                Extractor
 	Ffmpeg                   *FFmpegWrapper
 	Curl                     *CurlWrapper
 	MinLiveErrorRetryingTime int
-	random                   *rand.Rand
+	// RefreshOnFallback extract the video again before downloading the next format when the download of a format fail.
+	RefreshOnFallback bool
+	// FitToSize re-encode the best formats when every format is bigger than the max size. FitToSizeLowerResolution
+	// lower the resolution of the re-encoded video to match its bitrate.
+	FitToSize                bool
+	FitToSizeLowerResolution bool
+	// Ranking order the formats of the downloads, DefaultFormatRanking is used when it is nil.
+	Ranking *FormatRanking
+	// OutputDir is the directory that the files are downloaded to, the current directory if empty. It should be the
+	// root of the output template so the finished files are moved to their names by rename.
+	OutputDir string
+	// Deprecated: use Extractor. Youtube is used only when Extractor is nil.
+	Youtube *YoutubeDlWrapper
+	random  *rand.Rand
 }
 type LiveVideoCallback func(data interface{}, fileName string, async *Async)
 type TypedError interface {
@@ -42,22 +57,25 @@ func (e *FileTooBigError) Type() string {
 	return "File too big error"
 }
 func (e *FormatNotFoundError) Error() string {
-	return fmt.Sprintf("Format %s not found", e.format.formatID)
+	return fmt.Sprintf("Format %s not found", e.format.FormatID)
 }
 func (e *FormatNotFoundError) LogAttributes() map[string]interface{} {
 	return map[string]interface{}{"warn": e.warn, "videos": e.videos}
 }
+
+// createFileName return a new part file in OutputDir with extension ext, or the extension of format if ext is empty.
+// The extension is kept after the part suffix so ffmpeg detect the container of the file.
 func (vu *VideoUtils) createFileName(ext string, format Format) string {
 	if vu.random == nil {
 		vu.random = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
-	file := strconv.Itoa(vu.random.Int()) + "."
+	file := strconv.Itoa(vu.random.Int()) + partSuffix + "."
 	if ext != "" {
 		file += ext
 	} else {
 		file += format.Ext
 	}
-	return file
+	return filepath.Join(vu.OutputDir, file)
 }
 func (vu *VideoUtils) chooseDownload(url, output, protocol string, headers map[string]string) (*Async, error) {
 	if protocol == "https" {
@@ -65,22 +83,60 @@ func (vu *VideoUtils) chooseDownload(url, output, protocol string, headers map[s
 	}
 	return vu.Ffmpeg.DownloadHeaders(url, headers, output)
 }
+
+// cacheInvalidator is an extractor that cache its results.
+type cacheInvalidator interface {
+	Invalidate(url string)
+}
+
+// extractor return the extractor of vu, Youtube is used when Extractor is not set.
+func (vu *VideoUtils) extractor() Extractor {
+	if vu.Extractor == nil && vu.Youtube != nil {
+		return vu.Youtube
+	}
+	return vu.Extractor
+}
+
+func (vu *VideoUtils) ranking() FormatRanking {
+	if vu.Ranking == nil {
+		return DefaultFormatRanking
+	}
+	return *vu.Ranking
+}
+
+// GetBestFormat return the best format by the ranking of vu.
+func (vu *VideoUtils) GetBestFormat(formats []Format, needVideo, needAudio bool) Format {
+	return vu.ranking().Order(formats, needVideo, needAudio)[0]
+}
+
+// invalidate remove the cached result of the url so the next extraction run the extractor.
+func (vu *VideoUtils) invalidate(url string) {
+	if invalidator, ok := vu.extractor().(cacheInvalidator); ok {
+		invalidator.Invalidate(url)
+	}
+}
 func (vu *VideoUtils) recreateURL(url VideoUrl, format Format) (Format, error) {
 	const retryingTime = 2
 	var lastWarn string
 	var lastVideos []VideoUrl
 	for i := 0; i < retryingTime; i++ {
-		async, err := vu.Youtube.GetUrls(url.url)
+		if i != 0 {
+			vu.invalidate(url.extractorURL())
+		}
+		async, err := vu.extractor().GetUrls(url.extractorURL())
 		if err != nil {
 			return Format{}, err
 		}
-		videos, _, warn := async.Get()
-		lastVideos = videos.([]VideoUrl)
+		videos, err, warn := async.Get()
+		lastVideos, _ = videos.([]VideoUrl)
 		lastWarn = warn
+		if err != nil && len(lastVideos) == 0 {
+			return Format{}, err
+		}
 		for _, video := range lastVideos {
 			if url.ID == video.ID {
 				for _, form := range video.Formats {
-					if form.formatID == format.formatID {
+					if form.FormatID == format.FormatID {
 						return form, nil
 					}
 				}
@@ -93,8 +149,22 @@ func (vu *VideoUtils) recreateURL(url VideoUrl, format Format) (Format, error) {
 		videos: lastVideos,
 	}
 }
+
+// LiveDownload download the live in parts of maxSizeInKb or maxTimeInSec and call liveVideoCallback when every part finish.
+// The result of the async is LiveReport.
+// Hls lives are recorded segment by segment so parts does not overlap and gaps are reported, the split thresholds are
+// used only for other lives where the next part start when the current part pass the threshold.
+// Encrypted hls lives are downloaded by ffmpeg like other lives.
 func (vu *VideoUtils) LiveDownload(log *Logger, url VideoUrl, format Format, ext string, maxSizeInKb, sizeSplitThreshold, maxTimeInSec, timeSplitThreshold int, liveVideoCallback LiveVideoCallback, data interface{}) (*Async, error) {
+	if isHlsProtocol(format.Protocol) {
+		if !isEncryptedHls(format) {
+			return vu.liveDownloadHls(log, url, format, ext, maxSizeInKb, maxTimeInSec, liveVideoCallback, data)
+		}
+		log.liveEncrypted(url)
+	}
 	var wg sync.WaitGroup
+	var report LiveReport
+	var reportMutex sync.Mutex
 	var wa multipleWaitAble
 	var lastErr error
 	lastWarn := ""
@@ -106,6 +176,9 @@ func (vu *VideoUtils) LiveDownload(log *Logger, url VideoUrl, format Format, ext
 		log.startDownloadLive(url, output)
 		_, err, warn := fAsync.Get()
 		wa.remove(fAsync)
+		reportMutex.Lock()
+		report.Parts = append(report.Parts, LivePart{FileName: output})
+		reportMutex.Unlock()
 		if _, isWaitError := err.(*WaitError); isWaitError || err == ServerStopSendDataError {
 			log.finishDownloadLive(url, output, warn, err)
 			err = nil
@@ -151,7 +224,7 @@ func (vu *VideoUtils) LiveDownload(log *Logger, url VideoUrl, format Format, ext
 			log.liveRecreated(url, output)
 			var fAsync *Async
 			curRunIndex := atomic.AddInt32(&runsIndex, 1)
-			fAsync, err = vu.Ffmpeg.DownloadSplit(format.url, setting, output, log.withLiveId(int(curRunIndex)))
+			fAsync, err = vu.Ffmpeg.DownloadSplit(format.URL, setting, output, log.withLiveId(int(curRunIndex)))
 			if err != nil {
 				log.liveDownloadError(url, output, err)
 				if lastErr == nil {
@@ -171,7 +244,7 @@ func (vu *VideoUtils) LiveDownload(log *Logger, url VideoUrl, format Format, ext
 	output := vu.createFileName(ext, format)
 	setting := DownloadSettings{CallbackBeforeSplit: splitCallback, MaxSizeInKb: maxSizeInKb, MaxTimeInSec: maxTimeInSec, SizeSplitThreshold: sizeSplitThreshold, TimeSplitThreshold: timeSplitThreshold, returnWaitError: true}
 	curRunIndex := atomic.AddInt32(&runsIndex, 1)
-	fAsync, err := vu.Ffmpeg.DownloadSplit(format.url, setting, output, log.withLiveId(int(curRunIndex)))
+	fAsync, err := vu.Ffmpeg.DownloadSplit(format.URL, setting, output, log.withLiveId(int(curRunIndex)))
 	if err != nil {
 		return nil, err
 	}
@@ -182,17 +255,12 @@ func (vu *VideoUtils) LiveDownload(log *Logger, url VideoUrl, format Format, ext
 	go func() {
 		waitForVideoToDownload(fAsync, output, time.Time{}, setting)
 		wg.Wait()
-		async.SetResult(nil, lastErr, lastWarn)
+		async.SetResult(&report, lastErr, lastWarn)
 		wga.Done()
 	}()
 	return &async, nil
 }
-func (vu *VideoUtils) DownloadLiveUntilNow(url VideoUrl, format Format, ext string) (*Async, error) {
-	output := vu.createFileName(ext, format)
-	as, err := vu.Ffmpeg.DownloadLiveUntilNow(format.url, output)
-	if err != nil {
-		return nil, err
-	}
+func (vu *VideoUtils) outputAsync(output string, as *Async) *Async {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	async := CreateAsyncFromAsyncAsWaitAble(&wg, as)
@@ -201,197 +269,444 @@ func (vu *VideoUtils) DownloadLiveUntilNow(url VideoUrl, format Format, ext stri
 		_, err, warn := as.Get()
 		async.SetResult(output, err, warn)
 	}()
-	return &async, nil
+	return &async
 }
-func (vu *VideoUtils) downloadFormat(format Format, ext string) (*Async, error) {
+func (vu *VideoUtils) DownloadLiveUntilNow(url VideoUrl, format Format, ext string) (*Async, error) {
 	output := vu.createFileName(ext, format)
-	dAsync, err := vu.chooseDownload(format.url, output, format.protocol, format.httpHeaders)
+	as, err := vu.Ffmpeg.DownloadLiveUntilNowFormat(format, output)
 	if err != nil {
 		return nil, err
 	}
-	var wg sync.WaitGroup
-	wg.Add(1)
-	async := CreateAsyncFromAsyncAsWaitAble(&wg, dAsync)
-	go func() {
-		defer wg.Done()
-		_, err, warn := dAsync.Get()
-		async.SetResult(output, err, warn)
-	}()
-	return &async, nil
+	return vu.outputAsync(output, as), nil
+}
+
+// DownloadLiveWindow download the last windowInSec seconds of the live that is still available on the server.
+func (vu *VideoUtils) DownloadLiveWindow(url VideoUrl, format Format, ext string, windowInSec int) (*Async, error) {
+	output := vu.createFileName(ext, format)
+	as, err := vu.Ffmpeg.DownloadLiveWindow(format, output, windowInSec)
+	if err != nil {
+		return nil, err
+	}
+	return vu.outputAsync(output, as), nil
+}
+
+// downloadFormat download the format of url. Format whose url is about to expire is refreshed before the download
+// and the download continue with refreshed url if the url expire during the download.
+func (vu *VideoUtils) downloadFormat(url VideoUrl, format Format, ext string) (*Async, error) {
+	output := vu.createFileName(ext, format)
+	if formatExpired(format, time.Now()) {
+		refreshed, err := vu.refreshFormat(url, format)
+		if err != nil {
+			return nil, err
+		}
+		format = refreshed
+	}
+	dAsync, err := vu.chooseDownload(format.URL, output, format.Protocol, format.HTTPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	return vu.refreshingDownload(url, format, output, dAsync), nil
 }
 func formatLess(a, b *Format) bool {
-	return a.width < b.width || (a.width == b.width && a.height < b.height)
+	return a.Width < b.Width || (a.Width == b.Width && a.Height < b.Height)
 }
 func (vu *VideoUtils) needToDownloadBestFormat(bestVideoFormats, bestAudioFormats, bestFormats []Format, mergeOnlyIfHigherResolution bool) bool {
 	return (len(bestVideoFormats) == 0 || len(bestAudioFormats) == 0) || (mergeOnlyIfHigherResolution && len(bestFormats) > 0 && formatLess(&bestVideoFormats[0], &bestFormats[0]))
 }
+
+// trackLanguage return the language of the track if all the formats that can be chosen for it share the same language.
+func trackLanguage(formats []Format) string {
+	for _, format := range formats {
+		if format.Language != formats[0].Language {
+			return ""
+		}
+	}
+	return formats[0].Language
+}
 func (vu *VideoUtils) DownloadBestAndMerge(url VideoUrl, maxSizeInKb int, ext string, mergeOnlyIfHigherResolution bool) (*Async, error) {
-	bestVideoFormats := GetFormatsOrder(url.Formats, true, false)
-	bestAudioFormats := GetFormatsOrder(url.Formats, false, true)
-	bestFormats := GetFormatsOrder(url.Formats, true, true)
+	return vu.DownloadBestAndMergeLanguages(url, maxSizeInKb, ext, mergeOnlyIfHigherResolution, nil, false)
+}
+
+// DownloadBestAndMergeLanguages download the best video and audio and merge them to one file.
+// languages is the preferred audio languages ordered from the most wanted to the least.
+// If muxLanguages is true the best audio of every language in languages is merged as a separate audio track.
+func (vu *VideoUtils) DownloadBestAndMergeLanguages(url VideoUrl, maxSizeInKb int, ext string, mergeOnlyIfHigherResolution bool, languages []string, muxLanguages bool) (*Async, error) {
+	async, err := vu.downloadBestAndMergeLanguages(url, maxSizeInKb, ext, mergeOnlyIfHigherResolution, languages, muxLanguages)
+	return vu.fitToSizeOnTooBig(url, maxSizeInKb, ext, async, err, func() (*Async, error) {
+		return vu.DownloadFitToSize(url, maxSizeInKb, ext)
+	})
+}
+func (vu *VideoUtils) downloadBestAndMergeLanguages(url VideoUrl, maxSizeInKb int, ext string, mergeOnlyIfHigherResolution bool, languages []string, muxLanguages bool) (*Async, error) {
+	bestVideoFormats := vu.ranking().Order(url.Formats, true, false)
+	bestAudioFormats := vu.ranking().OrderLanguages(url.Formats, false, true, languages)
+	bestFormats := vu.ranking().OrderLanguages(url.Formats, true, true, languages)
 	if vu.needToDownloadBestFormat(bestVideoFormats, bestAudioFormats, bestFormats, mergeOnlyIfHigherResolution) {
 		return vu.downloadBestMaxSize(url, maxSizeInKb, ext, bestFormats)
 	}
-	var video, audio *Async
-	var vErr, aErr error
-	if maxSizeInKb == -1 {
-		video, vErr = vu.downloadFormat(bestVideoFormats[0], ext)
-		audio, aErr = vu.downloadFormat(bestAudioFormats[0], ext)
-	} else {
-		video, vErr = vu.downloadBestMaxSize(url, maxSizeInKb, ext, bestVideoFormats)
-		audio, aErr = vu.downloadBestMaxSize(url, maxSizeInKb, ext, bestAudioFormats)
-	}
-	if vErr != nil || aErr != nil {
-		if vErr != nil {
-			return nil, vErr
+	audioTracks := [][]Format{bestAudioFormats}
+	if muxLanguages {
+		if languagesTracks := vu.ranking().AudioByLanguages(url.Formats, languages); len(languagesTracks) > 1 {
+			audioTracks = languagesTracks
 		}
-		return nil, aErr
+	}
+	return vu.downloadAndMerge(url, maxSizeInKb, ext, bestVideoFormats, audioTracks)
+}
 
+// downloadAndMerge download the video track and every audio track and merge them to one file. When maxSizeInKb is not
+// -1 the formats are chosen so the merged file fit in it.
+func (vu *VideoUtils) downloadAndMerge(url VideoUrl, maxSizeInKb int, ext string, videoFormats []Format, audioTracks [][]Format) (*Async, error) {
+	languages := make([]string, 0, len(audioTracks))
+	for _, track := range audioTracks {
+		languages = append(languages, trackLanguage(track))
 	}
+	if maxSizeInKb != -1 {
+		return vu.downloadAndMergeMaxSize(url, maxSizeInKb, ext, videoFormats, audioTracks, languages)
+	}
+	return vu.mergeFormats(url, ext, videoFormats, audioTracks, languages)
+}
+
+// mergeFormats download the video track and the audio tracks and merge them to one file. Every track is downloaded
+// from the first of its formats that does not fail.
+func (vu *VideoUtils) mergeFormats(url VideoUrl, ext string, videoFormats []Format, audioTracks [][]Format, languages []string) (*Async, error) {
 	var wg sync.WaitGroup
 	var wa multipleWaitAble
-	async := CreateAsyncWaitGroup(&wg, &wa)
+	video, err := vu.downloadFormats(url, videoFormats, ext)
+	if err != nil {
+		return nil, err
+	}
 	wa.add(video)
-	wa.add(audio)
+	audios := make([]*Async, 0, len(audioTracks))
+	for _, formats := range audioTracks {
+		audio, err := vu.downloadFormats(url, formats, ext)
+		if err != nil {
+			_ = wa.Stop()
+			return nil, err
+		}
+		wa.add(audio)
+		audios = append(audios, audio)
+	}
+	async := CreateAsyncWaitGroup(&wg, &wa)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		wasErr := false
+		var err error
 		tWarn := ""
-		audioPath, err, aWarn := audio.Get()
-		tWarn += aWarn
+		paths := make([]string, 0, len(audios)+1)
+		for _, as := range append([]*Async{video}, audios...) {
+			path, asErr, warn := as.Get()
+			tWarn += warn
+			if err == nil && asErr != nil {
+				err = asErr
+			}
+			wa.remove(as)
+			if path != nil {
+				paths = append(paths, path.(string))
+			}
+		}
+		defer func() {
+			for _, path := range paths {
+				_ = os.Remove(path)
+			}
+		}()
 		if err != nil {
 			async.SetResult(nil, err, tWarn)
-			wasErr = true
+			return
 		}
-		wa.remove(audio)
-		videoPath, err, vWarn := video.Get()
-		tWarn += vWarn
-		if !wasErr && err != nil {
-			async.SetResult(nil, err, tWarn)
-			wasErr = true
+		tracks := make([]AudioTrack, 0, len(audios))
+		for i, path := range paths[1:] {
+			tracks = append(tracks, AudioTrack{Path: path, Language: languages[i]})
 		}
-		wa.remove(video)
-		audioPathStr := audioPath.(string)
-		videoPathStr := videoPath.(string)
-		defer func() {
-			_ = os.Remove(audioPathStr)
-			_ = os.Remove(videoPathStr)
-		}()
-		if !wasErr {
-			output := vu.createFileName(ext, bestVideoFormats[0])
-			merge, err := vu.Ffmpeg.Merge(output, videoPathStr, audioPathStr)
+		output := vu.createFileName(ext, videoFormats[0])
+		merge, err := vu.Ffmpeg.MergeTracks(output, paths[0], tracks)
+		if err != nil {
+			async.SetResult(nil, err, tWarn)
+		} else {
+			wa.add(merge)
+			_, err, warn := merge.Get()
+			wa.remove(merge)
+			tWarn += warn
 			if err != nil {
-				async.SetResult(nil, err, tWarn)
-			} else {
-				wa.add(merge)
-				_, err, warn := merge.Get()
-				wa.remove(merge)
-				tWarn += warn
-				if err != nil {
-					_ = os.Remove(output)
-				}
-				async.SetResult(output, err, tWarn)
+				_ = os.Remove(output)
 			}
+			async.SetResult(output, err, tWarn)
 		}
 	}()
 	return &async, nil
 }
-func (vu *VideoUtils) getBestFormatSize(async *Async, formats []Format, sizeInKBytes int) (*Format, string, error) {
+
+// DownloadSelector download the formats that selector choose and merge them if more than one format was chosen.
+// When maxSizeInKb is not -1 the most preferred formats of the selector that fit in it together are downloaded.
+func (vu *VideoUtils) DownloadSelector(url VideoUrl, selector *FormatSelector, maxSizeInKb int, ext string) (*Async, error) {
+	tracks, err := selector.Candidates(url.Formats, url.Duration, vu.ranking())
+	if err != nil {
+		return nil, err
+	}
+	async, err := vu.downloadTracks(url, maxSizeInKb, ext, tracks)
+	return vu.fitToSizeOnTooBig(url, maxSizeInKb, ext, async, err, func() (*Async, error) {
+		return vu.fitTracksToSize(url, maxSizeInKb, ext, tracks, TranscodeSettings{})
+	})
+}
+
+// downloadTracks download the first format of every track that does not fail and merge them if there is more than one
+// track. When maxSizeInKb is not -1 the formats are chosen so the output fit in it.
+func (vu *VideoUtils) downloadTracks(url VideoUrl, maxSizeInKb int, ext string, tracks [][]Format) (*Async, error) {
+	if len(tracks) == 1 {
+		return vu.downloadBestMaxSize(url, maxSizeInKb, ext, tracks[0])
+	}
+	return vu.downloadAndMerge(url, maxSizeInKb, ext, tracks[0], tracks[1:])
+}
+
+func (vu *VideoUtils) downloadSelection(url VideoUrl, selection FormatSelection, ext string) (*Async, error) {
+	if !selection.NeedMerge() {
+		return vu.downloadFormat(url, selection.Formats[0], ext)
+	}
+	audioTracks := make([][]Format, 0, len(selection.Formats)-1)
+	for _, format := range selection.Formats[1:] {
+		audioTracks = append(audioTracks, []Format{format})
+	}
+	return vu.downloadAndMerge(url, -1, ext, selection.Formats[0:1], audioTracks)
+}
+
+// DownloadProfile download the best formats that match the profile. If no format match the profile the best formats
+// are transcoded to match it. When maxSizeInKb is not -1 the output fit in it or FileTooBigError is returned.
+func (vu *VideoUtils) DownloadProfile(url VideoUrl, profile CompatibilityProfile, maxSizeInKb int) (*Async, error) {
+	tracks, settings, err := profile.Candidates(url.Formats, vu.ranking())
+	if err != nil {
+		return nil, err
+	}
+	limits := TranscodeSettings{VideoEncoder: profile.VideoEncoder, AudioEncoder: profile.AudioEncoder,
+		MaxHeight: int(profile.MaxHeight), MaxFPS: profile.MaxFPS, MaxVideoBitrate: int(profile.MaxBitrate)}
+	if settings == nil {
+		async, err := vu.downloadTracks(url, maxSizeInKb, profile.Ext, tracks)
+		return vu.fitToSizeOnTooBig(url, maxSizeInKb, profile.Ext, async, err, func() (*Async, error) {
+			return vu.fitTracksToSize(url, maxSizeInKb, profile.Ext, tracks, limits)
+		})
+	}
+	if maxSizeInKb != -1 {
+		// The size of the transcoded output is known only after the transcode so it is encoded to size.
+		return vu.fitTracksToSize(url, maxSizeInKb, profile.Ext, tracks, limits)
+	}
+	selection := firstFormats(tracks)
+	download, err := vu.downloadSelection(url, selection, profile.Ext)
+	if err != nil {
+		return nil, err
+	}
+	warn := fmt.Sprintf("No format match profile %s, transcoding %v.\n", profile.Name, selection.Formats)
+	return vu.transcodeDownload(download, profile.Ext, *settings, warn), nil
+}
+
+// transcodeDownload transcode the output of download when it finish and remove the downloaded file.
+func (vu *VideoUtils) transcodeDownload(download *Async, ext string, settings TranscodeSettings, warn string) *Async {
+	var wg sync.WaitGroup
+	var wa multipleWaitAble
+	wa.add(download)
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		input, err, dWarn := download.Get()
+		wa.remove(download)
+		warn += dWarn
+		if err != nil {
+			async.SetResult(nil, err, warn)
+			return
+		}
+		defer os.Remove(input.(string))
+		output, tWarn, err := vu.transcodeFile(&wa, input.(string), ext, settings)
+		async.SetResult(output, err, warn+tWarn)
+	}()
+	return &async
+}
+
+// transcodeFile encode input to new file with ext and return its name. The transcode is in wa while it is running.
+func (vu *VideoUtils) transcodeFile(wa *multipleWaitAble, input, ext string, settings TranscodeSettings) (string, string, error) {
+	if ext == "" {
+		ext = "mp4"
+	}
+	output := vu.createFileName(ext, Format{})
+	transcode, err := vu.Ffmpeg.Transcode(input, output, settings)
+	if err != nil {
+		return "", "", err
+	}
+	wa.add(transcode)
+	_, err, warn := transcode.Get()
+	wa.remove(transcode)
+	if err != nil {
+		_ = os.Remove(output)
+	}
+	return output, warn, err
+}
+
+// getBestFormatSize return the first format that fit in sizeInKBytes. Formats are requested only when their estimated
+// size is not certain to fit.
+func (vu *VideoUtils) getBestFormatSize(async *Async, url VideoUrl, formats []Format, sizeInKBytes int) (*Format, string, error) {
 	for _, format := range formats {
-		if !async.isStopped {
-			as, err := vu.Ffmpeg.GetInputSizeHeaders(format.url, format.httpHeaders)
-			if err != nil {
-				return nil, "", err
-			}
-			size, err, warn := as.Get()
-			if err != nil {
+		estimate := EstimateFormatSize(format, url.Duration)
+		if !estimate.Fits(float64(sizeInKBytes)) && estimate.Confidence != SizeExact {
+			var warn string
+			var err error
+			if estimate, warn, err = vu.probeFormatSize(async, format); err != nil {
 				return nil, warn, err
 			}
-			if size.(int) < sizeInKBytes {
-				return &format, "", nil
-			}
-		} else {
-			return nil, "", &CancelError{}
+		}
+		if estimate.Confidence == SizeUnknown || estimate.SizeInKb < float64(sizeInKBytes) {
+			return &format, "", nil
 		}
 	}
 	return nil, "", nil
 }
-func (vu *VideoUtils) findBestFormat(url VideoUrl, sizeInKBytes int, formats []Format, ext string) (*Async, error) {
+
+// findBestFormat download the first format in formats that fit in sizeInKBytes. The formats in fallbacks after it that
+// surely fit are downloaded if its download fail.
+func (vu *VideoUtils) findBestFormat(url VideoUrl, sizeInKBytes int, formats, fallbacks []Format, ext string) (*Async, error) {
 	var wg sync.WaitGroup
 	async := CreateAsyncWaitGroup(&wg, nil)
 	wg.Add(1)
 	go func(async *Async, wg *sync.WaitGroup) {
 		defer wg.Done()
-		format, warn, err := vu.getBestFormatSize(async, formats, sizeInKBytes)
+		format, warn, err := vu.getBestFormatSize(async, url, formats, sizeInKBytes)
 		if err != nil {
 			async.SetResult(nil, err, warn)
 		} else {
 			if format == nil {
 				async.SetResult(nil, &FileTooBigError{url: url}, warn)
 			} else {
-				output := vu.createFileName(ext, *format)
-				as, err := vu.chooseDownload(format.url, output, format.protocol, format.httpHeaders)
+				as, err := vu.downloadFormats(url, sizeFallbacks(url, *format, fallbacks, sizeInKBytes), ext)
 				if err != nil {
 					async.SetResult(nil, err, "")
 				} else {
-					_, err, warn := as.Get()
-					async.SetResult(output, err, warn)
+					async.SetResult(as.Get())
 				}
 			}
 		}
 	}(&async, &wg)
 	return &async, nil
 }
-func (vu *VideoUtils) downloadBestFormats(url VideoUrl, ext string, formats []Format, sizeInKBytes int) (*Async, error) {
-	var async *Async
-	var err error
-	if sizeInKBytes == -1 {
-		async, err = vu.downloadFormat(formats[0], ext)
-	} else {
-		async, err = vu.findBestFormat(url, sizeInKBytes, formats, ext)
-	}
-	return async, err
-}
 func (vu *VideoUtils) DownloadBest(url VideoUrl, ext string) (*Async, error) {
-	return vu.downloadBestFormats(url, ext, GetFormatsOrder(url.Formats, true, true)[0:1], -1)
+	return vu.downloadFormats(url, vu.ranking().Order(url.Formats, true, true), ext)
 }
+
+// reduceFormats return the formats that can be the best format that fit in sizeInKBytes by their size estimates. The
+// formats are ordered from the best so formats before format that is too big are removed too. The last format is the
+// first format that surely fit.
 func reduceFormats(url VideoUrl, formats []Format, sizeInKBytes int) ([]Format, error) {
 	if sizeInKBytes == -1 {
 		return formats[0:1], nil
 	}
-	var fIndex = -1
-	var lastKnownIndex = -1
-	for i, f := range formats {
-		if f.fileSize == -1 {
-			lastKnownIndex = i
-		} else {
-			if (int)(f.fileSize) < sizeInKBytes {
-				fIndex = i
-				break
-			}
-			lastKnownIndex = -1
+	var candidates []Format
+	for _, f := range formats {
+		estimate := EstimateFormatSize(f, url.Duration)
+		if estimate.TooBig(float64(sizeInKBytes)) {
+			candidates = nil
+			continue
 		}
-	}
-	if fIndex == -1 {
-		if lastKnownIndex == -1 {
-			return nil, &FileTooBigError{url: url}
+		candidates = append(candidates, f)
+		if estimate.Fits(float64(sizeInKBytes)) {
+			break
 		}
-		lastKnownIndex = 0
-		fIndex = len(formats) - 1
 	}
-	if lastKnownIndex == -1 {
-		lastKnownIndex = fIndex
+	if len(candidates) == 0 {
+		return nil, &FileTooBigError{url: url}
 	}
-	return formats[lastKnownIndex : fIndex+1], nil
+	return candidates, nil
 }
 func (vu *VideoUtils) downloadBestMaxSize(url VideoUrl, sizeInKBytes int, ext string, formats []Format) (*Async, error) {
+	if sizeInKBytes == -1 {
+		return vu.downloadFormats(url, formats, ext)
+	}
 	rFormats, err := reduceFormats(url, formats, sizeInKBytes)
 	if err != nil {
 		return nil, err
 	}
-	return vu.downloadBestFormats(url, ext, rFormats, sizeInKBytes)
+	return vu.findBestFormat(url, sizeInKBytes, rFormats, formats, ext)
 }
 func (vu *VideoUtils) DownloadBestMaxSize(url VideoUrl, sizeInKBytes int, ext string) (*Async, error) {
-	return vu.downloadBestMaxSize(url, sizeInKBytes, ext, GetFormatsOrder(url.Formats, true, true))
+	async, err := vu.downloadBestMaxSize(url, sizeInKBytes, ext, vu.ranking().Order(url.Formats, true, true))
+	return vu.fitToSizeOnTooBig(url, sizeInKBytes, ext, async, err, func() (*Async, error) {
+		return vu.DownloadFitToSize(url, sizeInKBytes, ext)
+	})
+}
+
+type waitForLiveWaitAble struct {
+	wg       *sync.WaitGroup
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func (wa *waitForLiveWaitAble) Wait() error {
+	wa.wg.Wait()
+	return nil
+}
+func (wa *waitForLiveWaitAble) Stop() error {
+	wa.stopOnce.Do(func() {
+		close(wa.stopChan)
+	})
+	return nil
+}
+
+// nextLivePoll return how much time to wait before checking again if the live started and the next backoff delay.
+// Until the scheduled release time the poll wait for the release time, after that the delay grow up to maxPoll.
+func nextLivePoll(video *VideoUrl, delay, minPoll, maxPoll time.Duration) (time.Duration, time.Duration) {
+	if video != nil && !video.ReleaseTime.IsZero() {
+		if untilRelease := time.Until(video.ReleaseTime); untilRelease > 0 {
+			if untilRelease < minPoll {
+				untilRelease = minPoll
+			} else if untilRelease > maxPoll {
+				untilRelease = maxPoll
+			}
+			return untilRelease, minPoll
+		}
+	}
+	nextDelay := delay * 2
+	if nextDelay > maxPoll {
+		nextDelay = maxPoll
+	}
+	return delay, nextDelay
+}
+
+// WaitForLive wait until the scheduled live or premiere in url start.
+// The result of the async is the VideoUrl of the live once it has formats to download.
+// If the video is not an upcoming live the result is the video as is.
+func (vu *VideoUtils) WaitForLive(url string, minPollInSec, maxPollInSec int) (*Async, error) {
+	if minPollInSec <= 0 || maxPollInSec < minPollInSec {
+		return nil, &ArgumentError{stackTrack: debug.Stack(), argName: "minPollInSec", argValue: minPollInSec}
+	}
+	minPoll, maxPoll := time.Duration(minPollInSec)*time.Second, time.Duration(maxPollInSec)*time.Second
+	var wg sync.WaitGroup
+	wa := waitForLiveWaitAble{wg: &wg, stopChan: make(chan struct{})}
+	async := CreateAsyncWaitGroup(&wg, &wa)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		delay := minPoll
+		for {
+			var video *VideoUrl
+			urlsAsync, err := vu.extractor().GetUrls(url)
+			if err != nil {
+				async.SetResult(nil, err, "")
+				return
+			}
+			videos, err, warn := urlsAsync.Get()
+			if urls := videos.([]VideoUrl); len(urls) != 0 {
+				video = &urls[0]
+			}
+			if video != nil && !video.IsUpcoming {
+				async.SetResult(*video, nil, warn)
+				return
+			}
+			if _, isNotStarted := err.(*LiveNotStartedError); video == nil && !isNotStarted {
+				async.SetResult(nil, err, warn)
+				return
+			}
+			var wait time.Duration
+			wait, delay = nextLivePoll(video, delay, minPoll, maxPoll)
+			select {
+			case <-wa.stopChan:
+				async.SetResult(nil, &CancelError{}, warn)
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	return &async, nil
 }
@@ -1,8 +1,11 @@
 package vigoler
 
 import (
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_reduceFormats(t *testing.T) {
@@ -12,16 +15,24 @@ func Test_reduceFormats(t *testing.T) {
 		sizeInKBytes int
 	}
 	formats := []Format{
-		{formatID: "1", fileSize: 10000},
-		{formatID: "2", fileSize: 1000},
-		{formatID: "7", fileSize: -1},
-		{formatID: "3", fileSize: 100},
-		{formatID: "6", fileSize: -1},
-		{formatID: "8", fileSize: -1},
-		{formatID: "4", fileSize: 10},
-		{formatID: "5", fileSize: 1},
+		{FormatID: "1", FileSize: 10000},
+		{FormatID: "2", FileSize: 1000},
+		{FormatID: "7", FileSize: -1},
+		{FormatID: "3", FileSize: 100},
+		{FormatID: "6", FileSize: -1},
+		{FormatID: "8", FileSize: -1},
+		{FormatID: "4", FileSize: 10},
+		{FormatID: "5", FileSize: 1},
 	}
 	video := VideoUrl{Name: "file"}
+	estimated := []Format{
+		{FormatID: "1", FileSize: -1, FileSizeApprox: 5000},
+		{FormatID: "2", FileSize: -1, TBR: 8192},
+		{FormatID: "3", FileSize: -1, FileSizeApprox: 460},
+		{FormatID: "4", FileSize: -1, TBR: 100},
+		{FormatID: "5", FileSize: 20},
+	}
+	longVideo := VideoUrl{Name: "file", Duration: 1}
 	tests := []struct {
 		name    string
 		args    args
@@ -32,7 +43,10 @@ func Test_reduceFormats(t *testing.T) {
 		{"max bigger", args{url: video, sizeInKBytes: 9999999999, formats: formats}, formats[0:1], false},
 		{"max smaller", args{url: video, sizeInKBytes: 0, formats: formats}, nil, true},
 		{"unknown size 1", args{url: video, sizeInKBytes: 500, formats: formats}, formats[2:4], false},
-		{"unknown size 2", args{url: video, sizeInKBytes: 50, formats: formats}, formats[5:7], false},
+		{"unknown size 2", args{url: video, sizeInKBytes: 50, formats: formats}, formats[4:7], false},
+		{"approximate size", args{url: longVideo, sizeInKBytes: 500, formats: estimated}, estimated[2:4], false},
+		{"bitrate size", args{url: longVideo, sizeInKBytes: 1100, formats: estimated}, estimated[1:3], false},
+		{"estimated too big", args{url: longVideo, sizeInKBytes: 10, formats: estimated}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -56,12 +70,12 @@ func TestVideoUtils_needToDownloadBestFormat(t *testing.T) {
 		mergeOnlyIfHigherResolution bool
 	}
 	bestFormat := []Format{{
-		height: 960,
-		width:  1080,
+		Height: 960,
+		Width:  1080,
 	}}
 	worstFormat := []Format{{
-		height: 640,
-		width:  860,
+		Height: 640,
+		Width:  860,
 	}}
 	bestAudioFormats := []Format{{}}
 	tests := []struct {
@@ -177,3 +191,51 @@ func TestVideoUtils_needToDownloadBestFormat(t *testing.T) {
 		})
 	}
 }
+
+func Test_nextLivePoll(t *testing.T) {
+	const minPoll, maxPoll = time.Minute, 10 * time.Minute
+	tests := []struct {
+		name      string
+		video     *VideoUrl
+		delay     time.Duration
+		wantWait  time.Duration
+		wantDelay time.Duration
+	}{
+		{"no video", nil, minPoll, minPoll, 2 * minPoll},
+		{"unknown release", &VideoUrl{}, 4 * minPoll, 4 * minPoll, 8 * minPoll},
+		{"max delay", &VideoUrl{}, 8 * minPoll, 8 * minPoll, maxPoll},
+		{"release passed", &VideoUrl{ReleaseTime: time.Now().Add(-time.Hour)}, 2 * minPoll, 2 * minPoll, 4 * minPoll},
+		{"release soon", &VideoUrl{ReleaseTime: time.Now().Add(time.Second)}, 8 * minPoll, minPoll, minPoll},
+		{"release far", &VideoUrl{ReleaseTime: time.Now().Add(time.Hour)}, 8 * minPoll, maxPoll, minPoll},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, delay := nextLivePoll(tt.video, tt.delay, minPoll, maxPoll)
+			if wait != tt.wantWait || delay != tt.wantDelay {
+				t.Errorf("nextLivePoll() = %v, %v, want %v, %v", wait, delay, tt.wantWait, tt.wantDelay)
+			}
+		})
+	}
+}
+func TestVideoUtils_createFileName(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputDir string
+		ext       string
+		format    Format
+		wantExt   string
+	}{
+		{"current directory", "", "mp4", Format{Ext: "webm"}, ".mp4"},
+		{"output directory", filepath.Join("out", "videos"), "", Format{Ext: "webm"}, ".webm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vu := VideoUtils{OutputDir: tt.outputDir}
+			got := vu.createFileName(tt.ext, tt.format)
+			if filepath.Dir(got) != filepath.Clean(tt.outputDir) || filepath.Ext(got) != tt.wantExt ||
+				!strings.HasSuffix(strings.TrimSuffix(got, tt.wantExt), partSuffix) {
+				t.Errorf("createFileName() = %s", got)
+			}
+		})
+	}
+}
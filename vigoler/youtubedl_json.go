@@ -0,0 +1,286 @@
+package vigoler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	str "strings"
+	"time"
+)
+
+// optionalNumber is json number that can be missing, null or of other type. Valid is true only for numbers.
+type optionalNumber struct {
+	Value float64
+	Valid bool
+}
+
+// youtubeDlFormat is a single format in the json output of youtube-dl. Every field is optional.
+type youtubeDlFormat struct {
+	URL            string            `json:"url"`
+	FormatID       string            `json:"format_id"`
+	Ext            string            `json:"ext"`
+	FileSize       optionalNumber    `json:"filesize"`
+	FileSizeApprox optionalNumber    `json:"filesize_approx"`
+	VCodec         string            `json:"vcodec"`
+	ACodec         string            `json:"acodec"`
+	Width          optionalNumber    `json:"width"`
+	Height         optionalNumber    `json:"height"`
+	Protocol       string            `json:"protocol"`
+	Language       string            `json:"language"`
+	HTTPHeaders    map[string]string `json:"http_headers"`
+	FPS            optionalNumber    `json:"fps"`
+	TBR            optionalNumber    `json:"tbr"`
+	ABR            optionalNumber    `json:"abr"`
+	VBR            optionalNumber    `json:"vbr"`
+	FormatNote     string            `json:"format_note"`
+	DynamicRange   string            `json:"dynamic_range"`
+}
+type youtubeDlChapter struct {
+	StartTime optionalNumber `json:"start_time"`
+	EndTime   optionalNumber `json:"end_time"`
+	Title     string         `json:"title"`
+}
+type youtubeDlThumbnail struct {
+	URL    string         `json:"url"`
+	ID     string         `json:"id"`
+	Width  optionalNumber `json:"width"`
+	Height optionalNumber `json:"height"`
+}
+
+// youtubeDlVideo is a single video in the json output of youtube-dl. Video without formats has its only format fields
+// in the video itself.
+type youtubeDlVideo struct {
+	youtubeDlFormat
+	ID               string            `json:"id"`
+	Title            string            `json:"title"`
+	FullTitle        string            `json:"fulltitle"`
+	WebPageURL       string            `json:"webpage_url"`
+	IsLive           bool              `json:"is_live"`
+	LiveStatus       string            `json:"live_status"`
+	ReleaseTimestamp optionalNumber    `json:"release_timestamp"`
+	Formats          []json.RawMessage `json:"formats"`
+	Duration         optionalNumber    `json:"duration"`
+	Uploader         string            `json:"uploader"`
+	Channel          string            `json:"channel"`
+	UploadDate       string            `json:"upload_date"`
+	Description      string            `json:"description"`
+	ViewCount        optionalNumber    `json:"view_count"`
+	Thumbnails       []json.RawMessage `json:"thumbnails"`
+	Tags             []string          `json:"tags"`
+	// EntryType is "url" for playlist entries that were not resolved.
+	EntryType     string             `json:"_type"`
+	PlaylistIndex optionalNumber     `json:"playlist_index"`
+	PlaylistTitle string             `json:"playlist_title"`
+	Playlist      string             `json:"playlist"`
+	ExtractorKey  string             `json:"extractor_key"`
+	IEKey         string             `json:"ie_key"`
+	Chapters      []youtubeDlChapter `json:"chapters"`
+	// warnings are the problems in the json that did not prevent reading the video.
+	warnings []string
+}
+
+// MalformedJSONError is returned when the output of the extractor can not be read as video.
+type MalformedJSONError struct {
+	Message string
+}
+
+func (e *MalformedJSONError) Error() string {
+	return fmt.Sprintf("Malformed extractor json: %s", e.Message)
+}
+func (e *MalformedJSONError) Type() string {
+	return "Malformed json error"
+}
+
+func (n *optionalNumber) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.Value); err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(n.Value)}
+	}
+	n.Valid = true
+	return nil
+}
+
+// unmarshalObject unmarshal json object into v. Fields with unexpected types are skipped and returned as warning.
+func unmarshalObject(data []byte, v interface{}) (string, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		return "", &MalformedJSONError{Message: fmt.Sprintf("%q is not an object", data)}
+	}
+	err := json.Unmarshal(data, v)
+	if _, isTypeError := err.(*json.UnmarshalTypeError); isTypeError {
+		return err.Error(), nil
+	}
+	if err != nil {
+		return "", &MalformedJSONError{Message: err.Error()}
+	}
+	return "", nil
+}
+
+// parseYoutubeDlVideo parse single line of youtube-dl output. Fields with unexpected types are ignored and reported in
+// the video warnings, video without id is an error.
+func parseYoutubeDlVideo(data []byte) (*youtubeDlVideo, error) {
+	var video youtubeDlVideo
+	warn, err := unmarshalObject(data, &video)
+	if err != nil {
+		return nil, err
+	}
+	if warn != "" {
+		video.warnings = append(video.warnings, warn)
+	}
+	if video.ID == "" {
+		return nil, &MalformedJSONError{Message: "video without id"}
+	}
+	return &video, nil
+}
+func createURL(urlStr string) string {
+	newURL, _ := url.Parse(urlStr)
+	return newURL.String()
+}
+func (f *youtubeDlFormat) toFormat() (Format, error) {
+	if f.URL == "" {
+		return Format{}, &MalformedJSONError{Message: fmt.Sprintf("format %s without url", f.FormatID)}
+	}
+	format := Format{URL: createURL(f.URL), FormatID: f.FormatID, Ext: f.Ext, FileSize: -1, Width: -1, Height: -1,
+		HasVideo: f.VCodec != "none", HasAudio: f.ACodec != "none", Protocol: f.Protocol, Language: f.Language,
+		HTTPHeaders: f.HTTPHeaders, FPS: f.FPS.Value, TBR: f.TBR.Value, ABR: f.ABR.Value, VBR: f.VBR.Value,
+		FormatNote: f.FormatNote, DynamicRange: f.DynamicRange}
+	if f.VCodec != "none" {
+		format.VCodec = f.VCodec
+	}
+	if f.ACodec != "none" {
+		format.ACodec = f.ACodec
+	}
+	if f.FileSize.Valid {
+		format.FileSize = f.FileSize.Value / 1024
+	}
+	if f.FileSizeApprox.Valid {
+		format.FileSizeApprox = f.FileSizeApprox.Value / 1024
+	}
+	if f.Width.Valid && f.Height.Valid {
+		format.Width = f.Width.Value
+		format.Height = f.Height.Value
+	}
+	if format.Protocol == "" {
+		if u, err := url.Parse(f.URL); err == nil {
+			format.Protocol = u.Scheme
+		}
+	}
+	if format.HTTPHeaders == nil {
+		format.HTTPHeaders = make(map[string]string)
+	}
+	return format, nil
+}
+
+// formats return the valid formats of the video and add warning for every malformed format.
+func (v *youtubeDlVideo) formats() []Format {
+	if v.Formats == nil {
+		if v.URL == "" {
+			// Upcoming lives does not have formats until they start.
+			return []Format{}
+		}
+		format, err := v.youtubeDlFormat.toFormat()
+		if err != nil {
+			v.warnings = append(v.warnings, err.Error())
+			return []Format{}
+		}
+		return []Format{format}
+	}
+	formats := make([]Format, 0, len(v.Formats))
+	for i, data := range v.Formats {
+		var f youtubeDlFormat
+		warn, err := unmarshalObject(data, &f)
+		if err != nil {
+			v.warnings = append(v.warnings, fmt.Sprintf("format number %d: %v", i, err))
+			continue
+		}
+		if warn != "" {
+			v.warnings = append(v.warnings, fmt.Sprintf("format %s: %s", f.FormatID, warn))
+		}
+		format, err := f.toFormat()
+		if err != nil {
+			v.warnings = append(v.warnings, err.Error())
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+func (v *youtubeDlVideo) name() string {
+	if v.FullTitle != "" {
+		return v.FullTitle
+	}
+	return v.Title
+}
+
+// releaseTime return when upcoming live should start or zero time if unknown.
+func (v *youtubeDlVideo) releaseTime() time.Time {
+	if !v.ReleaseTimestamp.Valid {
+		return time.Time{}
+	}
+	return time.Unix(int64(v.ReleaseTimestamp.Value), 0)
+}
+
+// thumbnails return the valid thumbnails of the video and add warning for every malformed thumbnail.
+func (v *youtubeDlVideo) thumbnails() []Thumbnail {
+	var thumbnails []Thumbnail
+	for i, data := range v.Thumbnails {
+		var t youtubeDlThumbnail
+		warn, err := unmarshalObject(data, &t)
+		if err == nil && warn == "" && t.URL == "" {
+			err = &MalformedJSONError{Message: "thumbnail without url"}
+		}
+		if err != nil || warn != "" {
+			v.warnings = append(v.warnings, fmt.Sprintf("thumbnail number %d: %v%s", i, err, warn))
+		}
+		if err == nil && t.URL != "" {
+			thumbnails = append(thumbnails, Thumbnail{URL: t.URL, ID: t.ID, Width: t.Width.Value, Height: t.Height.Value})
+		}
+	}
+	return thumbnails
+}
+func (v *youtubeDlVideo) toVideoURL(url string) VideoUrl {
+	formats := v.formats()
+	isUpcoming := v.LiveStatus == "is_upcoming"
+	isLive := !isUpcoming && (v.IsLive || v.LiveStatus == "is_live" || (len(formats) != 0 && formats[0].Protocol == "m3u8"))
+	viewCount := int64(-1)
+	if v.ViewCount.Valid {
+		viewCount = int64(v.ViewCount.Value)
+	}
+	return VideoUrl{url: url, WebPageURL: v.WebPageURL, ID: v.ID, Name: v.name(), IsLive: isLive, IsUpcoming: isUpcoming,
+		ReleaseTime: v.releaseTime(), Formats: formats, Duration: v.Duration.Value, Uploader: v.Uploader, Channel: v.Channel,
+		UploadDate: v.UploadDate, Description: v.Description, ViewCount: viewCount, Thumbnails: v.thumbnails(), Tags: v.Tags,
+		PlaylistIndex: int(v.PlaylistIndex.Value), PlaylistTitle: v.playlistTitle(), Extractor: v.extractorKey(),
+		Chapters: v.chapters()}
+}
+func (v *youtubeDlVideo) chapters() []Chapter {
+	var chapters []Chapter
+	for _, c := range v.Chapters {
+		chapters = append(chapters, Chapter{StartTime: c.StartTime.Value, EndTime: c.EndTime.Value, Title: c.Title})
+	}
+	return chapters
+}
+func (v *youtubeDlVideo) playlistTitle() string {
+	if v.PlaylistTitle != "" {
+		return v.PlaylistTitle
+	}
+	return v.Playlist
+}
+
+// extractorKey return the key of the extractor of the video, flat playlist entries report it as ie_key. The extractor
+// name (like youtube:tab) is not used because archive lines of youtube-dl use only the key.
+func (v *youtubeDlVideo) extractorKey() string {
+	if v.ExtractorKey != "" {
+		return v.ExtractorKey
+	}
+	return v.IEKey
+}
+func (v *youtubeDlVideo) warningsOutput(videoIndex int) string {
+	var sb str.Builder
+	for _, warn := range v.warnings {
+		sb.WriteString(fmt.Sprintf("WARN IN VIDEO NUMBER: %d. %s\n", videoIndex, warn))
+	}
+	return sb.String()
+}
@@ -2,38 +2,86 @@ package vigoler
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"net/url"
+	"sort"
 	"strconv"
 	str "strings"
 	"sync"
+	"time"
 )
 
 type YoutubeDlWrapper struct {
-	app externalApp
+	app  externalApp
+	info ExtractorInfo
 }
 type Format struct {
-	url      string
-	formatID string
+	URL      string `json:"url"`
+	FormatID string `json:"format_id"`
 	// size of the file in KB or -1 if the data is not available.
-	fileSize    float64
-	Ext         string
-	hasVideo    bool
-	hasAudio    bool
-	protocol    string
-	httpHeaders map[string]string
-	height      float64
-	width       float64
+	FileSize float64 `json:"file_size"`
+	// FileSizeApprox is the size of the file in KB that the extractor estimated or 0 if the data is not available.
+	FileSizeApprox float64 `json:"file_size_approx,omitempty"`
+	Ext            string  `json:"ext"`
+	HasVideo       bool    `json:"has_video"`
+	HasAudio       bool    `json:"has_audio"`
+	Protocol       string  `json:"protocol"`
+	// HTTPHeaders may contain cookies so it is not serialized.
+	HTTPHeaders map[string]string `json:"-"`
+	// Height and Width are -1 if the data is not available.
+	Height   float64 `json:"height"`
+	Width    float64 `json:"width"`
+	Language string  `json:"language,omitempty"`
+	FPS      float64 `json:"fps,omitempty"`
+	VCodec   string  `json:"vcodec,omitempty"`
+	ACodec   string  `json:"acodec,omitempty"`
+	// TBR, ABR and VBR are the total, audio and video bitrate in KBit/s or 0 if the data is not available.
+	TBR        float64 `json:"tbr,omitempty"`
+	ABR        float64 `json:"abr,omitempty"`
+	VBR        float64 `json:"vbr,omitempty"`
+	FormatNote string  `json:"format_note,omitempty"`
+	// DynamicRange is SDR, HDR10, HLG and so on or empty if the data is not available.
+	DynamicRange string `json:"dynamic_range,omitempty"`
+}
+type Thumbnail struct {
+	URL    string  `json:"url"`
+	ID     string  `json:"id,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+}
+
+// Chapter is part of video, the times are in seconds from the start of the video.
+type Chapter struct {
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Title     string  `json:"title,omitempty"`
 }
 type VideoUrl struct {
 	url        string
-	ID         string
-	Name       string
-	IsLive     bool
-	Formats    []Format
-	WebPageURL string
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	IsLive     bool   `json:"is_live"`
+	IsUpcoming bool   `json:"is_upcoming"`
+	// ReleaseTime is the scheduled start time of upcoming live or premiere, zero if unknown.
+	ReleaseTime time.Time `json:"release_time"`
+	Formats     []Format  `json:"formats"`
+	WebPageURL  string    `json:"webpage_url"`
+	// Duration is the length of the video in seconds or 0 if the data is not available.
+	Duration float64 `json:"duration,omitempty"`
+	Uploader string  `json:"uploader,omitempty"`
+	Channel  string  `json:"channel,omitempty"`
+	// UploadDate is in the format YYYYMMDD.
+	UploadDate  string `json:"upload_date,omitempty"`
+	Description string `json:"description,omitempty"`
+	// ViewCount is -1 if the data is not available.
+	ViewCount  int64       `json:"view_count"`
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+	// PlaylistIndex is the 1 based index of the video in its playlist or 0 if the video is not from playlist.
+	PlaylistIndex int    `json:"playlist_index,omitempty"`
+	PlaylistTitle string `json:"playlist_title,omitempty"`
+	// Extractor is the key of the extractor of the site, like Youtube.
+	Extractor string    `json:"extractor,omitempty"`
+	Chapters  []Chapter `json:"chapters,omitempty"`
 }
 type HttpError struct {
 	Video        string
@@ -41,8 +89,15 @@ type HttpError struct {
 }
 type DownloadStatus func(url VideoUrl, percent, size float32)
 
+// extractorURL return the url that the video was extracted from.
+func (url VideoUrl) extractorURL() string {
+	if url.url != "" {
+		return url.url
+	}
+	return url.WebPageURL
+}
 func (format Format) String() string {
-	return fmt.Sprintf("id=%s, size=%v, height=%v, width=%v, ext=%s, protocol=%s", format.formatID, format.fileSize, format.height, format.width, format.Ext, format.protocol)
+	return fmt.Sprintf("id=%s, size=%v, height=%v, width=%v, ext=%s, protocol=%s, language=%s", format.FormatID, format.FileSize, format.Height, format.Width, format.Ext, format.Protocol, format.Language)
 }
 func (e *HttpError) Error() string {
 	return fmt.Sprintf("Http error while requested %s. error message is: %s", e.Video, e.ErrorMessage)
@@ -51,70 +106,23 @@ func (e *HttpError) Type() string {
 	return "Http error"
 }
 func CreateYoutubeDlWrapper() YoutubeDlWrapper {
-	wrapper := YoutubeDlWrapper{app: externalApp{appLocation: "youtube-dl"}}
+	wrapper := YoutubeDlWrapper{app: externalApp{appLocation: YoutubeDlExtractor}, info: ExtractorInfo{Name: YoutubeDlExtractor, Path: YoutubeDlExtractor}}
 	return wrapper
 }
-func (you *YoutubeDlWrapper) UpdateYoutubeDl() error {
+func (you *YoutubeDlWrapper) Info() ExtractorInfo {
+	return you.info
+}
+func (you *YoutubeDlWrapper) Update() error {
 	_, _, _, err := you.app.runCommand(context.Background(), false, true, true, "-U")
 	return err
 }
-func createURL(urlStr string) string {
-	newURL, _ := url.Parse(urlStr)
-	return newURL.String()
-}
-func createSingleFormat(formatMap map[string]interface{}) Format {
-	var fileSize float64
-	if formatMap["filesize"] == nil {
-		fileSize = -1
-	} else {
-		fileSize = formatMap["filesize"].(float64) / 1024
-	}
-	url := createURL(formatMap["url"].(string))
-	formatID, _ := formatMap["format_id"].(string)
-	ext := formatMap["ext"].(string)
-	hasVideo := formatMap["vcodec"] != "none"
-	hasAudio := formatMap["acodec"] != "none"
-	var w, h float64
-	if formatMap["width"] != nil {
-		w = formatMap["width"].(float64)
-		h = formatMap["height"].(float64)
-	} else {
-		w = -1
-		h = -1
-	}
-	protocol := formatMap["protocol"].(string)
-	httpHeaderMap := formatMap["http_headers"].(map[string]interface{})
-	httpHeaders := make(map[string]string)
-	for k, v := range httpHeaderMap {
-		httpHeaders[k] = v.(string)
-	}
-	return Format{fileSize: fileSize, url: url, formatID: formatID, Ext: ext, protocol: protocol, hasVideo: hasVideo, hasAudio: hasAudio, httpHeaders: httpHeaders, height: h, width: w}
-}
-func sortFormats(formats []Format) {
-	l := len(formats)
-	for i := 0; i < l-1; i++ {
-		if formats[i].width > formats[i+1].width && formats[i].height > formats[i+1].height && formats[i].hasAudio == formats[i+1].hasAudio {
-			tempFormat := formats[i]
-			formats[i] = formats[i+1]
-			formats[i+1] = tempFormat
-		}
-	}
-}
-func readFormats(dMap map[string]interface{}) []Format {
-	mapOfFormats := dMap["formats"]
-	if mapOfFormats == nil {
-		return []Format{createSingleFormat(dMap)}
-	}
-	listOfFormats := mapOfFormats.([]interface{})
-	formats := make([]Format, 0, len(listOfFormats))
-	for _, format := range listOfFormats {
-		formats = append(formats, createSingleFormat(format.(map[string]interface{})))
-	}
-	sortFormats(formats)
-	return formats
+
+// Deprecated: use Update.
+func (you *YoutubeDlWrapper) UpdateYoutubeDl() error {
+	return you.Update()
 }
-func getURLData(output *<-chan string, url string) ([]map[string]interface{}, string, error) {
-	var mapData []map[string]interface{}
+func getURLData(output *<-chan string, url string) ([]*youtubeDlVideo, string, error) {
+	var videos []*youtubeDlVideo
 	var err error
 	warnOutput := ""
 	videoIndex := 0
@@ -124,18 +132,16 @@ func getURLData(output *<-chan string, url string) ([]map[string]interface{}, st
 		hasError := str.HasPrefix(s, "ERROR")
 		warnIndex := str.Index(s, "WARNING")
 		if hasError {
-			err = errors.New(s)
+			err = classifyExtractorError(url, s)
 		}
 		if hasError || warnIndex != -1 {
 			hasWarn = true
 		} else {
-			j := []byte(s)
-			var data interface{}
-			_ = json.Unmarshal(j, &data)
-			if data == nil {
+			video, parseErr := parseYoutubeDlVideo([]byte(s))
+			if parseErr != nil {
 				hasWarn = true
 			} else {
-				mapData = append(mapData, data.(map[string]interface{}))
+				videos = append(videos, video)
 			}
 		}
 		if hasWarn {
@@ -153,34 +159,25 @@ func getURLData(output *<-chan string, url string) ([]map[string]interface{}, st
 		}
 		preWarnIndex = warnIndex
 	}
-	return mapData, warnOutput, err
-}
-func extractDataFromMap(dMap map[string]interface{}) (string, string, string, bool) {
-	const ALIVE_NAME = "is_live"
-	const TITLE_NAME = "fulltitle"
-	const ID_NAME = "id"
-	const webPageURL = "webpage_url"
-	var isAlive bool
-	if dMap[ALIVE_NAME] == nil {
-		isAlive = false
-	} else {
-		isAlive = dMap[ALIVE_NAME].(bool)
-	}
-	return dMap[ID_NAME].(string), dMap[TITLE_NAME].(string), dMap[webPageURL].(string), isAlive
+	return videos, warnOutput, err
 }
 func getUrls(output *<-chan string, url string) ([]VideoUrl, error, string) {
-	maps, warn, err := getURLData(output, url)
+	data, warn, err := getURLData(output, url)
 	var videos []VideoUrl
-	for _, dMap := range maps {
-		id, name, webPageUrl, isLive := extractDataFromMap(dMap)
-		formats := readFormats(dMap)
-		videos = append(videos, VideoUrl{url: url, WebPageURL: webPageUrl, ID: id, Name: name, IsLive: isLive || formats[0].protocol == "m3u8", Formats: formats})
+	for i, video := range data {
+		videos = append(videos, video.toVideoURL(url))
+		warn += video.warningsOutput(i)
 	}
 	return videos, err, warn
 }
 func (youdown *YoutubeDlWrapper) getMetaData(url string) (*Async, *<-chan string, error) {
 	ctx := context.Background()
-	wa, output, err := youdown.app.runCommandChan(ctx, "-i", "-j", url)
+	args := []string{"-i", "-j"}
+	if youdown.info.LiveStatus {
+		// Upcoming lives are returned with their live status and release time instead of failing without formats.
+		args = append(args, "--ignore-no-formats-error")
+	}
+	wa, output, err := youdown.app.runCommandChan(ctx, append(args, url)...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -204,15 +201,50 @@ func GetBestFormat(formats []Format, needVideo, needAudio bool) Format {
 	return GetFormatsOrder(formats, needVideo, needAudio)[0]
 }
 
-// GetFormatsOrder Return the formats in descending from the best format to the worst format.
+// GetFormatsOrder Return the formats in descending from the best format to the worst format by DefaultFormatRanking.
 // needVideo and needAudio determinate if the format contain video and audio respectively.
 // needVideo and needAudio can be both true.
 func GetFormatsOrder(formats []Format, needVideo, needAudio bool) []Format {
-	oFormats := make([]Format, 0)
-	for i := len(formats) - 1; i >= 0; i-- {
-		if formats[i].hasVideo == needVideo && formats[i].hasAudio == needAudio {
-			oFormats = append(oFormats, formats[i])
-		}
+	return DefaultFormatRanking.Order(formats, needVideo, needAudio)
+}
+
+// GetFormatsOrderLanguages Return the formats in the same order as GetFormatsOrder but formats whose language appear
+// earlier in languages come first. Formats that does not match any of the languages keep their order at the end.
+func GetFormatsOrderLanguages(formats []Format, needVideo, needAudio bool, languages []string) []Format {
+	return DefaultFormatRanking.OrderLanguages(formats, needVideo, needAudio, languages)
+}
+
+// GetAudioFormatsByLanguages Return for every language in languages the audio formats in that language ordered from the
+// best format to the worst format. Languages without any audio format are skipped.
+func GetAudioFormatsByLanguages(formats []Format, languages []string) [][]Format {
+	return DefaultFormatRanking.AudioByLanguages(formats, languages)
+}
+
+// OrderLanguages is GetFormatsOrderLanguages by r.
+func (r FormatRanking) OrderLanguages(formats []Format, needVideo, needAudio bool, languages []string) []Format {
+	oFormats := r.Order(formats, needVideo, needAudio)
+	if len(languages) != 0 {
+		sort.SliceStable(oFormats, func(i, j int) bool {
+			return languageIndex(oFormats[i].Language, languages) < languageIndex(oFormats[j].Language, languages)
+		})
 	}
 	return oFormats
 }
+
+// AudioByLanguages is GetAudioFormatsByLanguages by r.
+func (r FormatRanking) AudioByLanguages(formats []Format, languages []string) [][]Format {
+	audioFormats := r.Order(formats, false, true)
+	tracks := make([][]Format, 0, len(languages))
+	for _, language := range languages {
+		var track []Format
+		for _, format := range audioFormats {
+			if isLanguageMatch(format.Language, language) {
+				track = append(track, format)
+			}
+		}
+		if len(track) != 0 {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
@@ -1,7 +1,7 @@
 package vigoler
 
 import (
-	"errors"
+	"encoding/json"
 	"io/ioutil"
 	"reflect"
 	"strings"
@@ -10,18 +10,18 @@ import (
 
 func TestFormats(t *testing.T) {
 	formatsArray := []Format{
-		{formatID: "1", hasAudio: true, hasVideo: false},
-		{formatID: "2", hasAudio: true, hasVideo: false},
-		{formatID: "3", hasAudio: false, hasVideo: true},
-		{formatID: "4", hasAudio: false, hasVideo: true},
-		{formatID: "5", hasAudio: false, hasVideo: true},
-		{formatID: "6", hasAudio: false, hasVideo: true},
-		{formatID: "7", hasAudio: true, hasVideo: true},
-		{formatID: "8", hasAudio: true, hasVideo: true},
-		{formatID: "9", hasAudio: true, hasVideo: true},
-		{formatID: "10", hasAudio: true, hasVideo: true},
-		{formatID: "11", hasAudio: true, hasVideo: true},
-		{formatID: "12", hasAudio: true, hasVideo: true},
+		{FormatID: "1", HasAudio: true, HasVideo: false},
+		{FormatID: "2", HasAudio: true, HasVideo: false},
+		{FormatID: "3", HasAudio: false, HasVideo: true},
+		{FormatID: "4", HasAudio: false, HasVideo: true},
+		{FormatID: "5", HasAudio: false, HasVideo: true},
+		{FormatID: "6", HasAudio: false, HasVideo: true},
+		{FormatID: "7", HasAudio: true, HasVideo: true},
+		{FormatID: "8", HasAudio: true, HasVideo: true},
+		{FormatID: "9", HasAudio: true, HasVideo: true},
+		{FormatID: "10", HasAudio: true, HasVideo: true},
+		{FormatID: "11", HasAudio: true, HasVideo: true},
+		{FormatID: "12", HasAudio: true, HasVideo: true},
 	}
 	reverse := func(rFormats []Format) []Format {
 		formats := append(rFormats[:0:0], rFormats...)
@@ -80,7 +80,7 @@ func idsToFormats(ids ...string) []Format {
 	formats := make([]Format, 0, len(ids))
 	for _, id := range ids {
 		formats = append(formats, Format{
-			formatID: id,
+			FormatID: id,
 		})
 	}
 	return formats
@@ -91,7 +91,7 @@ func formatsTest(t *testing.T, expectedFormats, formats []Format, checkOnlyIds b
 	}
 	for i, f := range expectedFormats {
 		if checkOnlyIds {
-			assertString(t, "getUrls return format", formats[i].formatID, f.formatID)
+			assertString(t, "getUrls return format", formats[i].FormatID, f.FormatID)
 		} else {
 			assert(t, "getUrls return format", formats[i], f)
 		}
@@ -138,7 +138,7 @@ func Test_getUrls(t *testing.T) {
 	headersMap["Accept-Language"] = "Accept-Language"
 	headersMap["Accept-Encoding"] = "Accept-Encoding"
 	headersMap["Accept"] = "Accept"
-	vidFormat := Format{url: vidFormatURL, httpHeaders: headersMap, formatID: "0", fileSize: -1, Ext: "mp4", protocol: "https", hasVideo: true, hasAudio: true, width: -1, height: -1}
+	vidFormat := Format{URL: vidFormatURL, HTTPHeaders: headersMap, FormatID: "0", FileSize: -1, Ext: "mp4", Protocol: "https", HasVideo: true, HasAudio: true, Width: -1, Height: -1}
 	getUrlsTest(t, url, vidName, "test_files/no_formats.json", false, []Format{vidFormat}, nil, nil, false, nil)
 }
 func Test_getUrlsFormatsOrder(t *testing.T) {
@@ -151,5 +151,144 @@ func Test_getUrlsFormatsOrder(t *testing.T) {
 }
 func Test_getUrlsError(t *testing.T) {
 	url := "https://www.youtube.com/watch?v=ERROR_VIDEO"
-	getUrlsTest(t, url, "", "test_files/youtube_error_output", false, nil, nil, nil, false, errors.New("ERROR: If the owner of this video has granted you access, please sign in."))
+	getUrlsTest(t, url, "", "test_files/youtube_error_output", false, nil, nil, nil, false, &PrivateVideoError{Video: url, ErrorMessage: "ERROR: If the owner of this video has granted you access, please sign in."})
+}
+func TestFormatsLanguages(t *testing.T) {
+	formatsArray := []Format{
+		{FormatID: "1", HasAudio: true, Language: "de"},
+		{FormatID: "2", HasAudio: true, Language: "en-US"},
+		{FormatID: "3", HasAudio: true},
+		{FormatID: "4", HasAudio: true, Language: "de"},
+		{FormatID: "5", HasAudio: true, Language: "en"},
+		{FormatID: "6", HasAudio: true, Language: "fr"},
+	}
+	t.Run("GetFormatsOrderLanguages", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			languages []string
+			want      []string
+		}{
+			{"no languages", nil, []string{"6", "5", "4", "3", "2", "1"}},
+			{"one language", []string{"en"}, []string{"5", "2", "6", "4", "3", "1"}},
+			{"regional language", []string{"en-US"}, []string{"2", "6", "5", "4", "3", "1"}},
+			{"languages order", []string{"de", "en"}, []string{"4", "1", "5", "2", "6", "3"}},
+			{"missing language", []string{"es"}, []string{"6", "5", "4", "3", "2", "1"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				formatsTest(t, idsToFormats(tt.want...), GetFormatsOrderLanguages(formatsArray, false, true, tt.languages), true)
+			})
+		}
+	})
+	t.Run("GetAudioFormatsByLanguages", func(t *testing.T) {
+		tracks := GetAudioFormatsByLanguages(formatsArray, []string{"fr", "es", "de"})
+		if len(tracks) != 2 {
+			t.Fatalf("GetAudioFormatsByLanguages() number of tracks = %v, want 2", len(tracks))
+		}
+		formatsTest(t, idsToFormats("6"), tracks[0], true)
+		formatsTest(t, idsToFormats("4", "1"), tracks[1], true)
+	})
+}
+func readTestUrls(t *testing.T, url string, lines ...string) ([]VideoUrl, error, string) {
+	sChan := make(chan string)
+	go func() {
+		for _, line := range lines {
+			sChan <- line
+		}
+		close(sChan)
+	}()
+	pChan := (<-chan string)(sChan)
+	return getUrls(&pChan, url)
+}
+func Test_getUrlsUpcoming(t *testing.T) {
+	data, err := ioutil.ReadFile("test_files/upcoming_live.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	videos, err, warn := readTestUrls(t, "https://www.youtube.com/watch?v=UPCOMING_ID", string(data))
+	if err != nil || warn != "" || len(videos) != 1 {
+		t.Fatalf("getUrls() = %v, %v, %v", videos, err, warn)
+	}
+	assertBool(t, "getUrls return isUpcoming", videos[0].IsUpcoming, true)
+	assertBool(t, "getUrls return isLive", videos[0].IsLive, false)
+	assert(t, "getUrls return release time", videos[0].ReleaseTime.Unix(), int64(1893456000))
+	assert(t, "getUrls number of formats", len(videos[0].Formats), 0)
+}
+func Test_getUrlsLiveNotStarted(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=UPCOMING_ID"
+	_, err, _ := readTestUrls(t, url, "ERROR: This live event will begin in 3 hours.\n")
+	if _, ok := err.(*LiveNotStartedError); !ok {
+		t.Errorf("getUrls() error = %v, want LiveNotStartedError", err)
+	}
+}
+func readTestUrlsFile(t *testing.T, fileName string) ([]VideoUrl, error, string) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readTestUrls(t, "https://site/video", strings.Split(strings.TrimSpace(string(data)), "\n")...)
+}
+func Test_getUrlsRealWorldFormats(t *testing.T) {
+	videos, err, warn := readTestUrlsFile(t, "test_files/real_world_formats.json")
+	if err != nil || len(videos) != 2 {
+		t.Fatalf("getUrls() = %v, %v, %v", videos, err, warn)
+	}
+	video := videos[0]
+	assertString(t, "getUrls return video name", video.Name, "Real world video")
+	assertBool(t, "getUrls return isLive", video.IsLive, false)
+	formatsTest(t, idsToFormats("139", "sb0", "160", "18"), video.Formats, true)
+	assert(t, "getUrls audio format", video.Formats[0], Format{URL: "https://host/139", FormatID: "139", FileSize: 1, Ext: "m4a", HasAudio: true, ACodec: "mp4a.40.5", Protocol: "https", HTTPHeaders: map[string]string{"User-Agent": "agent"}, Width: -1, Height: -1})
+	assert(t, "getUrls format without height", [2]float64{video.Formats[1].Width, video.Formats[1].Height}, [2]float64{-1, -1})
+	assert(t, "getUrls format without size", video.Formats[2].FileSize, float64(-1))
+	assert(t, "getUrls format language", video.Formats[3].Language, "en")
+	videoFormat := video.Formats[2]
+	if videoFormat.FPS != 30 || videoFormat.TBR != 100.5 || videoFormat.VBR != 100.5 || videoFormat.ABR != 0 || videoFormat.VCodec != "avc1.4d400c" || videoFormat.ACodec != "" || videoFormat.FormatNote != "144p" || videoFormat.DynamicRange != "SDR" {
+		t.Errorf("getUrls() video format = %+v", videoFormat)
+	}
+	assert(t, "getUrls metadata", []interface{}{video.Duration, video.Uploader, video.Channel, video.UploadDate, video.Description, video.ViewCount, video.Tags},
+		[]interface{}{float64(212), "Uploader", "Channel", "20200501", "Line one\nLine two", int64(1500), []string{"music", "live"}})
+	assert(t, "getUrls chapters", video.Chapters, []Chapter{{StartTime: 0, EndTime: 100, Title: "Intro"}, {StartTime: 100, EndTime: 212, Title: "Song"}})
+	assert(t, "getUrls thumbnails", video.Thumbnails, []Thumbnail{{URL: "https://host/thumb.jpg", ID: "0", Width: 120, Height: 90}})
+	if !strings.Contains(warn, "format broken without url") || !strings.Contains(warn, "format number 5") || !strings.Contains(warn, "thumbnail number 1") {
+		t.Errorf("getUrls() warning = %v", warn)
+	}
+	hls := videos[1]
+	assertString(t, "getUrls return video name", hls.Name, "Single hls format")
+	assertBool(t, "getUrls return isLive", hls.IsLive, true)
+	assert(t, "getUrls unknown view count", hls.ViewCount, int64(-1))
+	formatsTest(t, []Format{{URL: "https://host/live/index.m3u8", Ext: "mp4", Protocol: "m3u8", FileSize: -1, Width: -1, Height: -1, HasVideo: true, HasAudio: true, HTTPHeaders: map[string]string{}}}, hls.Formats, false)
+}
+func Test_getUrlsMalformed(t *testing.T) {
+	videos, err, warn := readTestUrlsFile(t, "test_files/malformed_types.json")
+	if err != nil || len(videos) != 1 {
+		t.Fatalf("getUrls() = %v, %v, %v", videos, err, warn)
+	}
+	video := videos[0]
+	assertString(t, "getUrls return video name", video.Name, "Wrong types")
+	assertBool(t, "getUrls return isLive", video.IsLive, false)
+	assertBool(t, "getUrls return release time", video.ReleaseTime.IsZero(), true)
+	if len(video.Formats) != 1 || video.Formats[0].URL != "https://host/1" || video.Formats[0].Width != -1 || video.Formats[0].FileSize != -1 {
+		t.Errorf("getUrls() formats = %v", video.Formats)
+	}
+	for _, want := range []string{"WARN IN VIDEO NUMBER: 0. json: cannot unmarshal", "Numeric id", "Without id", "TRUNCATED_ID"} {
+		if !strings.Contains(warn, want) {
+			t.Errorf("getUrls() warning = %v, want to contain %v", warn, want)
+		}
+	}
+}
+func TestVideoUrlJSON(t *testing.T) {
+	video := VideoUrl{ID: "id", Name: "name", Formats: []Format{{URL: "https://host/1", FormatID: "1", FileSize: -1, HTTPHeaders: map[string]string{"Cookie": "secret"}, Height: 720, Width: 1280, FPS: 60}}, ViewCount: -1}
+	data, err := json.Marshal(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("json.Marshal() contain http headers %s", data)
+	}
+	var decoded VideoUrl
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	video.Formats[0].HTTPHeaders = nil
+	assert(t, "json.Unmarshal()", decoded, video)
 }